@@ -160,7 +160,7 @@ func (v *AbstractFileSystem) Invoke(ctx context.Context, endpoint string, args .
 	if v == nil || v.FInvoke == nil {
 		return nil, NewENOSYS("Invoke not supported", v)
 	}
-	return v.FInvoke(ctx, endpoint, args)
+	return v.FInvoke(ctx, endpoint, args...)
 }
 
 func (v *AbstractFileSystem) MkBucket(ctx context.Context, path string, options interface{}) error {
@@ -241,6 +241,24 @@ func (a *DefaultEntry) Size() int64 {
 	return a.Length
 }
 
+// readEntryInto copies the entry into args, which is either nil, *DefaultEntry or map[string]interface{}, just
+// like the DefaultResultSet does.
+func readEntryInto(entry *DefaultEntry, args interface{}) Entry {
+	switch t := args.(type) {
+	case *DefaultEntry:
+		*t = *entry
+		return t
+	case map[string]interface{}:
+		t[mapEntryName] = entry.Id
+		t[mapEntrySize] = entry.Size()
+		t[mapEntryIsDir] = entry.IsBucket
+		t[mapEntrySys] = entry.Data
+		return AbsMapEntry(t)
+	default:
+		return entry
+	}
+}
+
 // DefaultResultSet is a minimal type, useful to create simple VFS implementation. However you should usually
 // provide a custom implementation to give access to the raw data (see #Sys()), e.g. the original parsed
 // JSON data structures.
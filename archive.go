@@ -0,0 +1,247 @@
+package vfs
+
+import (
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// an archiveNode is an entry of an archiveIndex. Buckets have non-nil children and symbolic links a non-empty
+// link. The payload is provider specific, e.g. the *zip.File of an entry.
+type archiveNode struct {
+	entry    *DefaultEntry
+	children map[string]*archiveNode
+	link     string
+	payload  interface{}
+}
+
+// an archiveIndex is the tree of the flat entry list of an archive format. Archives usually do not contain
+// entries for all parent directories, so missing buckets are synthesized with a nil Data payload. It is not
+// thread safe.
+type archiveIndex struct {
+	root *archiveNode
+}
+
+func newArchiveIndex() *archiveIndex {
+	return &archiveIndex{root: &archiveNode{entry: &DefaultEntry{IsBucket: true}, children: make(map[string]*archiveNode)}}
+}
+
+// put inserts or replaces the entry at the given path and creates all missing parents. The Id of the entry is
+// set to the name of the path. A bucket which replaces an existing bucket keeps its children.
+func (a *archiveIndex) put(path Path, entry *DefaultEntry, payload interface{}) *archiveNode {
+	names := path.Names()
+	if len(names) == 0 {
+		a.root.entry.Data = entry.Data
+		a.root.payload = payload
+		return a.root
+	}
+	node := a.root
+	for _, name := range names[:len(names)-1] {
+		child := node.children[name]
+		if child == nil || child.children == nil {
+			// a missing parent or a blob, which is shadowed by a bucket of the same name
+			child = &archiveNode{entry: &DefaultEntry{Id: name, IsBucket: true}, children: make(map[string]*archiveNode)}
+			node.children[name] = child
+		}
+		node = child
+	}
+
+	name := names[len(names)-1]
+	entry.Id = name
+	if existing := node.children[name]; existing != nil && existing.children != nil && entry.IsBucket {
+		existing.entry = entry
+		existing.payload = payload
+		return existing
+	}
+	child := &archiveNode{entry: entry, payload: payload}
+	if entry.IsBucket {
+		child.children = make(map[string]*archiveNode)
+	}
+	node.children[name] = child
+	return child
+}
+
+// get returns the node of the path or ENOENT. Symbolic links are resolved in all segments, relative targets
+// are resolved against the parent of the link.
+func (a *archiveIndex) get(path Path) (*archiveNode, error) {
+	names := path.Names()
+	node := a.root
+	resolved := Path("")
+	links := 0
+	for i := 0; i < len(names); i++ {
+		if node.children == nil {
+			return nil, &DefaultError{Message: "not a bucket: " + resolved.String(), Code: ENOTDIR, DetailsPayload: []string{path.String()}}
+		}
+		child := node.children[names[i]]
+		if child == nil {
+			return nil, &DefaultError{Message: path.String(), Code: ENOENT, DetailsPayload: []string{path.String()}}
+		}
+		if len(child.link) > 0 {
+			links++
+			if links > maxSymLinks {
+				return nil, &DefaultError{Message: path.String(), Code: ELOOP, DetailsPayload: []string{path.String()}}
+			}
+			target := Path(child.link).Resolve(resolved)
+			names = append(target.Names(), names[i+1:]...)
+			node = a.root
+			resolved = ""
+			i = -1
+			continue
+		}
+		node = child
+		resolved = resolved.Child(names[i])
+	}
+	return node, nil
+}
+
+// list returns copies of all child entries sorted by name. Returns ENOENT if the path is not a bucket.
+func (a *archiveIndex) list(path Path) (*DefaultResultSet, error) {
+	node, err := a.get(path)
+	if err != nil {
+		return nil, err
+	}
+	if node.children == nil {
+		return nil, &DefaultError{Message: "not a bucket: " + path.String(), Code: ENOENT, DetailsPayload: []string{path.String()}}
+	}
+	names := make([]string, 0, len(node.children))
+	for name := range node.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	entries := make([]*DefaultEntry, len(names))
+	for i, name := range names {
+		entry := *node.children[name].entry
+		entries[i] = &entry
+	}
+	return &DefaultResultSet{Entries: entries}, nil
+}
+
+// checkParents returns ENOTDIR if any parent of the path is a blob.
+func (a *archiveIndex) checkParents(path Path) error {
+	node := a.root
+	names := path.Names()
+	for i, name := range names {
+		if node.children == nil {
+			return &DefaultError{Message: "not a bucket: " + Path(strings.Join(names[:i], "/")).String(), Code: ENOTDIR, DetailsPayload: []string{path.String()}}
+		}
+		node = node.children[name]
+		if node == nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+//==
+
+// An archiveBlob reads an entry of an archive. If a section is available, it provides random access, otherwise
+// the entry is read as a stream and Seek is emulated by skipping forward or reopening.
+type archiveBlob struct {
+	open    func() (io.ReadCloser, error)
+	size    int64
+	section *io.SectionReader
+	lock    sync.Mutex // guards rc, pos and the section position
+	rc      io.ReadCloser
+	pos     int64
+	closed  int32
+}
+
+func (b *archiveBlob) isClosed() bool {
+	return atomic.LoadInt32(&b.closed) == 1
+}
+
+func (b *archiveBlob) ReadAt(p []byte, off int64) (n int, err error) {
+	if b.isClosed() {
+		return 0, &DefaultError{Message: "blob already closed", Code: EBADF}
+	}
+	if b.section == nil {
+		return 0, NewENOSYS("ReadAt of compressed entries not supported", b)
+	}
+	return b.section.ReadAt(p, off)
+}
+
+func (b *archiveBlob) Read(p []byte) (n int, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.isClosed() {
+		return 0, &DefaultError{Message: "blob already closed", Code: EBADF}
+	}
+	if b.section != nil {
+		return b.section.Read(p)
+	}
+	if b.rc == nil {
+		rc, err := b.open()
+		if err != nil {
+			return 0, err
+		}
+		if _, err := io.CopyN(ioutil.Discard, rc, b.pos); err != nil {
+			_ = rc.Close()
+			return 0, err
+		}
+		b.rc = rc
+	}
+	n, err = b.rc.Read(p)
+	b.pos += int64(n)
+	return n, err
+}
+
+func (b *archiveBlob) WriteAt(p []byte, off int64) (n int, err error) {
+	return 0, &DefaultError{Message: "archive opened in read mode", Code: EROFS}
+}
+
+func (b *archiveBlob) Write(p []byte) (n int, err error) {
+	return 0, &DefaultError{Message: "archive opened in read mode", Code: EROFS}
+}
+
+// Seek is cheap with a section. Otherwise the stream is skipped forward or reopened.
+func (b *archiveBlob) Seek(offset int64, whence int) (int64, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.isClosed() {
+		return 0, &DefaultError{Message: "blob already closed", Code: EBADF}
+	}
+	if b.section != nil {
+		return b.section.Seek(offset, whence)
+	}
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = b.pos + offset
+	case io.SeekEnd:
+		abs = b.size + offset
+	default:
+		return 0, &DefaultError{Message: "invalid whence", Code: EINVAL}
+	}
+	if abs < 0 {
+		return 0, &DefaultError{Message: "negative position", Code: EINVAL}
+	}
+	if b.rc != nil && abs != b.pos {
+		if abs < b.pos {
+			_ = b.rc.Close()
+			b.rc = nil
+		} else if _, err := io.CopyN(ioutil.Discard, b.rc, abs-b.pos); err != nil {
+			// e.g. beyond the end, the next read will tell
+			_ = b.rc.Close()
+			b.rc = nil
+		}
+	}
+	b.pos = abs
+	return abs, nil
+}
+
+func (b *archiveBlob) Close() error {
+	atomic.StoreInt32(&b.closed, 1)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.rc != nil {
+		err := b.rc.Close()
+		b.rc = nil
+		return err
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
 )
 
 type Fields = map[string]interface{}
@@ -31,6 +32,13 @@ const EventBeforeReadAttrs = "BeforeReadAttrs"
 const EventBeforeSymLink = "BeforeSymLink"
 const EventBeforeHardLink = "BeforeHardLink"
 const EventBeforeMkBucket = "BeforeMkBucket"
+const EventBeforeRename = "BeforeRename"
+const EventBeforeRefLink = "BeforeRefLink"
+const EventBeforeWriteAttrs = "BeforeWriteAttrs"
+
+// EventMount and EventUnmount are fired by the MountableFileSystem after a mount point has been changed
+const EventMount = "Mount"
+const EventUnmount = "Unmount"
 
 // The Builder is used to create a VFS from scratch in a simpler way. A list of included batteries:
 //
@@ -39,124 +47,133 @@ const EventBeforeMkBucket = "BeforeMkBucket"
 //   * Optimized reads in ReadAttrs if args is map[string]interface{}
 //   * Each undefined method will return ENOSYS error
 //   * Listeners can be used to intercept operations (before semantic)
+//
+// All closures only capture the state of the current configuration and never the Builder itself, so that
+// the created FileSystem is not affected when the Builder is reset or reused.
 type Builder struct {
 	vfs               *AbstractFileSystem
 	buckets           []*BucketBuilder
 	blobs             []*BlobBuilder
 	fallbackDelete    func(ctx context.Context, path string) error
 	fallbackReadAttrs func(ctx context.Context, path string, options interface{}) (Entry, error)
-	listeners         map[int]*builderPathListener
-	lastHandle        int
-}
-
-func (b *Builder) nextHandle() int {
-	b.lastHandle++
-	return b.lastHandle
-}
-
-func (b *Builder) debugName() string {
-	return b.vfs.String()
 }
 
 func (b *Builder) ensureInit() {
 	if b.vfs == nil {
 		b.vfs = &AbstractFileSystem{}
-		b.listeners = make(map[int]*builderPathListener)
+		vfs := b.vfs
+		debugName := func() string {
+			return vfs.String()
+		}
+		listeners := make(map[int]*builderPathListener)
+		lastHandle := 0
+		lock := &sync.Mutex{}
+
 		b.vfs.FConnect = func(ctx context.Context, options interface{}) (interface{}, error) {
-			return nil,NewENOSYS("Connect not supported", b.debugName())
+			return nil, NewENOSYS("Connect not supported", debugName())
 		}
 		b.vfs.FClose = func() error {
 			return nil // intentionally always no-op
 		}
 
 		b.vfs.FDisconnect = func(ctx context.Context) error {
-			return NewENOSYS("Disconnect not supported", b.debugName())
+			return NewENOSYS("Disconnect not supported", debugName())
 		}
 
 		b.vfs.FRemoveListener = func(ctx context.Context, handle int) error {
-			delete(b.listeners, handle)
+			lock.Lock()
+			defer lock.Unlock()
+			delete(listeners, handle)
 			return nil
 		}
 
 		b.vfs.FAddListener = func(ctx context.Context, path string, listener ResourceListener) (hnd int, err error) {
-			hnd = b.nextHandle()
-			b.listeners[hnd] = &builderPathListener{
+			lock.Lock()
+			defer lock.Unlock()
+			lastHandle++
+			listeners[lastHandle] = &builderPathListener{
 				path:     Path(path),
 				listener: listener,
 			}
-			return hnd, nil
+			return lastHandle, nil
 		}
 
 		b.vfs.FFireEvent = func(ctx context.Context, path string, event interface{}) error {
-			for _, listener := range b.listeners {
+			lock.Lock()
+			matching := make([]ResourceListener, 0, len(listeners))
+			for _, listener := range listeners {
 				if listener.matches(path) {
-					err := listener.listener.OnEvent(path, event)
-					if err != nil {
-						return err
-					}
+					matching = append(matching, listener.listener)
+				}
+			}
+			lock.Unlock()
+			for _, listener := range matching {
+				err := listener.OnEvent(path, event)
+				if err != nil {
+					return err
 				}
 			}
 			return nil
 		}
 
 		b.vfs.FBegin = func(ctx context.Context, options interface{}) (i context.Context, e error) {
-			return nil, NewENOSYS("Begin transaction not supported", b.debugName())
+			return nil, NewENOSYS("Begin transaction not supported", debugName())
 		}
 
 		b.vfs.FCommit = func(ctx context.Context) error {
-			return NewENOSYS("Commit transaction not supported", b.debugName())
+			return NewENOSYS("Commit transaction not supported", debugName())
 		}
 
 		b.vfs.FRollback = func(ctx context.Context) error {
-			return NewENOSYS("Rollback transaction not supported", b.debugName())
+			return NewENOSYS("Rollback transaction not supported", debugName())
 		}
 
 		b.vfs.FOpen = func(ctx context.Context, path string, flag int, options interface{}) (blob Blob, e error) {
-			return nil, NewENOSYS("Open not supported", b.debugName())
+			return nil, NewENOSYS("Open not supported", debugName())
 		}
 
 		b.vfs.FDelete = func(ctx context.Context, path string) error {
-			return NewENOSYS("Delete not supported", b.debugName())
+			return NewENOSYS("Delete not supported", debugName())
 		}
 
 		b.vfs.FReadAttrs = func(ctx context.Context, path string, options interface{}) (Entry, error) {
-			return nil, NewENOSYS("ReadAttrs not supported", b.debugName())
+			return nil, NewENOSYS("ReadAttrs not supported", debugName())
 		}
 
 		b.vfs.FReadForks = func(ctx context.Context, path string) (strings []string, e error) {
-			return nil, NewENOSYS("ReadForks not supported", b.debugName())
+			return nil, NewENOSYS("ReadForks not supported", debugName())
 		}
 
 		b.vfs.FWriteAttrs = func(ctx context.Context, path string, src interface{}) (Entry, error) {
-			return nil, NewENOSYS("WriteAttrs not supported", b.debugName())
+			return nil, NewENOSYS("WriteAttrs not supported", debugName())
 		}
 
 		b.vfs.FReadBucket = func(ctx context.Context, path string, options interface{}) (set ResultSet, e error) {
-			return nil, NewENOSYS("ReadBucket not supported", b.debugName())
+			return nil, NewENOSYS("ReadBucket not supported", debugName())
 		}
 
 		b.vfs.FInvoke = func(ctx context.Context, endpoint string, args ...interface{}) (i interface{}, e error) {
-			return nil, NewENOSYS("Invoke not supported", b.debugName())
+			return nil, NewENOSYS("Invoke not supported", debugName())
 		}
 
 		b.vfs.FMkBucket = func(ctx context.Context, path string, options interface{}) error {
-			return NewENOSYS("MkBucket not supported", b.debugName())
+			return NewENOSYS("MkBucket not supported", debugName())
 		}
 
 		b.vfs.FRename = func(ctx context.Context, oldPath string, newPath string) error {
-			return NewENOSYS("Rename not supported", b.debugName())
+			return NewENOSYS("Rename not supported", debugName())
 		}
 
 		b.vfs.FSymLink = func(ctx context.Context, oldPath string, newPath string) error {
-			return NewENOSYS("SymLink not supported", b.debugName())
+			return NewENOSYS("SymLink not supported", debugName())
 		}
 
 		b.vfs.FHardLink = func(ctx context.Context, oldPath string, newPath string) error {
-			return NewENOSYS("HardLink not supported", b.debugName())
+			return NewENOSYS("HardLink not supported", debugName())
 		}
 
 		b.vfs.FRefLink = func(ctx context.Context, oldPath string, newPath string) error {
-			return NewENOSYS("RefLink not supported", b.debugName())
+			return NewENOSYS("RefLink not supported", debugName())
 		}
 		b.vfs.FString = func() string {
 			return "AbstractVirtualFilesystem"
@@ -164,84 +181,100 @@ func (b *Builder) ensureInit() {
 	}
 }
 
+// Create returns the configured FileSystem and resets the Builder.
 func (b *Builder) Create() FileSystem {
+	b.ensureInit()
+	vfs := b.vfs
 	buckets := b.buckets
 	blobs := b.blobs
+	fallbackDelete := b.fallbackDelete
+	fallbackReadAttrs := b.fallbackReadAttrs
 
 	// open blobs behavior
 	if len(blobs) > 0 {
-		b.vfs.FOpen = func(ctx context.Context, path string, flag int, options interface{}) (blob Blob, e error) {
-			err := b.vfs.FireEvent(ctx, path, EventBeforeOpen)
+		vfs.FOpen = func(ctx context.Context, path string, flag int, options interface{}) (blob Blob, e error) {
+			err := vfs.FireEvent(ctx, path, EventBeforeOpen)
 			if err != nil {
 				return nil, err
 			}
 			for _, blob := range blobs {
 				for _, matcher := range blob.matchPatterns {
-					if matcher.isMatching(Path(path)) {
-						if blob.open != nil {
-							return blob.open(ctx, path, flag, options)
-						}
-
-						if flag == os.O_RDONLY && blob.reader != nil {
-							return blob.reader(ctx, path, flag, options)
-						}
-
-						if flag != os.O_RDONLY && blob.writer != nil {
-							return blob.writer(ctx, path, flag, options)
-						}
+					rctx, ok := matcher.match(ctx, Path(path), flag, options)
+					if !ok {
+						continue
+					}
+					if blob.open != nil {
+						return blob.open(rctx, flag, options)
+					}
+
+					if flag == os.O_RDONLY && blob.reader != nil {
+						return blob.reader(rctx, flag, options)
+					}
+
+					if flag != os.O_RDONLY && blob.writer != nil {
+						return blob.writer(rctx, flag, options)
 					}
 				}
 			}
 
 			// no matching blob found
-			return nil, &DefaultError{Message: "unmatched blob: " + path, Code: ENOENT, DetailsPayload: Path(path)}
+			return nil, NewErr().NotFound("unmatched blob: "+path, path)
 		}
 	}
 
 	// ReadBuckets behavior
 	if len(buckets) > 0 {
-		b.vfs.FReadBucket = func(ctx context.Context, path string, options interface{}) (set ResultSet, e error) {
-			err := b.vfs.FireEvent(ctx, path, EventBeforeBucketRead)
+		vfs.FReadBucket = func(ctx context.Context, path string, options interface{}) (set ResultSet, e error) {
+			err := vfs.FireEvent(ctx, path, EventBeforeBucketRead)
 			if err != nil {
 				return nil, err
 			}
 			for _, bucket := range buckets {
+				if bucket.onRead == nil {
+					continue
+				}
 				for _, matcher := range bucket.matchPatterns {
-					if matcher.isMatching(Path(path)) {
-						return bucket.onRead(ctx, path, options)
+					if rctx, ok := matcher.match(ctx, Path(path), options); ok {
+						return bucket.onRead(rctx, options)
 					}
 				}
 			}
 			// no matching bucket found
-			return nil, &DefaultError{Message: "unmatched bucket: " + path, Code: ENOENT, DetailsPayload: Path(path)}
+			return nil, NewErr().NotFound("unmatched bucket: "+path, path)
 		}
 	}
 
 	// Mixed behavior
 	if len(buckets) > 0 || len(blobs) > 0 {
 		// delete
-		b.vfs.FDelete = func(ctx context.Context, path string) error {
-			err := b.vfs.FireEvent(ctx, path, EventBeforeDelete)
+		vfs.FDelete = func(ctx context.Context, path string) error {
+			err := vfs.FireEvent(ctx, path, EventBeforeDelete)
 			if err != nil {
 				return err
 			}
 			for _, bucket := range buckets {
+				if bucket.delete == nil {
+					continue
+				}
 				for _, matcher := range bucket.matchPatterns {
-					if bucket.delete != nil && matcher.isMatching(Path(path)) {
-						return bucket.delete(ctx, path)
+					if rctx, ok := matcher.match(ctx, Path(path)); ok {
+						return bucket.delete(rctx)
 					}
 				}
 			}
 
 			for _, blob := range blobs {
+				if blob.delete == nil {
+					continue
+				}
 				for _, matcher := range blob.matchPatterns {
-					if blob.delete != nil && matcher.isMatching(Path(path)) {
-						return blob.delete(ctx, path)
+					if rctx, ok := matcher.match(ctx, Path(path)); ok {
+						return blob.delete(rctx)
 					}
 				}
 			}
-			if b.fallbackDelete != nil {
-				return b.fallbackDelete(ctx, path)
+			if fallbackDelete != nil {
+				return fallbackDelete(ctx, path)
 			}
 
 			// no matching bucket found, this is not an error by spec, because the resource is absent anyway
@@ -249,8 +282,8 @@ func (b *Builder) Create() FileSystem {
 		}
 
 		// read attributes
-		b.vfs.FReadAttrs = func(ctx context.Context, path string, options interface{}) (Entry, error) {
-			err := b.vfs.FireEvent(ctx, path, EventBeforeReadAttrs)
+		vfs.FReadAttrs = func(ctx context.Context, path string, options interface{}) (Entry, error) {
+			err := vfs.FireEvent(ctx, path, EventBeforeReadAttrs)
 			if err != nil {
 				return nil, err
 			}
@@ -269,26 +302,27 @@ func (b *Builder) Create() FileSystem {
 					}
 				}
 			}*/
-			if b.fallbackReadAttrs != nil {
-				return b.fallbackReadAttrs(ctx, path, options)
+			if fallbackReadAttrs != nil {
+				return fallbackReadAttrs(ctx, path, options)
 			}
 
 			// no matching bucket found, this is not an error by spec, because the resource is absent anyway
-			return nil, &DefaultError{Message: "ReadAttrs: " + path, Code: ENOENT, DetailsPayload: Path(path)}
+			return nil, NewErr().NotFound("ReadAttrs: "+path, path)
 		}
 
 	}
 
 	// clear this builder, to avoid inconsistent vfs instances, if developer reuses the builder
-	vfs := b.vfs
 	b.Reset()
 
 	return vfs
 }
 
 func (b *Builder) Symlink(f func(ctx context.Context, oldPath Path, newPath Path) error) *Builder {
-	b.vfs.FSymLink = func(ctx context.Context, oldPath string, newPath string) error {
-		err := b.vfs.FireEvent(ctx, oldPath+string(filepath.ListSeparator)+oldPath, EventBeforeSymLink)
+	b.ensureInit()
+	vfs := b.vfs
+	vfs.FSymLink = func(ctx context.Context, oldPath string, newPath string) error {
+		err := vfs.FireEvent(ctx, oldPath+string(filepath.ListSeparator)+oldPath, EventBeforeSymLink)
 		if err != nil {
 			return err
 		}
@@ -298,8 +332,23 @@ func (b *Builder) Symlink(f func(ctx context.Context, oldPath Path, newPath Path
 }
 
 func (b *Builder) Hardlink(f func(ctx context.Context, oldPath Path, newPath Path) error) *Builder {
-	b.vfs.FHardLink = func(ctx context.Context, oldPath string, newPath string) error {
-		err := b.vfs.FireEvent(ctx, oldPath+string(filepath.ListSeparator)+oldPath, EventBeforeHardLink)
+	b.ensureInit()
+	vfs := b.vfs
+	vfs.FHardLink = func(ctx context.Context, oldPath string, newPath string) error {
+		err := vfs.FireEvent(ctx, oldPath+string(filepath.ListSeparator)+oldPath, EventBeforeHardLink)
+		if err != nil {
+			return err
+		}
+		return f(ctx, Path(oldPath), Path(newPath))
+	}
+	return b
+}
+
+func (b *Builder) Rename(f func(ctx context.Context, oldPath Path, newPath Path) error) *Builder {
+	b.ensureInit()
+	vfs := b.vfs
+	vfs.FRename = func(ctx context.Context, oldPath string, newPath string) error {
+		err := vfs.FireEvent(ctx, oldPath, EventBeforeRename)
 		if err != nil {
 			return err
 		}
@@ -310,6 +359,7 @@ func (b *Builder) Hardlink(f func(ctx context.Context, oldPath Path, newPath Pat
 
 // Delete has lowest priority, after all blob and bucket matches have been checked
 func (b *Builder) Delete(f func(ctx context.Context, path Path) error) *Builder {
+	b.ensureInit()
 	b.fallbackDelete = func(_ctx context.Context, _path string) error {
 		return f(_ctx, Path(_path))
 	}
@@ -317,8 +367,10 @@ func (b *Builder) Delete(f func(ctx context.Context, path Path) error) *Builder
 }
 
 func (b *Builder) MkBucket(f func(ctx context.Context, path Path, options interface{}) error) *Builder {
-	b.vfs.FMkBucket = func(ctx context.Context, path string, options interface{}) error {
-		err := b.vfs.FireEvent(ctx, path, EventBeforeMkBucket)
+	b.ensureInit()
+	vfs := b.vfs
+	vfs.FMkBucket = func(ctx context.Context, path string, options interface{}) error {
+		err := vfs.FireEvent(ctx, path, EventBeforeMkBucket)
 		if err != nil {
 			return err
 		}
@@ -328,7 +380,9 @@ func (b *Builder) MkBucket(f func(ctx context.Context, path Path, options interf
 }
 
 func (b *Builder) ReadEntryAttrs(f func(ctx context.Context, path Path, dst *DefaultEntry) error) *Builder {
-	b.fallbackReadAttrs = func(_ctx context.Context, _path string, _dst interface{}) (Entry, error) {
+	b.ensureInit()
+	var readAttrs func(_ctx context.Context, _path string, _dst interface{}) (Entry, error)
+	readAttrs = func(_ctx context.Context, _path string, _dst interface{}) (Entry, error) {
 		switch t := _dst.(type) {
 		case *DefaultEntry:
 			return t, f(_ctx, Path(_path), t)
@@ -344,9 +398,10 @@ func (b *Builder) ReadEntryAttrs(f func(ctx context.Context, path Path, dst *Def
 			t[mapEntrySys] = tmp.Data
 			return AbsMapEntry(t), nil
 		default:
-			return b.fallbackReadAttrs(_ctx, _path, make(map[string]interface{}))
+			return readAttrs(_ctx, _path, make(map[string]interface{}))
 		}
 	}
+	b.fallbackReadAttrs = readAttrs
 	return b
 }
 
@@ -357,7 +412,6 @@ func (b *Builder) Reset() {
 	b.blobs = nil
 	b.fallbackReadAttrs = nil
 	b.fallbackDelete = nil
-	b.listeners = nil
 }
 
 // Details sets the name of the VFS
@@ -369,12 +423,18 @@ func (b *Builder) Details(name string, majorVersion int, minorVersion int, micro
 	return b
 }
 
+// MatchBucket starts the configuration of a bucket (directory) behavior. The pattern supports the same
+// syntax as Router#Match().
 func (b *Builder) MatchBucket(pattern string) *BucketBuilder {
+	b.ensureInit()
 	builder := &BucketBuilder{parent: b}
 	return builder.MatchAlso(pattern)
 }
 
+// MatchBlob starts the configuration of a blob (file) behavior. The pattern supports the same
+// syntax as Router#Match().
 func (b *Builder) MatchBlob(pattern string) *BlobBuilder {
+	b.ensureInit()
 	builder := &BlobBuilder{parent: b}
 	return builder.MatchAlso(pattern)
 }
@@ -384,24 +444,25 @@ func (b *Builder) MatchBlob(pattern string) *BlobBuilder {
 type BlobBuilder struct {
 	parent        *Builder
 	matchPatterns []*pathMatcher
-	reader        func(ctx context.Context, path string, flag int, perm interface{}) (Blob, error)
-	writer        func(ctx context.Context, path string, flag int, perm interface{}) (Blob, error)
-	open          func(ctx context.Context, path string, flag int, perm interface{}) (Blob, error)
-	delete        func(ctx context.Context, path string) error
+	reader        func(ctx RoutingContext, flag int, perm interface{}) (Blob, error)
+	writer        func(ctx RoutingContext, flag int, perm interface{}) (Blob, error)
+	open          func(ctx RoutingContext, flag int, perm interface{}) (Blob, error)
+	delete        func(ctx RoutingContext) error
 }
 
-func (b *BlobBuilder) OnOpen(open func(context.Context, Path, int, interface{}) (Blob, error)) *BlobBuilder {
-	b.open = func(ctx context.Context, path string, flag int, perm interface{}) (blob Blob, e error) {
-		return open(ctx, Path(path), flag, perm)
-	}
+// OnOpen configures the generic call to Open. The RoutingContext provides the path, the values of the named
+// variables of the matching pattern and the flag and options as arguments.
+func (b *BlobBuilder) OnOpen(open func(ctx RoutingContext, flag int, options interface{}) (Blob, error)) *BlobBuilder {
+	b.open = open
 	b.reader = nil
 	b.writer = nil
 	return b
 }
 
-func (b *BlobBuilder) OnRead(open func(context.Context, Path) (io.Reader, error)) *BlobBuilder {
-	b.reader = func(ctx context.Context, path string, flag int, perm interface{}) (blob Blob, e error) {
-		reader, err := open(ctx, Path(path))
+// OnRead configures the call to Open for os.O_RDONLY.
+func (b *BlobBuilder) OnRead(open func(ctx RoutingContext) (io.Reader, error)) *BlobBuilder {
+	b.reader = func(ctx RoutingContext, flag int, perm interface{}) (blob Blob, e error) {
+		reader, err := open(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -411,9 +472,10 @@ func (b *BlobBuilder) OnRead(open func(context.Context, Path) (io.Reader, error)
 	return b
 }
 
-func (b *BlobBuilder) OnWrite(open func(context.Context, Path) (io.Writer, error)) *BlobBuilder {
-	b.writer = func(ctx context.Context, path string, flag int, perm interface{}) (blob Blob, e error) {
-		writer, err := open(ctx, Path(path))
+// OnWrite configures the call to Open for any flag other than os.O_RDONLY.
+func (b *BlobBuilder) OnWrite(open func(ctx RoutingContext) (io.Writer, error)) *BlobBuilder {
+	b.writer = func(ctx RoutingContext, flag int, perm interface{}) (blob Blob, e error) {
+		writer, err := open(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -423,16 +485,16 @@ func (b *BlobBuilder) OnWrite(open func(context.Context, Path) (io.Writer, error
 	return b
 }
 
-func (b *BlobBuilder) OnDelete(delete func(context.Context, Path) error) *BlobBuilder {
-	b.delete = func(ctx context.Context, path string) error {
-		return delete(ctx, Path(path))
-	}
+// OnDelete configures the call to Delete. The RoutingContext provides the path and the values of the named
+// variables of the matching pattern.
+func (b *BlobBuilder) OnDelete(delete func(ctx RoutingContext) error) *BlobBuilder {
+	b.delete = delete
 	return b
 }
 
 // Match defines a pattern which is matched against a path and applies the defined data transformation rules
 func (b *BlobBuilder) MatchAlso(pattern string) *BlobBuilder {
-	b.matchPatterns = append(b.matchPatterns, &pathMatcher{})
+	b.matchPatterns = append(b.matchPatterns, newPathMatcher(pattern))
 	return b
 }
 
@@ -446,29 +508,30 @@ func (b *BlobBuilder) Add() *Builder {
 type BucketBuilder struct {
 	parent        *Builder
 	matchPatterns []*pathMatcher
-	onRead        func(ctx context.Context, path string, options interface{}) (ResultSet, error)
-	delete        func(ctx context.Context, path string) error
+	onRead        func(ctx RoutingContext, options interface{}) (ResultSet, error)
+	delete        func(ctx RoutingContext) error
 }
 
-func (b *BucketBuilder) OnDelete(delete func(context.Context, Path) error) *BucketBuilder {
-	b.delete = func(ctx context.Context, path string) error {
-		return delete(ctx, Path(path))
-	}
+// OnDelete configures the call to Delete. The RoutingContext provides the path and the values of the named
+// variables of the matching pattern.
+func (b *BucketBuilder) OnDelete(delete func(ctx RoutingContext) error) *BucketBuilder {
+	b.delete = delete
 	return b
 }
 
 // Match defines a pattern which is matched against a path and applies the defined data transformation rules
 func (b *BucketBuilder) MatchAlso(pattern string) *BucketBuilder {
-	b.matchPatterns = append(b.matchPatterns, &pathMatcher{})
+	b.matchPatterns = append(b.matchPatterns, newPathMatcher(pattern))
 	return b
 }
 
 // OnList configures the generic call to ReadBucket, which is either nil, *DefaultEntry or map[string]interface{}.
 // In any other case ReadBucket will return map[string]interface{} with the 3 fields n,s and b which
-// contains name, size and the isBucket flag.
-func (b *BucketBuilder) OnList(transformation func(Path) ([]*DefaultEntry, error)) *BucketBuilder {
-	b.onRead = func(context context.Context, path string, options interface{}) (ResultSet, error) {
-		entries, err := transformation(Path(path))
+// contains name, size and the isBucket flag. The RoutingContext provides the path, the values of the named
+// variables of the matching pattern and the options as argument.
+func (b *BucketBuilder) OnList(transformation func(ctx RoutingContext) ([]*DefaultEntry, error)) *BucketBuilder {
+	b.onRead = func(ctx RoutingContext, options interface{}) (ResultSet, error) {
+		entries, err := transformation(ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -484,12 +547,24 @@ func (b *BucketBuilder) Add() *Builder {
 
 //==
 
+// A pathMatcher uses the Router pattern engine for a single pattern.
 type pathMatcher struct {
-	path string
+	router Router
 }
 
-func (p *pathMatcher) isMatching(path Path) bool {
-	return false
+func newPathMatcher(pattern string) *pathMatcher {
+	p := &pathMatcher{}
+	p.router.Match(pattern, nil)
+	return p
+}
+
+// match returns a RoutingContext with the resolved named variables, if the path matches.
+func (p *pathMatcher) match(ctx context.Context, path Path, args ...interface{}) (RoutingContext, bool) {
+	res, ok := p.router.lookup(ctx, path, args...)
+	if !ok {
+		return nil, false
+	}
+	return res, true
 }
 
 // deprecated
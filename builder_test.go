@@ -0,0 +1,131 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuilder_Match(t *testing.T) {
+	builder := &Builder{}
+	var photoOwner, photoId, deleted string
+	fs := builder.Details("test", 1, 0, 0).
+		MatchBucket("/users/{id}/photos").
+		OnList(func(ctx RoutingContext) ([]*DefaultEntry, error) {
+			return []*DefaultEntry{{Id: ctx.ValueOf("id") + ".jpg"}}, nil
+		}).
+		Add().
+		MatchBlob("/users/{id}/photos/{photo}").
+		OnRead(func(ctx RoutingContext) (io.Reader, error) {
+			photoOwner = ctx.ValueOf("id")
+			photoId = ctx.ValueOf("photo")
+			return strings.NewReader("jpg"), nil
+		}).
+		OnWrite(func(ctx RoutingContext) (io.Writer, error) {
+			return &bytes.Buffer{}, nil
+		}).
+		OnDelete(func(ctx RoutingContext) error {
+			deleted = ctx.ValueOf("id") + "/" + ctx.ValueOf("photo")
+			return nil
+		}).
+		Add().
+		MatchBlob("/static/**/*.txt").
+		OnRead(func(ctx RoutingContext) (io.Reader, error) {
+			return strings.NewReader(ctx.Path().String()), nil
+		}).
+		Add().
+		Create()
+
+	ctx := context.Background()
+	res, err := fs.ReadBucket(ctx, "/users/42/photos", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Len() != 1 || res.ReadAttrs(0, nil).Name() != "42.jpg" {
+		t.Fatal("unexpected result", res.Sys())
+	}
+
+	if _, err := fs.ReadBucket(ctx, "/users/42", nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+
+	blob, err := fs.Open(ctx, "/users/42/photos/7", os.O_RDONLY, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(blob)
+	if string(data) != "jpg" || photoOwner != "42" || photoId != "7" {
+		t.Fatal("unexpected match", string(data), photoOwner, photoId)
+	}
+
+	blob, err = fs.Open(ctx, "/users/42/photos/7", os.O_WRONLY, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blob.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Delete(ctx, "/users/42/photos/7"); err != nil || deleted != "42/7" {
+		t.Fatal("expected a deleted 42/7 but got", deleted, err)
+	}
+
+	blob, err = fs.Open(ctx, "/static/a/b/c.txt", os.O_RDONLY, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = ioutil.ReadAll(blob)
+	if string(data) != "/static/a/b/c.txt" {
+		t.Fatal("unexpected content", string(data))
+	}
+
+	if _, err := fs.Open(ctx, "/static/a/b/c.png", os.O_RDONLY, nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+
+	// the builder is reset but the created filesystem must still work
+	if builder.vfs != nil {
+		t.Fatal("expected reset builder")
+	}
+	listener := &memTestListener{}
+	if _, err := fs.AddListener(ctx, "/users/1/photos", listener); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.ReadBucket(ctx, "/users/1/photos", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(listener.events) != 1 {
+		t.Fatal("expected 1 event but got", listener.events)
+	}
+}
+
+func TestLocalFileSystem(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := &ChRoot{Prefix: Path(filepath.ToSlash(dir)), Delegate: LocalFileSystem}
+	memWrite(t, fs, "/a/b.txt", "hello")
+	if str := memRead(t, fs, "/a/b.txt"); str != "hello" {
+		t.Fatal("expected hello but got", str)
+	}
+
+	res, err := fs.ReadBucket(context.Background(), "/a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Len() != 1 || res.ReadAttrs(0, nil).Name() != "b.txt" {
+		t.Fatal("unexpected result", res.Sys())
+	}
+
+	if _, err := fs.Open(context.Background(), "/a/missing.txt", os.O_RDONLY, nil); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,434 @@
+package vfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// copyJournalSuffix is appended to the hidden name of a blob in transfer
+	copyJournalSuffix = ".vfscopy"
+	// copyJournalInterval is the amount of bytes after which the journal is updated
+	copyJournalInterval = 4 << 20
+)
+
+// a copyJournal is the sidecar of a partially transferred blob
+type copyJournal struct {
+	Src      string `json:"src"`
+	Size     int64  `json:"size"`
+	Modified int64  `json:"modified"`
+	Offset   int64  `json:"offset"`
+}
+
+// copyJob is a single blob to transfer
+type copyJob struct {
+	src   string
+	dst   string
+	entry Entry
+}
+
+// copier contains the state of a single copy process
+type copier struct {
+	ctx     context.Context
+	srcFs   FileSystem
+	dstFs   FileSystem
+	options *CopyOptions
+	objects int64
+	bytes   int64
+}
+
+// CopyFS performs a copy from src of the srcFs to dst of the dstFs, which may be the same or a different
+// FileSystem. Dst is removed and replaced with the contents of src, unless CopyOptions.Resume is set. The copy
+// options can be nil and can be used to get detailed information on the progress. The implementation tries to
+// use RefLink first, if both FileSystems are the same. Use Sync to transfer only the changes.
+//
+// Details
+//
+//  * buckets are created first, afterwards the blobs are transferred by the configured amount of Workers
+//  * with Resume, a journal is kept for each blob in transfer, either in CopyOptions.JournalDir or as a hidden
+//    sidecar next to the blob, e.g. /dst/.a.txt.vfscopy. The sidecars of an interrupted copy remain in the
+//    destination until the copy is resumed, but they are never copied or synchronized. A resumed transfer
+//    continues at the journaled offset using Seek, if the size and modification time of the source are
+//    unchanged. A blob without journal and with the size of the source is considered as complete, if it has
+//    been modified after the source. With Verify, the hashes decide instead.
+//  * with Verify, the hashes are compared after each transfer and a mismatch is returned as EIO. With Resume,
+//    a mismatching complete blob is transferred again instead.
+//  * an error is passed to OnError and the blob is skipped, if nil is returned. Cancel or a done context
+//    interrupts the process with EINTR and keeps the journals.
+func CopyFS(ctx context.Context, srcFs FileSystem, src string, dstFs FileSystem, dst string, options *CopyOptions) error {
+	info, err := srcFs.ReadAttrs(ctx, src, nil)
+	if err != nil {
+		return err
+	}
+	if options == nil || !options.Resume {
+		if err := dstFs.Delete(ctx, dst); err != nil {
+			return err
+		}
+	}
+
+	if equalsByReference(srcFs, dstFs) {
+		if err := srcFs.RefLink(ctx, src, dst); err == nil {
+			options.onScan(src, 1, size(info))
+			options.onCopied(src, 1, size(info))
+			return nil
+		}
+	}
+
+	c := &copier{ctx: ctx, srcFs: srcFs, dstFs: dstFs, options: options}
+	if !info.IsDir() {
+		options.onScan(src, 1, size(info))
+		err := c.copyBlob(copyJob{src: Path(src).String(), dst: Path(dst).String(), entry: info})
+		if err != nil {
+			return options.onError(dst, err)
+		}
+		return nil
+	}
+
+	var buckets []copyJob
+	var blobs []copyJob
+	if err := c.scan(Path(src), Path(dst), &buckets, &blobs); err != nil {
+		return err
+	}
+	c.bytes = 0
+	if err := dstFs.MkBucket(ctx, Path(dst).String(), nil); err != nil {
+		return err
+	}
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].dst < buckets[j].dst
+	})
+	for _, job := range buckets {
+		if err := c.cancelled(); err != nil {
+			return err
+		}
+		if err := dstFs.MkBucket(ctx, job.dst, nil); err != nil {
+			if err = options.onError(job.dst, err); err != nil {
+				return err
+			}
+			continue
+		}
+		options.onCopied(job.src, atomic.AddInt64(&c.objects, 1), atomic.LoadInt64(&c.bytes))
+	}
+	return c.transfer(blobs)
+}
+
+// cancelled returns EINTR, if the options have been cancelled or the context is done.
+func (c *copier) cancelled() error {
+	if err := c.ctx.Err(); err != nil {
+		return &DefaultError{Message: "copy cancelled: " + err.Error(), Code: EINTR, CausedBy: err}
+	}
+	if c.options.IsCancelled() {
+		return &DefaultError{Message: "copy cancelled", Code: EINTR}
+	}
+	return nil
+}
+
+// scan collects all buckets and blobs recursively
+func (c *copier) scan(src Path, dst Path, buckets *[]copyJob, blobs *[]copyJob) error {
+	entries, err := readEntries(c.ctx, c.srcFs, src.String(), nil)
+	if err != nil {
+		if err = c.options.onError(src.String(), err); err != nil {
+			return err
+		}
+		return nil
+	}
+	for _, entry := range entries {
+		if isCopyJournal(entry.Id) {
+			continue
+		}
+		job := copyJob{src: src.Child(entry.Id).String(), dst: dst.Child(entry.Id).String(), entry: entry}
+		found := int64(len(*buckets) + len(*blobs) + 1)
+		if entry.IsBucket {
+			*buckets = append(*buckets, job)
+			c.options.onScan(job.src, found, c.bytes)
+			if err := c.scan(Path(job.src), Path(job.dst), buckets, blobs); err != nil {
+				return err
+			}
+			continue
+		}
+		*blobs = append(*blobs, job)
+		if entry.Length > 0 {
+			c.bytes += entry.Length
+		}
+		c.options.onScan(job.src, found, c.bytes)
+	}
+	return nil
+}
+
+// transfer distributes the blobs to the workers and returns the first error, which has not been ignored by
+// OnError.
+func (c *copier) transfer(blobs []copyJob) error {
+	workers := 1
+	if c.options != nil && c.options.Workers > 1 {
+		workers = c.options.Workers
+	}
+	jobs := make(chan copyJob)
+	var failed error
+	var failedOnce sync.Once
+	var stopped int32
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				if atomic.LoadInt32(&stopped) == 1 {
+					continue
+				}
+				err := c.copyBlob(job)
+				if err == nil {
+					continue
+				}
+				if !IsErr(err, EINTR) {
+					err = c.options.onError(job.dst, err)
+				}
+				if err != nil {
+					failedOnce.Do(func() {
+						failed = err
+						atomic.StoreInt32(&stopped, 1)
+					})
+				}
+			}
+		}()
+	}
+	for _, job := range blobs {
+		if atomic.LoadInt32(&stopped) == 1 {
+			break
+		}
+		jobs <- job
+	}
+	close(jobs)
+	wg.Wait()
+	return failed
+}
+
+// journalPath returns the path of the hidden sidecar, e.g. /dst/.a.txt.vfscopy
+func journalPath(dst string) string {
+	p := Path(dst)
+	return p.Parent().Normalize().Child("." + p.Name() + copyJournalSuffix).String()
+}
+
+// isCopyJournal returns true, if the name is a hidden sidecar of journalPath
+func isCopyJournal(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, copyJournalSuffix)
+}
+
+// journalPath returns the path of the journal, either in the JournalDir or the sidecar of the blob
+func (c *copier) journalPath(dst string) string {
+	if c.options != nil && len(c.options.JournalDir) > 0 {
+		return Path(c.options.JournalDir).Add(Path(dst)).String() + copyJournalSuffix
+	}
+	return journalPath(dst)
+}
+
+// resumeOffset returns the offset to continue the transfer. If done is true, the blob is already complete.
+func (c *copier) resumeOffset(job copyJob, journal *copyJournal) (offset int64, done bool, err error) {
+	var existing int64 = -1
+	complete := false
+	if entry, err := c.dstFs.ReadAttrs(c.ctx, job.dst, nil); err == nil && !entry.IsDir() {
+		existing = size(entry)
+		// a blob of equal size is only complete, if it has been written after the last modification of the
+		// source or if it is verified anyway
+		modified := entryDelegator{entry}.ModTime()
+		complete = existing == journal.Size && (c.options.Verify || modified.UnixNano() > 0 && modified.UnixNano() >= journal.Modified)
+	}
+	data, err := ReadAllFS(c.ctx, c.dstFs, c.journalPath(job.dst))
+	if err != nil {
+		if !isNotFound(err) {
+			return 0, false, err
+		}
+		return 0, complete, nil
+	}
+	old := &copyJournal{}
+	if json.Unmarshal(data, old) != nil || old.Src != journal.Src || old.Size != journal.Size || old.Modified != journal.Modified {
+		// garbage or another source
+		return 0, false, nil
+	}
+	offset = old.Offset
+	if existing < offset {
+		offset = existing
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return offset, false, nil
+}
+
+// copyBlob transfers a single blob, resumes and verifies it if requested.
+func (c *copier) copyBlob(job copyJob) error {
+	if err := c.cancelled(); err != nil {
+		return err
+	}
+	resume := c.options != nil && c.options.Resume
+	verify := c.options != nil && c.options.Verify
+	length := size(job.entry)
+	journal := &copyJournal{Src: job.src, Size: length, Modified: entryDelegator{job.entry}.ModTime().UnixNano()}
+
+	if resume {
+		offset, done, err := c.resumeOffset(job, journal)
+		if err != nil {
+			return err
+		}
+		if done {
+			equal := true
+			if verify {
+				if equal, err = c.equal(job); err != nil {
+					return err
+				}
+			}
+			if equal {
+				c.options.onCopied(job.src, atomic.AddInt64(&c.objects, 1), atomic.AddInt64(&c.bytes, length))
+				return nil
+			}
+			offset = 0
+		}
+		journal.Offset = offset
+		if err := c.writeJournal(job, journal); err != nil {
+			return err
+		}
+	}
+
+	written, err := c.stream(job, journal, resume)
+	if err != nil {
+		if resume {
+			journal.Offset += written
+			_ = c.writeJournal(job, journal)
+		}
+		return err
+	}
+	if verify {
+		equal, err := c.equal(job)
+		if err != nil {
+			return err
+		}
+		if !equal {
+			_ = c.dstFs.Delete(c.ctx, c.journalPath(job.dst))
+			return &DefaultError{Message: "checksum mismatch: " + job.dst, Code: EIO, DetailsPayload: []string{job.src, job.dst}}
+		}
+	}
+	if resume {
+		if err := c.dstFs.Delete(c.ctx, c.journalPath(job.dst)); err != nil {
+			return err
+		}
+	}
+	c.options.onCopied(job.src, atomic.AddInt64(&c.objects, 1), atomic.AddInt64(&c.bytes, journal.Offset+written))
+	return nil
+}
+
+// stream copies the content beginning at the journaled offset and returns the written bytes.
+func (c *copier) stream(job copyJob, journal *copyJournal, resume bool) (int64, error) {
+	reader, err := c.srcFs.Open(c.ctx, job.src, os.O_RDONLY, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer silentClose(reader)
+	flag := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if journal.Offset > 0 {
+		flag = os.O_CREATE | os.O_WRONLY
+	}
+	writer, err := c.dstFs.Open(c.ctx, job.dst, flag, nil)
+	if err != nil {
+		return 0, err
+	}
+	if journal.Offset > 0 {
+		if _, err := reader.Seek(journal.Offset, io.SeekStart); err != nil {
+			silentClose(writer)
+			return 0, err
+		}
+		if _, err := writer.Seek(journal.Offset, io.SeekStart); err != nil {
+			silentClose(writer)
+			return 0, err
+		}
+	}
+	var dst io.Writer = writer
+	if resume {
+		dst = &copyJournalWriter{copier: c, job: job, journal: journal, writer: writer}
+	}
+	written, err := copyBuffer(job.src, job.dst, journal.Size, &copyContextReader{c, reader}, dst, nil, c.options)
+	if closeErr := writer.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+	return written, err
+}
+
+func (c *copier) writeJournal(job copyJob, journal *copyJournal) error {
+	data, err := json.Marshal(journal)
+	if err != nil {
+		return err
+	}
+	path := c.journalPath(job.dst)
+	if err := c.dstFs.MkBucket(c.ctx, Path(path).Parent().Normalize().String(), nil); err != nil {
+		return err
+	}
+	_, err = WriteAllFS(c.ctx, c.dstFs, path, data)
+	return err
+}
+
+// equal compares the hashes of the source and the destination
+func (c *copier) equal(job copyJob) (bool, error) {
+	srcHash, err := blobHash(c.ctx, c.srcFs, job.src)
+	if err != nil {
+		return false, err
+	}
+	dstHash, err := blobHash(c.ctx, c.dstFs, job.dst)
+	if err != nil {
+		return false, err
+	}
+	return srcHash == dstHash, nil
+}
+
+// blobHash calculates the SHA-256 hash of the blob
+func blobHash(ctx context.Context, fs FileSystem, path string) (string, error) {
+	blob, err := fs.Open(ctx, path, os.O_RDONLY, nil)
+	if err != nil {
+		return "", err
+	}
+	defer silentClose(blob)
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, blob); err != nil {
+		return "", err
+	}
+	return string(hasher.Sum(nil)), nil
+}
+
+// copyJournalWriter updates the journal of a resumable transfer regularly
+type copyJournalWriter struct {
+	copier  *copier
+	job     copyJob
+	journal *copyJournal
+	writer  io.Writer
+	written int64
+}
+
+func (w *copyJournalWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	before := w.written
+	w.written += int64(n)
+	if w.written/copyJournalInterval != before/copyJournalInterval {
+		journal := *w.journal
+		journal.Offset += w.written
+		if err := w.copier.writeJournal(w.job, &journal); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+// copyContextReader stops reading, if the context is done
+type copyContextReader struct {
+	copier *copier
+	reader io.Reader
+}
+
+func (r *copyContextReader) Read(p []byte) (int, error) {
+	if err := r.copier.ctx.Err(); err != nil {
+		return 0, r.copier.cancelled()
+	}
+	return r.reader.Read(p)
+}
@@ -0,0 +1,173 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCopyFS_Workers(t *testing.T) {
+	src, dst := &MemFS{}, &MemFS{}
+	ctx := context.Background()
+	for i := 0; i < 20; i++ {
+		memWrite(t, src, fmt.Sprintf("/media/%d/%d.jpg", i%3, i), fmt.Sprint(i))
+	}
+	memWrite(t, dst, "/copy/old.jpg", "old")
+
+	copied := 0
+	var objects int64
+	options := &CopyOptions{Workers: 4, Verify: true}
+	options.OnCopied = func(obj string, objectsTransferred int64, bytesTransferred int64) {
+		// serialized callbacks, so no race here
+		copied++
+		objects = objectsTransferred
+	}
+	if err := CopyFS(ctx, src, "/media", dst, "/copy", options); err != nil {
+		t.Fatal(err)
+	}
+	if copied != 23 || objects != 23 {
+		t.Fatal("expected 23 objects but got", copied, objects)
+	}
+	for i := 0; i < 20; i++ {
+		if str := memRead(t, dst, fmt.Sprintf("/copy/%d/%d.jpg", i%3, i)); str != fmt.Sprint(i) {
+			t.Fatal("expected", i, "but got", str)
+		}
+	}
+	if _, err := dst.ReadAttrs(ctx, "/copy/old.jpg", nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected a replaced destination but got", err)
+	}
+
+	// the same FileSystem uses RefLink
+	copied = 0
+	if err := CopyFS(ctx, src, "/media", src, "/backup", options); err != nil {
+		t.Fatal(err)
+	}
+	if copied != 1 || memRead(t, src, "/backup/2/5.jpg") != "5" {
+		t.Fatal("expected a single RefLink but got", copied)
+	}
+}
+
+func TestCopyFS_Resume(t *testing.T) {
+	src, dst := &MemFS{}, &MemFS{}
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	memWrite(t, src, "/big.bin", string(data))
+
+	options := &CopyOptions{Resume: true}
+	options.OnProgress = func(src string, dst string, bytes int64, size int64) {
+		options.Cancel()
+	}
+	if err := CopyFS(ctx, src, "/big.bin", dst, "/big.bin", options); !IsErr(err, EINTR) {
+		t.Fatal("expected EINTR but got", err)
+	}
+	if _, err := dst.ReadAttrs(ctx, journalPath("/big.bin"), nil); err != nil {
+		t.Fatal("expected a journal but got", err)
+	}
+
+	var transferred int64
+	options = &CopyOptions{Resume: true, Verify: true}
+	options.OnProgress = func(src string, dst string, bytes int64, size int64) {
+		transferred = bytes
+	}
+	if err := CopyFS(ctx, src, "/big.bin", dst, "/big.bin", options); err != nil {
+		t.Fatal(err)
+	}
+	if transferred == 0 || transferred >= int64(len(data)) {
+		t.Fatal("expected a resumed transfer but got", transferred)
+	}
+	if str := memRead(t, dst, "/big.bin"); str != string(data) {
+		t.Fatal("unexpected content of size", len(str))
+	}
+	if _, err := dst.ReadAttrs(ctx, journalPath("/big.bin"), nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected no journal but got", err)
+	}
+
+	// a complete blob is skipped
+	transferred = 0
+	if err := CopyFS(ctx, src, "/big.bin", dst, "/big.bin", options); err != nil || transferred != 0 {
+		t.Fatal("expected no transfer but got", transferred, err)
+	}
+}
+
+func TestCopyFS_Context(t *testing.T) {
+	src, dst := &MemFS{}, &MemFS{}
+	memWrite(t, src, "/media/a.jpg", "a")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := CopyFS(ctx, src, "/media", dst, "/media", nil); !IsErr(err, EINTR) {
+		t.Fatal("expected EINTR but got", err)
+	}
+}
+
+func TestCopyFS_ResumeStale(t *testing.T) {
+	src, dst := &MemFS{}, &MemFS{}
+	memWrite(t, dst, "/a.txt", "old")
+	time.Sleep(10 * time.Millisecond)
+	memWrite(t, src, "/a.txt", "new")
+
+	options := &CopyOptions{Resume: true}
+	if err := CopyFS(context.Background(), src, "/a.txt", dst, "/a.txt", options); err != nil {
+		t.Fatal(err)
+	}
+	if str := memRead(t, dst, "/a.txt"); str != "new" {
+		t.Fatal("expected new but got", str)
+	}
+
+	// the destination is newer now and kept
+	memWrite(t, src, "/b.txt", "src")
+	time.Sleep(10 * time.Millisecond)
+	memWrite(t, dst, "/b.txt", "dst")
+	if err := CopyFS(context.Background(), src, "/b.txt", dst, "/b.txt", options); err != nil {
+		t.Fatal(err)
+	}
+	if str := memRead(t, dst, "/b.txt"); str != "dst" {
+		t.Fatal("expected dst but got", str)
+	}
+}
+
+func TestCopyFS_JournalDir(t *testing.T) {
+	src, dst := &MemFS{}, &MemFS{}
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	memWrite(t, src, "/media/big.bin", string(data))
+
+	options := &CopyOptions{Resume: true, JournalDir: "/.journal"}
+	options.OnProgress = func(src string, dst string, bytes int64, size int64) {
+		options.Cancel()
+	}
+	if err := CopyFS(ctx, src, "/media", dst, "/media", options); !IsErr(err, EINTR) {
+		t.Fatal("expected EINTR but got", err)
+	}
+	if _, err := dst.ReadAttrs(ctx, "/.journal/media/big.bin.vfscopy", nil); err != nil {
+		t.Fatal("expected a journal but got", err)
+	}
+	if _, err := dst.ReadAttrs(ctx, journalPath("/media/big.bin"), nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected no sidecar but got", err)
+	}
+
+	options = &CopyOptions{Resume: true, JournalDir: "/.journal"}
+	if err := CopyFS(ctx, src, "/media", dst, "/media", options); err != nil {
+		t.Fatal(err)
+	}
+	if str := memRead(t, dst, "/media/big.bin"); str != string(data) {
+		t.Fatal("unexpected content of size", len(str))
+	}
+	if _, err := dst.ReadAttrs(ctx, "/.journal/media/big.bin.vfscopy", nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected no journal but got", err)
+	}
+}
+
+func TestCopyFS_SkipJournal(t *testing.T) {
+	src, dst := &MemFS{}, &MemFS{}
+	memWrite(t, src, "/media/a.jpg", "a")
+	memWrite(t, src, journalPath("/media/b.jpg"), "{}")
+
+	if err := CopyFS(context.Background(), src, "/media", dst, "/media", nil); err != nil {
+		t.Fatal(err)
+	}
+	if names := overlayNames(t, dst, "/media"); len(names) != 1 || names[0] != "a.jpg" {
+		t.Fatal("expected [a.jpg] but got", names)
+	}
+}
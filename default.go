@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -77,13 +78,19 @@ func Rename(oldPath string, newPath string) error {
 }
 
 // ReadBucket is a utility method to simply list a directory by querying all result set pages.
+// Delegates to ReadBucketFS with Default().
 func ReadBucket(path string) ([]Entry, error) {
+	return ReadBucketFS(context.Background(), Default(), path)
+}
+
+// ReadBucketFS lists a directory of the given FileSystem by querying all result set pages.
+func ReadBucketFS(ctx context.Context, fs FileSystem, path string) ([]Entry, error) {
 	list := make([]Entry, 10)[0:0]
-	res, err := Default().ReadBucket(context.Background(), path, nil)
+	res, err := fs.ReadBucket(ctx, path, nil)
 	for {
 		// got error which may be EOF or something important
 		if err != nil {
-			if IsErr(err, EOF) {
+			if IsErr(err, EOF) || err == io.EOF {
 				return list, nil
 			}
 			return list, err
@@ -95,15 +102,24 @@ func ReadBucket(path string) ([]Entry, error) {
 		}
 
 		// query next page
-		err = res.Next(context.Background())
+		if err = ctx.Err(); err == nil {
+			err = res.Next(ctx)
+		}
 	}
 
 }
 
 // ReadBucketRecur fully reads the given directory recursively and returns Entries with full qualified paths.
+// Delegates to ReadBucketRecurFS with Default().
 func ReadBucketRecur(path string) ([]*PathEntry, error) {
+	return ReadBucketRecurFS(context.Background(), Default(), path)
+}
+
+// ReadBucketRecurFS fully reads the given directory of the FileSystem recursively and returns Entries with
+// full qualified paths.
+func ReadBucketRecurFS(ctx context.Context, fs FileSystem, path string) ([]*PathEntry, error) {
 	res := make([]*PathEntry, 0)
-	err := Walk(path, func(path string, info Entry, err error) error {
+	err := WalkFS(ctx, fs, path, func(path string, info Entry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -119,34 +135,51 @@ func ReadBucketRecur(path string) ([]*PathEntry, error) {
 // A WalkClosure is invoked for each entry in Walk, as long as no error is returned and Entries are available.
 type WalkClosure func(path string, info Entry, err error) error
 
-// Walk recursively goes down the entire path hierarchy starting at the given path
+// Walk recursively goes down the entire path hierarchy starting at the given path. Delegates to WalkFS with
+// Default().
 func Walk(path string, each WalkClosure) error {
+	return WalkFS(context.Background(), Default(), path, each)
+}
 
-	res, err := Default().ReadBucket(context.Background(), path, nil)
+// WalkFS recursively goes down the entire path hierarchy of the FileSystem starting at the given path. The
+// closure is invoked for a bucket before its children. If a bucket cannot be read, the closure is invoked with
+// the path of the bucket and the error. If an err is turned to nil, the walk continues with the next entry.
+// A cancelled context stops the walk with the error of the context.
+func WalkFS(ctx context.Context, fs FileSystem, path string, each WalkClosure) error {
+	res, err := fs.ReadBucket(ctx, path, nil)
 	for {
 
 		// got error which may be EOF or something important
 		if err != nil {
-			if IsErr(err, EOF) {
+			if IsErr(err, EOF) || err == io.EOF {
 				return nil
 			}
-			failedEntry := &DefaultEntry{Id: Path(path).Name()}
+			if ctx.Err() != nil {
+				return err
+			}
+			failedEntry := &DefaultEntry{Id: Path(path).Name(), IsBucket: true}
 			// let the dev override any error case. If an err is turned to nil, the Walk-callee will continue
-			err = each(Path(path).Child(failedEntry.Name()).String(), failedEntry, err)
-			return err
+			return each(Path(path).String(), failedEntry, err)
 		}
 
 		// no error at all, collect results
 		for i := 0; i < res.Len(); i++ {
 			entry := res.ReadAttrs(i, nil)
-			err = each(Path(path).Child(entry.Name()).String(), entry, nil)
-			if err != nil {
+			child := Path(path).Child(entry.Name()).String()
+			if err := each(child, entry, nil); err != nil {
 				return err
 			}
+			if entry.IsDir() {
+				if err := WalkFS(ctx, fs, child, each); err != nil {
+					return err
+				}
+			}
 		}
 
 		// query next page
-		err = res.Next(context.Background())
+		if err = ctx.Err(); err == nil {
+			err = res.Next(ctx)
+		}
 	}
 
 }
@@ -168,9 +201,16 @@ func (e *PathEntry) Equals(other interface{}) bool {
 	return false
 }
 
-// ReadAll loads the entire resource into memory. Only use it, if you know that it fits into memory
+// ReadAll loads the entire resource into memory. Only use it, if you know that it fits into memory.
+// Delegates to ReadAllFS with Default().
 func ReadAll(path string) ([]byte, error) {
-	reader, err := Read(path)
+	return ReadAllFS(context.Background(), Default(), path)
+}
+
+// ReadAllFS loads the entire resource of the FileSystem into memory. Only use it, if you know that it fits into
+// memory.
+func ReadAllFS(ctx context.Context, fs FileSystem, path string) ([]byte, error) {
+	reader, err := fs.Open(ctx, path, os.O_RDONLY, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -185,37 +225,64 @@ func ReadAll(path string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// WriteAll just puts the given data into the path
+// WriteAll just puts the given data into the path. Delegates to WriteAllFS with Default().
 func WriteAll(path string, data []byte) (int, error) {
-	writer, err := Write(path)
+	return WriteAllFS(context.Background(), Default(), path, data)
+}
+
+// WriteAllFS just puts the given data into the path of the FileSystem. An existing blob is truncated and
+// the error of Close is returned, because some implementations only upload when closed.
+func WriteAllFS(ctx context.Context, fs FileSystem, path string, data []byte) (int, error) {
+	writer, err := fs.Open(ctx, path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, nil)
 	if err != nil {
 		return 0, err
 	}
-	defer silentClose(writer)
 
 	n, err := writer.Write(data)
 	if err != nil {
+		_ = writer.Close()
 		return n, err
 	}
 	if n != len(data) {
-		return n, fmt.Errorf("provider %v.Write has violated the Write contract", Default())
+		_ = writer.Close()
+		return n, fmt.Errorf("provider %v.Write has violated the Write contract", fs)
 	}
-	return n, nil
+	return n, writer.Close()
 }
 
 // Stat emulates a standard library file info contract. See also #ReadAttrs() which allows a bit more control on
-// how the call is made.
+// how the call is made. Delegates to StatFS with Default().
 func Stat(path string) (os.FileInfo, error) {
-	entry, err := Default().ReadAttrs(context.Background(), path, nil)
+	return StatFS(context.Background(), Default(), path)
+}
+
+// StatFS emulates a standard library file info contract for an entry of the FileSystem.
+func StatFS(ctx context.Context, fs FileSystem, path string) (os.FileInfo, error) {
+	entry, err := fs.ReadAttrs(ctx, path, nil)
 	if err != nil {
 		return nil, err
 	}
 	return entryDelegator{entry}, nil
 }
 
-// CopyOptions is used to define the process of copying.
+// CopyOptions is used to define the process of copying. All callbacks are serialized, even if multiple
+// Workers are used.
 type CopyOptions struct {
 	cancelled int32
+	lock      sync.Mutex
+
+	// Workers is the amount of blobs which are transferred concurrently, defaults to 1
+	Workers int
+
+	// Resume keeps the destination and continues partially transferred blobs, see also Copy
+	Resume bool
+
+	// JournalDir is a bucket of the destination FileSystem, which keeps the journals of Resume, e.g. /.vfscopy.
+	// It should be outside of the destination tree. If empty, a hidden sidecar is kept next to each blob in transfer.
+	JournalDir string
+
+	// Verify compares the SHA-256 hashes of the source and the destination after each transfer
+	Verify bool
 
 	// OnScan is called while scanning the source
 	OnScan func(obj string, objects int64, bytes int64)
@@ -247,6 +314,8 @@ func (o *CopyOptions) onProgress(src string, dst string, bytes int64, size int64
 	if o == nil || o.OnProgress == nil {
 		return
 	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
 	o.OnProgress(src, dst, bytes, size)
 }
 
@@ -254,6 +323,8 @@ func (o *CopyOptions) onScan(obj string, objects int64, bytes int64) {
 	if o == nil || o.OnScan == nil {
 		return
 	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
 	o.OnScan(obj, objects, bytes)
 }
 
@@ -261,6 +332,8 @@ func (o *CopyOptions) onCopied(obj string, objectsTransferred int64, bytesTransf
 	if o == nil || o.OnCopied == nil {
 		return
 	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
 	o.OnCopied(obj, objectsTransferred, bytesTransferred)
 }
 
@@ -268,6 +341,8 @@ func (o *CopyOptions) onError(object string, err error) error {
 	if o == nil || o.OnError == nil {
 		return err
 	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
 	return o.OnError(object, err)
 }
 
@@ -282,117 +357,9 @@ func size(entry Entry) int64 {
 	return -1
 }
 
-// Copy performs a copy from src to dst. Dst is always removed and replaced with the contents of src.
-// The copy options can be nil and can be used to get detailed information on the progress. The implementation
-// tries to use RefLink if possible.
+// Copy performs a copy from src to dst. Delegates to CopyFS with Default().
 func Copy(src string, dst string, options *CopyOptions) error {
-
-	// first try to stat
-	info, err := Stat(src)
-	if err != nil {
-		return err
-	}
-
-	// cleanup dst
-	err = Delete(dst)
-	if err != nil {
-		return err
-	}
-
-	if info.IsDir() {
-		var objectsFound int64
-		var bytesFound int64
-		var objectsProcessed int64
-		var bytesProcessed int64
-		// collect info
-		list := make([]*PathEntry, 0)
-		err = Walk(src, func(path string, info Entry, err error) error {
-			if err != nil {
-				return options.onError(path, err)
-			}
-			list = append(list, &PathEntry{path, info})
-			objectsFound++
-			if !info.IsDir() {
-				len := size(info)
-				if len > 0 {
-					bytesFound += len
-				}
-
-			}
-			options.onScan(path, objectsFound, bytesFound)
-			return nil
-		})
-
-		if err != nil {
-			return err
-		}
-
-		//walk through, directory are first
-		for _, entry := range list {
-			dstPath := ConcatPaths(Path(dst), Path(entry.Path).TrimPrefix(Path(src)))
-			if entry.Entry.IsDir() {
-				err := MkDirs(dstPath.String())
-				if err != nil {
-					err = options.onError(dstPath.String(), err)
-					if err != nil {
-						return err
-					}
-				}
-				objectsProcessed++
-				options.onCopied(entry.Path, objectsProcessed, bytesProcessed)
-			} else if !entry.Entry.IsDir() {
-				reader, err := Read(entry.Path)
-				if err != nil {
-					return err
-				}
-				writer, err := Write(dstPath.String())
-				if err != nil {
-					silentClose(reader)
-					return err
-				}
-				written, err := copyBuffer(entry.Path, dstPath.String(), size(entry.Entry), reader, writer, nil, options)
-				silentClose(reader)
-				silentClose(writer)
-				if err != nil {
-					err = options.onError(dstPath.String(), err)
-					if err != nil {
-						return err
-					}
-					return err
-				}
-				objectsProcessed++
-				bytesProcessed += written
-				options.onCopied(entry.Path, objectsProcessed, bytesProcessed)
-
-			} else {
-				err = options.onError(entry.Path, fmt.Errorf("unsupported path object %v", entry.Path))
-				if err != nil {
-					return err
-				}
-			}
-		}
-		return nil
-	}
-
-	options.onScan(src, 1, info.Size())
-	//just copy file
-	reader, err := Read(src)
-	if err != nil {
-		return err
-	}
-	defer silentClose(reader)
-	writer, err := Write(dst)
-	if err != nil {
-		return err
-	}
-	defer silentClose(writer)
-	written, err := copyBuffer(src, dst, info.Size(), reader, writer, nil, options)
-	if err != nil {
-		return err
-	}
-	options.onCopied(src, 1, written)
-	return nil
-
+	return CopyFS(context.Background(), Default(), src, Default(), dst, options)
 }
 
 func copyBuffer(srcPath string, dstPath string, totalSize int64, src io.Reader, dst io.Writer, buf []byte, options *CopyOptions) (written int64, err error) {
@@ -450,13 +417,14 @@ func (e entryDelegator) Name() string {
 }
 
 func (e entryDelegator) Size() int64 {
-	if sizer, ok := e.entry.(interface{ Size() int64 }); ok {
-		return sizer.Size()
-	}
-	return -1
+	return size(e.entry)
 }
 
 func (e entryDelegator) Mode() os.FileMode {
+	// e.g. an os.FileInfo or tar header as payload
+	if moder, ok := e.entry.Sys().(interface{ Mode() os.FileMode }); ok {
+		return moder.Mode()
+	}
 	if e.entry.IsDir() {
 		return os.ModeDir
 	}
@@ -467,6 +435,10 @@ func (e entryDelegator) ModTime() time.Time {
 	if timer, ok := e.entry.(interface{ ModTime() time.Time }); ok {
 		return timer.ModTime()
 	}
+	// e.g. an os.FileInfo as payload
+	if timer, ok := e.entry.Sys().(interface{ ModTime() time.Time }); ok {
+		return timer.ModTime()
+	}
 	return time.Unix(0, 0)
 }
 
@@ -0,0 +1,75 @@
+package vfs
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestWalkFS(t *testing.T) {
+	fs := &MemFS{}
+	ctx := context.Background()
+	memWrite(t, fs, "/a/b/c.txt", "c")
+	memWrite(t, fs, "/a/d.txt", "d")
+
+	var paths []string
+	err := WalkFS(ctx, fs, "/", func(path string, info Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a parent is always visited before its children
+	index := strings.Join(paths, ",") + ","
+	for _, path := range []string{"/a", "/a/b", "/a/b/c.txt", "/a/d.txt"} {
+		if !strings.Contains(index, path+",") {
+			t.Fatal("expected", path, "but got", paths)
+		}
+	}
+	if strings.Index(index, "/a,") > strings.Index(index, "/a/b,") || strings.Index(index, "/a/b,") > strings.Index(index, "/a/b/c.txt,") {
+		t.Fatal("expected parents first but got", paths)
+	}
+
+	entries, err := ReadBucketRecurFS(ctx, fs, "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatal("expected 3 entries but got", len(entries))
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := ReadBucketFS(cancelled, fs, "/a"); err == nil {
+		t.Fatal("expected a cancelled context but got", err)
+	}
+}
+
+func TestReadWriteAllFS(t *testing.T) {
+	fs := &MemFS{}
+	ctx := context.Background()
+	if _, err := WriteAllFS(ctx, fs, "/a.txt", []byte("hello world")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := WriteAllFS(ctx, fs, "/a.txt", []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ReadAllFS(ctx, fs, "/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Fatal("expected a truncated blob but got", string(data))
+	}
+	info, err := StatFS(ctx, fs, "/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 5 || info.IsDir() {
+		t.Fatal("expected 5 but got", info.Size())
+	}
+}
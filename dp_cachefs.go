@@ -0,0 +1,514 @@
+package vfs
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ FileSystem = (*CacheFileSystem)(nil)
+
+const (
+	defaultCacheTTL          = time.Minute
+	defaultCacheMaxEntries   = 10000
+	defaultCacheMaxBlobBytes = 256 << 20
+)
+
+// A CacheFileSystem decorates a slow FileSystem, e.g. a remote backend, with an in-memory cache of attributes
+// and bucket listings and optionally with a local disk cache of blob contents.
+//
+// Details
+//
+//  * ReadAttrs is cached for nil, *DefaultEntry and map[string]interface{} arguments, other arguments are
+//    always delegated
+//  * ReadBucket is cached with nil options only. All pages are read at once and returned as a single page. The
+//    entries of a listing are also cached as attributes.
+//  * if BlobDir is set, blobs opened with os.O_RDONLY and not larger than MaxBlobBytes are copied into BlobDir
+//    and served from there
+//  * an entry expires after TTL. The least recently used entries and blobs are evicted, if MaxEntries or
+//    MaxBlobBytes are exceeded.
+//  * Open for writing, Delete, Rename, MkBucket, WriteAttrs and the link operations invalidate the path, its
+//    children and its parent bucket. A writable Blob invalidates again when closed.
+//  * the cache registers a listener at the Delegate and invalidates for all events except the ones fired before
+//    reading. Because an Open event does not tell whether the blob is read or written, changes which bypass
+//    the cache are only detected by the TTL, if the Delegate fires no other events.
+//  * transactions are delegated, Commit and Rollback clear the cache. Reads within a transaction are cached like
+//    any other read, so don't use a transaction view through the cache.
+//  * Close clears the cache, removes the cached blobs and closes the Delegate
+type CacheFileSystem struct {
+	// Delegate is the cached FileSystem
+	Delegate FileSystem
+	// TTL is the maximum age of a cached entry, defaults to 1 minute
+	TTL time.Duration
+	// MaxEntries is the maximum amount of cached attributes and listings, defaults to 10000
+	MaxEntries int
+	// BlobDir is a local directory to cache blob contents. If empty, blobs are not cached.
+	BlobDir string
+	// MaxBlobBytes is the maximum size of all cached blobs, defaults to 256 MiB
+	MaxBlobBytes int64
+
+	once     sync.Once
+	lock     sync.Mutex
+	meta     *cacheLRU
+	blobs    *cacheLRU
+	gen      int64 // incremented by each invalidation, so that a concurrent fill is discarded
+	listener int
+}
+
+const (
+	cacheKindAttrs = iota
+	cacheKindBucket
+	cacheKindBlob
+)
+
+type cacheKey struct {
+	kind int
+	path Path
+}
+
+// a cacheItem is either an entry, a listing or the local file of a blob
+type cacheItem struct {
+	key     cacheKey
+	expires time.Time
+	entry   *DefaultEntry
+	entries []*DefaultEntry
+	file    string
+	size    int64
+}
+
+// cacheLRU orders the items by their last usage and evicts the oldest ones, if the used size exceeds max.
+type cacheLRU struct {
+	order   *list.List
+	items   map[cacheKey]*list.Element
+	used    int64
+	max     int64
+	evicted func(item *cacheItem)
+}
+
+func newCacheLRU(max int64, evicted func(item *cacheItem)) *cacheLRU {
+	return &cacheLRU{order: list.New(), items: make(map[cacheKey]*list.Element), max: max, evicted: evicted}
+}
+
+// get returns the item and marks it as recently used. Expired items are removed.
+func (l *cacheLRU) get(key cacheKey) *cacheItem {
+	elem := l.items[key]
+	if elem == nil {
+		return nil
+	}
+	item := elem.Value.(*cacheItem)
+	if time.Now().After(item.expires) {
+		l.remove(elem)
+		return nil
+	}
+	l.order.MoveToFront(elem)
+	return item
+}
+
+// put adds or replaces the item and evicts the least recently used items, if required.
+func (l *cacheLRU) put(item *cacheItem) {
+	if elem := l.items[item.key]; elem != nil {
+		l.remove(elem)
+	}
+	l.items[item.key] = l.order.PushFront(item)
+	l.used += item.size
+	for l.used > l.max && l.order.Len() > 0 {
+		l.remove(l.order.Back())
+	}
+}
+
+func (l *cacheLRU) remove(elem *list.Element) {
+	item := l.order.Remove(elem).(*cacheItem)
+	delete(l.items, item.key)
+	l.used -= item.size
+	if l.evicted != nil {
+		l.evicted(item)
+	}
+}
+
+// removeIf removes all matching items
+func (l *cacheLRU) removeIf(matches func(key cacheKey) bool) {
+	for key, elem := range l.items {
+		if matches(key) {
+			l.remove(elem)
+		}
+	}
+}
+
+// cacheListener invalidates the cache on events of the Delegate
+type cacheListener struct {
+	fs *CacheFileSystem
+}
+
+func (l cacheListener) OnEvent(path string, event interface{}) error {
+	switch event {
+	case EventBeforeOpen, EventBeforeReadAttrs, EventBeforeBucketRead:
+		return nil
+	}
+	p, _ := splitFork(path)
+	l.fs.invalidate(p)
+	return nil
+}
+
+func (c *CacheFileSystem) ttl() time.Duration {
+	if c.TTL <= 0 {
+		return defaultCacheTTL
+	}
+	return c.TTL
+}
+
+func (c *CacheFileSystem) maxBlobBytes() int64 {
+	if c.MaxBlobBytes <= 0 {
+		return defaultCacheMaxBlobBytes
+	}
+	return c.MaxBlobBytes
+}
+
+// init allocates the caches and registers the listener. A Delegate without listeners is fine.
+func (c *CacheFileSystem) init() {
+	c.once.Do(func() {
+		maxEntries := int64(c.MaxEntries)
+		if maxEntries <= 0 {
+			maxEntries = defaultCacheMaxEntries
+		}
+		c.meta = newCacheLRU(maxEntries, nil)
+		c.blobs = newCacheLRU(c.maxBlobBytes(), func(item *cacheItem) {
+			_ = os.Remove(item.file)
+		})
+		handle, err := c.Delegate.AddListener(context.Background(), "/", cacheListener{c})
+		if err != nil {
+			handle = -1
+		}
+		c.listener = handle
+	})
+}
+
+// invalidate removes the path, all its children and the parent listing and attributes.
+func (c *CacheFileSystem) invalidate(path Path) {
+	c.init()
+	path = path.Normalize()
+	parent := path.Parent().Normalize()
+	prefix := string(path) + "/"
+	if path.NameCount() == 0 {
+		prefix = "/"
+	}
+	matches := func(key cacheKey) bool {
+		return key.path == path || key.path == parent || strings.HasPrefix(string(key.path), prefix)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.gen++
+	c.meta.removeIf(matches)
+	c.blobs.removeIf(matches)
+}
+
+// Invalidate removes the path, all its children and its parent bucket from the cache.
+func (c *CacheFileSystem) Invalidate(path string) {
+	c.invalidate(Path(path))
+}
+
+// generation returns the current generation, to detect an invalidation while filling the cache.
+func (c *CacheFileSystem) generation() int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.gen
+}
+
+// put caches the items, if there was no invalidation since gen.
+func (c *CacheFileSystem) put(gen int64, lru *cacheLRU, items ...*cacheItem) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if gen != c.gen {
+		return false
+	}
+	expires := time.Now().Add(c.ttl())
+	for _, item := range items {
+		item.expires = expires
+		lru.put(item)
+	}
+	return true
+}
+
+func (c *CacheFileSystem) get(lru *cacheLRU, kind int, path Path) *cacheItem {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return lru.get(cacheKey{kind, path})
+}
+
+func (c *CacheFileSystem) Connect(ctx context.Context, path string, options interface{}) (interface{}, error) {
+	return c.Delegate.Connect(ctx, path, options)
+}
+
+// Disconnect clears the cache, because a new connection may see other data.
+func (c *CacheFileSystem) Disconnect(ctx context.Context, path string) error {
+	c.invalidate("/")
+	return c.Delegate.Disconnect(ctx, path)
+}
+
+func (c *CacheFileSystem) FireEvent(ctx context.Context, path string, event interface{}) error {
+	return c.Delegate.FireEvent(ctx, path, event)
+}
+
+func (c *CacheFileSystem) AddListener(ctx context.Context, path string, listener ResourceListener) (handle int, err error) {
+	return c.Delegate.AddListener(ctx, path, listener)
+}
+
+func (c *CacheFileSystem) RemoveListener(ctx context.Context, handle int) error {
+	return c.Delegate.RemoveListener(ctx, handle)
+}
+
+func (c *CacheFileSystem) Begin(ctx context.Context, path string, options interface{}) (context.Context, error) {
+	return c.Delegate.Begin(ctx, path, options)
+}
+
+func (c *CacheFileSystem) Commit(ctx context.Context) error {
+	defer c.invalidate("/")
+	return c.Delegate.Commit(ctx)
+}
+
+func (c *CacheFileSystem) Rollback(ctx context.Context) error {
+	defer c.invalidate("/")
+	return c.Delegate.Rollback(ctx)
+}
+
+// Open serves read only blobs from the BlobDir, if configured. A writable blob invalidates the path when
+// opened and when closed.
+func (c *CacheFileSystem) Open(ctx context.Context, path string, flag int, options interface{}) (Blob, error) {
+	c.init()
+	p, fork := splitFork(path)
+	if flag != os.O_RDONLY {
+		c.invalidate(p)
+		blob, err := c.Delegate.Open(ctx, path, flag, options)
+		if err != nil {
+			return nil, err
+		}
+		return &cacheWriteBlob{Blob: blob, fs: c, path: p}, nil
+	}
+	if len(c.BlobDir) == 0 || len(fork) > 0 || options != nil {
+		return c.Delegate.Open(ctx, path, flag, options)
+	}
+
+	if item := c.get(c.blobs, cacheKindBlob, p); item != nil {
+		if file, err := os.Open(item.file); err == nil {
+			return &BlobAdapter{file}, nil
+		}
+	}
+
+	gen := c.generation()
+	entry, err := c.ReadAttrs(ctx, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	length := size(entry)
+	if entry.IsDir() || length < 0 || length > c.maxBlobBytes() {
+		return c.Delegate.Open(ctx, path, flag, options)
+	}
+	file, err := c.fill(ctx, gen, p, length)
+	if err != nil {
+		return nil, err
+	}
+	return &BlobAdapter{file}, nil
+}
+
+// fill copies the blob into the BlobDir and returns the opened local copy. The copy is only cached, if
+// there was no invalidation in the meantime.
+func (c *CacheFileSystem) fill(ctx context.Context, gen int64, path Path, length int64) (*os.File, error) {
+	blob, err := c.Delegate.Open(ctx, path.String(), os.O_RDONLY, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer silentClose(blob)
+
+	if err := os.MkdirAll(c.BlobDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempFile(c.BlobDir, "fill")
+	if err != nil {
+		return nil, err
+	}
+	written, err := io.Copy(tmp, io.LimitReader(blob, c.maxBlobBytes()+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	sum := sha256.Sum256([]byte(path))
+	name := filepath.Join(c.BlobDir, hex.EncodeToString(sum[:]))
+	file, err := os.Open(tmp.Name())
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return nil, err
+	}
+	// the opened file stays readable, even if it is removed or replaced
+	if written > c.maxBlobBytes() || !c.putBlob(gen, path, tmp.Name(), name, written) {
+		_ = os.Remove(tmp.Name())
+	}
+	return file, nil
+}
+
+// putBlob renames the tmp file and caches it, if there was no invalidation since gen.
+func (c *CacheFileSystem) putBlob(gen int64, path Path, tmp string, name string, length int64) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if gen != c.gen {
+		return false
+	}
+	// an existing item refers to the same file and must not remove it later
+	if elem := c.blobs.items[cacheKey{cacheKindBlob, path}]; elem != nil {
+		c.blobs.remove(elem)
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return false
+	}
+	c.blobs.put(&cacheItem{key: cacheKey{cacheKindBlob, path}, expires: time.Now().Add(c.ttl()), file: name, size: length})
+	return true
+}
+
+func (c *CacheFileSystem) Delete(ctx context.Context, path string) error {
+	p, _ := splitFork(path)
+	defer c.invalidate(p)
+	return c.Delegate.Delete(ctx, path)
+}
+
+// ReadAttrs returns a cached entry, if available.
+func (c *CacheFileSystem) ReadAttrs(ctx context.Context, path string, args interface{}) (Entry, error) {
+	c.init()
+	switch args.(type) {
+	case nil, *DefaultEntry, map[string]interface{}:
+	default:
+		return c.Delegate.ReadAttrs(ctx, path, args)
+	}
+	p := Path(path).Normalize()
+	if item := c.get(c.meta, cacheKindAttrs, p); item != nil {
+		cpy := *item.entry
+		return readEntryInto(&cpy, args), nil
+	}
+
+	gen := c.generation()
+	entry, err := c.Delegate.ReadAttrs(ctx, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &DefaultEntry{Id: entry.Name(), IsBucket: entry.IsDir(), Length: size(entry), Data: entry.Sys()}
+	c.put(gen, c.meta, &cacheItem{key: cacheKey{cacheKindAttrs, p}, entry: snapshot, size: 1})
+	cpy := *snapshot
+	return readEntryInto(&cpy, args), nil
+}
+
+func (c *CacheFileSystem) ReadForks(ctx context.Context, path string) ([]string, error) {
+	return c.Delegate.ReadForks(ctx, path)
+}
+
+func (c *CacheFileSystem) WriteAttrs(ctx context.Context, path string, src interface{}) (Entry, error) {
+	p, _ := splitFork(path)
+	defer c.invalidate(p)
+	return c.Delegate.WriteAttrs(ctx, path, src)
+}
+
+// ReadBucket returns a cached listing as a single page, if available.
+func (c *CacheFileSystem) ReadBucket(ctx context.Context, path string, options interface{}) (ResultSet, error) {
+	c.init()
+	if options != nil {
+		return c.Delegate.ReadBucket(ctx, path, options)
+	}
+	p := Path(path).Normalize()
+	if item := c.get(c.meta, cacheKindBucket, p); item != nil {
+		return &DefaultResultSet{cacheCopy(item.entries)}, nil
+	}
+
+	gen := c.generation()
+	entries, err := readEntries(ctx, c.Delegate, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*cacheItem, 0, len(entries)+1)
+	items = append(items, &cacheItem{key: cacheKey{cacheKindBucket, p}, entries: entries, size: 1})
+	for _, entry := range entries {
+		items = append(items, &cacheItem{key: cacheKey{cacheKindAttrs, p.Child(entry.Id)}, entry: entry, size: 1})
+	}
+	c.put(gen, c.meta, items...)
+	return &DefaultResultSet{cacheCopy(entries)}, nil
+}
+
+// cacheCopy returns a shallow copy of the entries, so that the cached entries cannot be modified.
+func cacheCopy(entries []*DefaultEntry) []*DefaultEntry {
+	res := make([]*DefaultEntry, len(entries))
+	for i, entry := range entries {
+		cpy := *entry
+		res[i] = &cpy
+	}
+	return res
+}
+
+func (c *CacheFileSystem) Invoke(ctx context.Context, endpoint string, args ...interface{}) (interface{}, error) {
+	return c.Delegate.Invoke(ctx, endpoint, args...)
+}
+
+func (c *CacheFileSystem) MkBucket(ctx context.Context, path string, options interface{}) error {
+	defer c.invalidate(Path(path))
+	return c.Delegate.MkBucket(ctx, path, options)
+}
+
+func (c *CacheFileSystem) Rename(ctx context.Context, oldPath string, newPath string) error {
+	defer c.invalidate(Path(newPath))
+	defer c.invalidate(Path(oldPath))
+	return c.Delegate.Rename(ctx, oldPath, newPath)
+}
+
+func (c *CacheFileSystem) SymLink(ctx context.Context, oldPath string, newPath string) error {
+	defer c.invalidate(Path(newPath))
+	return c.Delegate.SymLink(ctx, oldPath, newPath)
+}
+
+// HardLink also invalidates the old path, because the attributes of the source, like its link count, change.
+func (c *CacheFileSystem) HardLink(ctx context.Context, oldPath string, newPath string) error {
+	defer c.invalidate(Path(newPath))
+	defer c.invalidate(Path(oldPath))
+	return c.Delegate.HardLink(ctx, oldPath, newPath)
+}
+
+// RefLink also invalidates the old path, because a backend may track the shared references in the attributes.
+func (c *CacheFileSystem) RefLink(ctx context.Context, oldPath string, newPath string) error {
+	defer c.invalidate(Path(newPath))
+	defer c.invalidate(Path(oldPath))
+	return c.Delegate.RefLink(ctx, oldPath, newPath)
+}
+
+// Close clears the cache, removes the listener and closes the Delegate.
+func (c *CacheFileSystem) Close() error {
+	c.invalidate("/")
+	c.lock.Lock()
+	handle := c.listener
+	c.listener = -1
+	c.lock.Unlock()
+	if handle >= 0 {
+		_ = c.Delegate.RemoveListener(context.Background(), handle)
+	}
+	return c.Delegate.Close()
+}
+
+func (c *CacheFileSystem) String() string {
+	return "CacheFileSystem(" + c.Delegate.String() + ")"
+}
+
+// cacheWriteBlob invalidates the path when closed
+type cacheWriteBlob struct {
+	Blob
+	fs   *CacheFileSystem
+	path Path
+}
+
+func (b *cacheWriteBlob) Close() error {
+	defer b.fs.invalidate(b.path)
+	return b.Blob.Close()
+}
@@ -0,0 +1,177 @@
+package vfs
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testCountingFS counts the reading calls
+type testCountingFS struct {
+	MemFS
+	attrs   int32
+	buckets int32
+	opens   int32
+}
+
+func (f *testCountingFS) ReadAttrs(ctx context.Context, path string, args interface{}) (Entry, error) {
+	atomic.AddInt32(&f.attrs, 1)
+	return f.MemFS.ReadAttrs(ctx, path, args)
+}
+
+func (f *testCountingFS) ReadBucket(ctx context.Context, path string, options interface{}) (ResultSet, error) {
+	atomic.AddInt32(&f.buckets, 1)
+	return f.MemFS.ReadBucket(ctx, path, options)
+}
+
+func (f *testCountingFS) Open(ctx context.Context, path string, flag int, options interface{}) (Blob, error) {
+	atomic.AddInt32(&f.opens, 1)
+	return f.MemFS.Open(ctx, path, flag, options)
+}
+
+func TestCacheFileSystem(t *testing.T) {
+	delegate := &testCountingFS{}
+	memWrite(t, &delegate.MemFS, "/docs/a.txt", "a")
+	fs := &CacheFileSystem{Delegate: delegate}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if names := overlayNames(t, fs, "/docs"); len(names) != 1 || names[0] != "a.txt" {
+			t.Fatal("unexpected listing", names)
+		}
+	}
+	if _, err := fs.ReadAttrs(ctx, "/docs/a.txt", nil); err != nil {
+		t.Fatal(err)
+	}
+	if delegate.buckets != 1 || delegate.attrs != 0 {
+		t.Fatal("expected 1 listing and no attributes but got", delegate.buckets, delegate.attrs)
+	}
+
+	// own write paths
+	memWrite(t, fs, "/docs/b.txt", "b")
+	if names := overlayNames(t, fs, "/docs"); len(names) != 2 {
+		t.Fatal("expected 2 entries but got", names)
+	}
+	if err := fs.Rename(ctx, "/docs/b.txt", "/other/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if names := overlayNames(t, fs, "/docs"); len(names) != 1 {
+		t.Fatal("expected 1 entry but got", names)
+	}
+
+	// events of the delegate
+	memWrite(t, &delegate.MemFS, "/docs/c.txt", "c")
+	if err := delegate.MemFS.Delete(ctx, "/docs/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if names := overlayNames(t, fs, "/docs"); len(names) != 1 || names[0] != "c.txt" {
+		t.Fatal("expected c.txt but got", names)
+	}
+	if _, err := fs.ReadAttrs(ctx, "/docs/a.txt", nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheFileSystem_Eviction(t *testing.T) {
+	delegate := &testCountingFS{}
+	for _, name := range []string{"/a", "/b", "/c"} {
+		memWrite(t, &delegate.MemFS, name, name)
+	}
+	fs := &CacheFileSystem{Delegate: delegate, MaxEntries: 2, TTL: 50 * time.Millisecond}
+	ctx := context.Background()
+	read := func(path string) {
+		t.Helper()
+		if _, err := fs.ReadAttrs(ctx, path, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read("/a")
+	read("/b")
+	read("/a")
+	read("/c") // evicts /b
+	read("/a")
+	if delegate.attrs != 3 {
+		t.Fatal("expected 3 calls but got", delegate.attrs)
+	}
+	read("/b")
+	if delegate.attrs != 4 {
+		t.Fatal("expected 4 calls but got", delegate.attrs)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	read("/b")
+	if delegate.attrs != 5 {
+		t.Fatal("expected 5 calls but got", delegate.attrs)
+	}
+}
+
+func TestCacheFileSystem_Blobs(t *testing.T) {
+	delegate := &testCountingFS{}
+	memWrite(t, &delegate.MemFS, "/small.txt", "small")
+	memWrite(t, &delegate.MemFS, "/large.txt", "large content")
+	fs := &CacheFileSystem{Delegate: delegate, BlobDir: t.TempDir(), MaxBlobBytes: 10}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if str := memRead(t, fs, "/small.txt"); str != "small" {
+			t.Fatal("expected small but got", str)
+		}
+		if str := memRead(t, fs, "/large.txt"); str != "large content" {
+			t.Fatal("expected large content but got", str)
+		}
+	}
+	if delegate.opens != 4 {
+		t.Fatal("expected 4 opens but got", delegate.opens)
+	}
+
+	memWrite(t, fs, "/small.txt", "changed")
+	if str := memRead(t, fs, "/small.txt"); str != "changed" {
+		t.Fatal("expected changed but got", str)
+	}
+	if _, err := fs.Open(ctx, "/missing.txt", os.O_RDONLY, nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if files, err := os.ReadDir(fs.BlobDir); err != nil || len(files) != 0 {
+		t.Fatal("expected an empty blob dir but got", files, err)
+	}
+}
+
+func TestCacheFileSystem_Links(t *testing.T) {
+	delegate := &testCountingFS{}
+	memWrite(t, &delegate.MemFS, "/src/a.txt", "a")
+	fs := &CacheFileSystem{Delegate: delegate}
+	ctx := context.Background()
+
+	for _, link := range []func(ctx context.Context, oldPath string, newPath string) error{fs.HardLink, fs.RefLink} {
+		if _, err := fs.ReadAttrs(ctx, "/src/a.txt", nil); err != nil {
+			t.Fatal(err)
+		}
+		overlayNames(t, fs, "/src")
+		attrs, buckets := delegate.attrs, delegate.buckets
+		if err := link(ctx, "/src/a.txt", "/src/b.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fs.ReadAttrs(ctx, "/src/a.txt", nil); err != nil {
+			t.Fatal(err)
+		}
+		if names := overlayNames(t, fs, "/src"); len(names) != 2 {
+			t.Fatal("expected 2 entries but got", names)
+		}
+		if delegate.attrs != attrs+1 || delegate.buckets != buckets+1 {
+			t.Fatal("expected an invalidated source but got", delegate.attrs-attrs, delegate.buckets-buckets)
+		}
+		if err := fs.Delete(ctx, "/src/b.txt"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,971 @@
+package vfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var _ FileSystem = (*CASFileSystem)(nil)
+
+const (
+	casIndexName        = "index.json"
+	casObjectsName      = "objects"
+	casTmpName          = "tmp"
+	defaultCASChunkSize = 1 << 20
+)
+
+// A CASFileSystem keeps the content of all blobs in a content addressable object store on the local disk and
+// the tree of buckets and blobs as metadata. Identical content is stored only once, which makes it a good fit
+// for large collections with many duplicates, like media libraries. The zero value uses the current working
+// directory, so usually you want to set Dir.
+//
+// Details
+//
+//  * the content is split into chunks of ChunkSize bytes and each chunk is stored once at
+//    Dir/objects/ab/abcdef... using the hex encoded SHA-256 hash of the chunk
+//  * the tree is kept in memory and written atomically to Dir/index.json after each modification
+//  * RefLink is a reference copy, which only copies the list of chunks and never the content, also for buckets
+//  * HardLink shares the content address and the modification time, a write through one name is visible
+//    through all names
+//  * objects are garbage collected, as soon as no blob and no opened Blob refers to them anymore. Unreferenced
+//    objects, e.g. after a crash, are removed when the index is loaded.
+//  * a writable Blob is buffered in Dir/tmp and stored when it is closed. Readers see the content from the time
+//    when they have opened the Blob.
+//  * the Sys() payload of an entry is always a *CASAttrs
+//  * symbolic links, forks, attributes, listeners, transactions and Invoke are not supported
+//  * the directory must not be used by multiple instances at the same time
+type CASFileSystem struct {
+	// Dir is the local directory, which contains the objects and the index
+	Dir string
+	// ChunkSize is the size of a chunk in bytes and defaults to 1 MiB. It can be changed for an existing store,
+	// but new content is not deduplicated against content written with a different chunk size.
+	ChunkSize int64
+
+	once    sync.Once
+	loadErr error
+	lock    sync.RWMutex
+	index   *casIndex
+	links   map[uint64]int // inode id => amount of nodes
+	refs    map[string]int // hash => amount of inodes and opened blobs
+}
+
+// CASAttrs is the payload returned by Entry.Sys() for all entries of a CASFileSystem.
+type CASAttrs struct {
+	// Modified is the last time, when the content has been changed
+	Modified time.Time
+	// Chunks are the hex encoded SHA-256 hashes of the content, empty for buckets
+	Chunks []string
+	// Links is the amount of names, which refer to the same content
+	Links int
+}
+
+// ModTime returns Modified, so that CASAttrs looks like an os.FileInfo.
+func (a *CASAttrs) ModTime() time.Time {
+	return a.Modified
+}
+
+// casIndex is the persistent metadata of a CASFileSystem
+type casIndex struct {
+	Root      *casNode             `json:"root"`
+	Inodes    map[uint64]*casInode `json:"inodes"`
+	NextInode uint64               `json:"nextInode"`
+}
+
+// a casNode is a named entry in the tree. A bucket has non-nil children.
+type casNode struct {
+	Children map[string]*casNode `json:"children"`
+	Inode    uint64              `json:"inode"`
+}
+
+func (n *casNode) isDir() bool {
+	return n.Children != nil
+}
+
+// a casInode is shared by hard links
+type casInode struct {
+	Chunks    []string  `json:"chunks"`
+	ChunkSize int64     `json:"chunkSize"`
+	Size      int64     `json:"size"`
+	Modified  time.Time `json:"modified"`
+}
+
+// casIOError wraps an error of the local filesystem.
+func casIOError(err error) error {
+	if os.IsNotExist(err) {
+		return &DefaultError{Message: err.Error(), Code: ENOENT, CausedBy: err}
+	}
+	return &DefaultError{Message: err.Error(), Code: EIO, CausedBy: err}
+}
+
+func (c *CASFileSystem) chunkSize() int64 {
+	if c.ChunkSize <= 0 {
+		return defaultCASChunkSize
+	}
+	return c.ChunkSize
+}
+
+func (c *CASFileSystem) objectPath(hash string) string {
+	return filepath.Join(c.Dir, casObjectsName, hash[:2], hash)
+}
+
+// init loads the index on first use and removes all stale temporary files and unreferenced objects.
+func (c *CASFileSystem) init() error {
+	c.once.Do(func() {
+		c.loadErr = c.load()
+	})
+	return c.loadErr
+}
+
+func (c *CASFileSystem) load() error {
+	tmp := filepath.Join(c.Dir, casTmpName)
+	if err := os.RemoveAll(tmp); err != nil {
+		return casIOError(err)
+	}
+	for _, dir := range []string{tmp, filepath.Join(c.Dir, casObjectsName)} {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			return casIOError(err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(c.Dir, casIndexName))
+	switch {
+	case os.IsNotExist(err):
+		c.index = &casIndex{Inodes: make(map[uint64]*casInode)}
+		c.index.Root = c.newNode(true)
+	case err != nil:
+		return casIOError(err)
+	default:
+		c.index = &casIndex{}
+		if err := json.Unmarshal(data, c.index); err != nil {
+			return &DefaultError{Message: "invalid index: " + err.Error(), Code: EIO, CausedBy: err}
+		}
+	}
+
+	c.links = make(map[uint64]int)
+	c.refs = make(map[string]int)
+	c.walk(c.index.Root, func(node *casNode) {
+		c.links[node.Inode]++
+	})
+	for _, inode := range c.index.Inodes {
+		for _, hash := range inode.Chunks {
+			c.refs[hash]++
+		}
+	}
+
+	return filepath.Walk(filepath.Join(c.Dir, casObjectsName), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return casIOError(err)
+		}
+		if !info.IsDir() && c.refs[info.Name()] == 0 {
+			if err := os.Remove(path); err != nil {
+				return casIOError(err)
+			}
+		}
+		return nil
+	})
+}
+
+// save writes the index atomically, caller must hold the lock.
+func (c *CASFileSystem) save() error {
+	data, err := json.Marshal(c.index)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(c.Dir, casTmpName, casIndexName)
+	if err := ioutil.WriteFile(tmp, data, os.ModePerm); err != nil {
+		return casIOError(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(c.Dir, casIndexName)); err != nil {
+		return casIOError(err)
+	}
+	return nil
+}
+
+// walk visits the node and all its children
+func (c *CASFileSystem) walk(node *casNode, visitor func(node *casNode)) {
+	visitor(node)
+	for _, child := range node.Children {
+		c.walk(child, visitor)
+	}
+}
+
+// newNode allocates a new node with a new inode, caller must hold the lock.
+func (c *CASFileSystem) newNode(bucket bool) *casNode {
+	c.index.NextInode++
+	c.index.Inodes[c.index.NextInode] = &casInode{ChunkSize: c.chunkSize(), Modified: time.Now()}
+	node := &casNode{Inode: c.index.NextInode}
+	if bucket {
+		node.Children = make(map[string]*casNode)
+	}
+	if c.links != nil {
+		c.links[node.Inode]++
+	}
+	return node
+}
+
+// touch updates the modification time of the node
+func (c *CASFileSystem) touch(node *casNode) {
+	c.index.Inodes[node.Inode].Modified = time.Now()
+}
+
+// retain increments the references of all chunks
+func (c *CASFileSystem) retain(chunks []string) {
+	for _, hash := range chunks {
+		c.refs[hash]++
+	}
+}
+
+// release decrements the references of all chunks and removes unreferenced objects.
+func (c *CASFileSystem) release(chunks []string) {
+	for _, hash := range chunks {
+		c.refs[hash]--
+		if c.refs[hash] <= 0 {
+			delete(c.refs, hash)
+			// an orphan is also removed by the next load
+			_ = os.Remove(c.objectPath(hash))
+		}
+	}
+}
+
+// unlink removes the node and all its children from the link counts. Inodes without links are dropped and
+// their chunks released.
+func (c *CASFileSystem) unlink(node *casNode) {
+	c.walk(node, func(node *casNode) {
+		c.links[node.Inode]--
+		if c.links[node.Inode] > 0 {
+			return
+		}
+		delete(c.links, node.Inode)
+		if inode := c.index.Inodes[node.Inode]; inode != nil {
+			c.release(inode.Chunks)
+			delete(c.index.Inodes, node.Inode)
+		}
+	})
+}
+
+// lookup walks the tree, caller must hold the lock.
+func (c *CASFileSystem) lookup(path Path) (*casNode, error) {
+	node := c.index.Root
+	current := Path("")
+	for _, name := range path.Names() {
+		if !node.isDir() {
+			return nil, &DefaultError{Message: "not a bucket: " + current.String(), Code: ENOTDIR, DetailsPayload: []string{path.String()}}
+		}
+		node = node.Children[name]
+		if node == nil {
+			return nil, &DefaultError{Message: path.String(), Code: ENOENT, DetailsPayload: []string{path.String()}}
+		}
+		current = current.Child(name)
+	}
+	return node, nil
+}
+
+// ensureBucket creates all missing buckets of the path. Returns ENOTDIR if a segment is not a bucket.
+func (c *CASFileSystem) ensureBucket(path Path) (*casNode, error) {
+	node := c.index.Root
+	current := Path("")
+	for _, name := range path.Names() {
+		current = current.Child(name)
+		child := node.Children[name]
+		if child == nil {
+			child = c.newNode(true)
+			node.Children[name] = child
+			c.touch(node)
+		}
+		if !child.isDir() {
+			return nil, &DefaultError{Message: "not a bucket: " + current.String(), Code: ENOTDIR, DetailsPayload: []string{current.String()}}
+		}
+		node = child
+	}
+	return node, nil
+}
+
+// entry creates a snapshot of the node, caller must hold the lock.
+func (c *CASFileSystem) entry(name string, node *casNode) *DefaultEntry {
+	inode := c.index.Inodes[node.Inode]
+	attrs := &CASAttrs{Modified: inode.Modified, Chunks: append([]string(nil), inode.Chunks...), Links: c.links[node.Inode]}
+	length := inode.Size
+	if node.isDir() {
+		length = 0
+	}
+	return &DefaultEntry{Id: name, IsBucket: node.isDir(), Length: length, Data: attrs}
+}
+
+func (c *CASFileSystem) Connect(ctx context.Context, path string, options interface{}) (interface{}, error) {
+	return options, nil
+}
+
+func (c *CASFileSystem) Disconnect(ctx context.Context, path string) error {
+	return nil
+}
+
+func (c *CASFileSystem) FireEvent(ctx context.Context, path string, event interface{}) error {
+	return NewENOSYS("FireEvent not supported", c)
+}
+
+func (c *CASFileSystem) AddListener(ctx context.Context, path string, listener ResourceListener) (handle int, err error) {
+	return -1, NewENOSYS("AddListener not supported", c)
+}
+
+func (c *CASFileSystem) RemoveListener(ctx context.Context, handle int) error {
+	return NewENOSYS("RemoveListener not supported", c)
+}
+
+func (c *CASFileSystem) Begin(ctx context.Context, path string, options interface{}) (context.Context, error) {
+	return nil, NewENOSYS("Begin transaction not supported", c)
+}
+
+func (c *CASFileSystem) Commit(ctx context.Context) error {
+	return NewENOSYS("Commit transaction not supported", c)
+}
+
+func (c *CASFileSystem) Rollback(ctx context.Context) error {
+	return NewENOSYS("Rollback transaction not supported", c)
+}
+
+// Open supports the os.O_* flags. A writable Blob copies the current content into a temporary file, unless
+// os.O_TRUNC is set.
+func (c *CASFileSystem) Open(ctx context.Context, path string, flag int, options interface{}) (Blob, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	p := Path(path).Normalize()
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	create := flag&os.O_CREATE != 0
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	node, err := c.lookup(p)
+	switch {
+	case err != nil && create && IsErr(err, ENOENT):
+		parent, err := c.ensureBucket(p.Parent())
+		if err != nil {
+			return nil, err
+		}
+		if p.NameCount() == 0 {
+			return nil, &DefaultError{Message: "cannot create " + p.String(), Code: ENOENT, DetailsPayload: []string{p.String()}}
+		}
+		node = c.newNode(false)
+		parent.Children[p.Name()] = node
+		c.touch(parent)
+		if err := c.save(); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case create && flag&os.O_EXCL != 0:
+		return nil, &DefaultError{Message: p.String(), Code: EEXIST, DetailsPayload: []string{p.String()}}
+	}
+
+	if node.isDir() {
+		return nil, &DefaultError{Message: p.String(), Code: EISDIR, DetailsPayload: []string{p.String()}}
+	}
+
+	inode := c.index.Inodes[node.Inode]
+	reader := &casReadBlob{fs: c, chunks: append([]string(nil), inode.Chunks...), chunkSize: inode.ChunkSize, size: inode.Size}
+	c.retain(reader.chunks)
+	if !writable {
+		return reader, nil
+	}
+	defer c.release(reader.chunks)
+
+	file, err := ioutil.TempFile(filepath.Join(c.Dir, casTmpName), "blob")
+	if err != nil {
+		return nil, casIOError(err)
+	}
+	name := file.Name()
+	if flag&os.O_TRUNC == 0 {
+		if _, err := io.Copy(file, io.NewSectionReader(reader, 0, reader.size)); err != nil {
+			_ = file.Close()
+			_ = os.Remove(name)
+			return nil, err
+		}
+	}
+	if err := file.Close(); err != nil {
+		_ = os.Remove(name)
+		return nil, casIOError(err)
+	}
+	file, err = os.OpenFile(name, os.O_RDWR|flag&os.O_APPEND, os.ModePerm)
+	if err != nil {
+		_ = os.Remove(name)
+		return nil, casIOError(err)
+	}
+	return &casWriteBlob{fs: c, inode: node.Inode, file: file, flag: flag}, nil
+}
+
+// store splits the file into chunks and replaces the content of the inode. If the inode has been deleted in
+// the meantime, the content is discarded. The chunks are hashed and the missing objects are written into Dir/tmp
+// without holding the lock, which is only taken to pin existing objects and to commit the new content.
+func (c *CASFileSystem) store(id uint64, file *os.File) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return casIOError(err)
+	}
+
+	tx := &casStore{fs: c, objects: make(map[string]string)}
+	chunkSize := c.chunkSize()
+	buf := make([]byte, chunkSize)
+	var size int64
+	for {
+		n, err := io.ReadFull(file, buf)
+		if n > 0 {
+			if err := tx.add(buf[:n]); err != nil {
+				tx.discard()
+				return err
+			}
+			size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			tx.discard()
+			return casIOError(err)
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	inode := c.index.Inodes[id]
+	if inode == nil {
+		tx.discardLocked()
+		return nil
+	}
+	if err := tx.commitLocked(); err != nil {
+		tx.discardLocked()
+		return err
+	}
+
+	old := inode.Chunks
+	inode.Chunks = tx.chunks
+	inode.ChunkSize = chunkSize
+	inode.Size = size
+	inode.Modified = time.Now()
+	if err := c.save(); err != nil {
+		return err
+	}
+	c.release(old)
+	return nil
+}
+
+// A casStore collects the chunks of a blob, before they are committed into the object store.
+type casStore struct {
+	fs      *CASFileSystem
+	chunks  []string
+	pinned  []string          // chunks which have been retained while adding
+	objects map[string]string // hash => temporary file of a new object
+}
+
+// add hashes the chunk and either retains the existing object or writes a temporary file.
+func (s *casStore) add(data []byte) error {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	s.chunks = append(s.chunks, hash)
+	if _, ok := s.objects[hash]; ok {
+		return nil
+	}
+
+	s.fs.lock.Lock()
+	exists := s.fs.refs[hash] > 0
+	if exists {
+		s.fs.retain([]string{hash})
+	}
+	s.fs.lock.Unlock()
+	if exists {
+		s.pinned = append(s.pinned, hash)
+		return nil
+	}
+
+	file, err := ioutil.TempFile(filepath.Join(s.fs.Dir, casTmpName), "chunk")
+	if err != nil {
+		return casIOError(err)
+	}
+	s.objects[hash] = file.Name()
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return casIOError(err)
+	}
+	return nil
+}
+
+// commitLocked moves the new objects into place and retains all chunks, caller must hold the lock. Objects
+// which have been stored concurrently are not replaced.
+func (s *casStore) commitLocked() error {
+	for hash, tmp := range s.objects {
+		if s.fs.refs[hash] > 0 {
+			_ = os.Remove(tmp)
+			continue
+		}
+		path := s.fs.objectPath(hash)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			return casIOError(err)
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			return casIOError(err)
+		}
+	}
+	// the pinned chunks are already retained once
+	s.fs.retain(s.chunks)
+	s.fs.release(s.pinned)
+	s.pinned = nil
+	s.objects = nil
+	return nil
+}
+
+// discard releases the pinned chunks and removes the temporary files.
+func (s *casStore) discard() {
+	s.fs.lock.Lock()
+	defer s.fs.lock.Unlock()
+	s.discardLocked()
+}
+
+// discardLocked is like discard, but the caller must hold the lock.
+func (s *casStore) discardLocked() {
+	s.fs.release(s.pinned)
+	for _, tmp := range s.objects {
+		_ = os.Remove(tmp)
+	}
+}
+
+// Delete removes the entry and all its children. Objects which are not referenced anymore are removed.
+func (c *CASFileSystem) Delete(ctx context.Context, path string) error {
+	if err := c.init(); err != nil {
+		return err
+	}
+	p := Path(path).Normalize()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if p.NameCount() == 0 {
+		for _, child := range c.index.Root.Children {
+			c.unlink(child)
+		}
+		c.index.Root.Children = make(map[string]*casNode)
+		c.touch(c.index.Root)
+		return c.save()
+	}
+
+	parent, err := c.lookup(p.Parent())
+	if err != nil || !parent.isDir() {
+		// does not exist anyway
+		return nil
+	}
+	node := parent.Children[p.Name()]
+	if node == nil {
+		return nil
+	}
+	delete(parent.Children, p.Name())
+	c.unlink(node)
+	c.touch(parent)
+	return c.save()
+}
+
+// ReadAttrs accepts nil, *DefaultEntry or map[string]interface{}.
+func (c *CASFileSystem) ReadAttrs(ctx context.Context, path string, args interface{}) (Entry, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	p := Path(path).Normalize()
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	node, err := c.lookup(p)
+	if err != nil {
+		return nil, err
+	}
+	return readEntryInto(c.entry(p.Name(), node), args), nil
+}
+
+func (c *CASFileSystem) ReadForks(ctx context.Context, path string) ([]string, error) {
+	return nil, NewENOSYS("ReadForks not supported", c)
+}
+
+func (c *CASFileSystem) WriteAttrs(ctx context.Context, path string, src interface{}) (Entry, error) {
+	return nil, NewENOSYS("WriteAttrs not supported", c)
+}
+
+// ReadBucket returns all entries sorted by name within a single page.
+func (c *CASFileSystem) ReadBucket(ctx context.Context, path string, options interface{}) (ResultSet, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	p := Path(path).Normalize()
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	node, err := c.lookup(p)
+	if err != nil {
+		return nil, err
+	}
+	if !node.isDir() {
+		return nil, &DefaultError{Message: "not a bucket: " + path, Code: ENOENT, DetailsPayload: []string{path}}
+	}
+
+	names := make([]string, 0, len(node.Children))
+	for name := range node.Children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	entries := make([]*DefaultEntry, len(names))
+	for i, name := range names {
+		entries[i] = c.entry(name, node.Children[name])
+	}
+	return &DefaultResultSet{entries}, nil
+}
+
+func (c *CASFileSystem) Invoke(ctx context.Context, endpoint string, args ...interface{}) (interface{}, error) {
+	return nil, NewENOSYS("Invoke not supported", c)
+}
+
+// MkBucket creates all missing buckets and returns ENOTDIR if any segment refers to a blob.
+func (c *CASFileSystem) MkBucket(ctx context.Context, path string, options interface{}) error {
+	if err := c.init(); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, err := c.ensureBucket(Path(path).Normalize()); err != nil {
+		return err
+	}
+	return c.save()
+}
+
+// detach looks up the parent of path and returns it together with the child.
+func (c *CASFileSystem) detach(path Path) (parent *casNode, child *casNode, err error) {
+	parent, err = c.lookup(path.Parent())
+	if err != nil {
+		return nil, nil, err
+	}
+	if !parent.isDir() {
+		return nil, nil, &DefaultError{Message: "not a bucket: " + path.Parent().String(), Code: ENOTDIR, DetailsPayload: []string{path.String()}}
+	}
+	child = parent.Children[path.Name()]
+	if child == nil {
+		return nil, nil, &DefaultError{Message: path.String(), Code: ENOENT, DetailsPayload: []string{path.String()}}
+	}
+	return parent, child, nil
+}
+
+// replace puts the node at path and unlinks a replaced node, caller must hold the lock.
+func (c *CASFileSystem) replace(path Path, node *casNode) error {
+	parent, err := c.ensureBucket(path.Parent())
+	if err != nil {
+		return err
+	}
+	if old := parent.Children[path.Name()]; old != nil {
+		c.unlink(old)
+	}
+	parent.Children[path.Name()] = node
+	c.touch(parent)
+	return nil
+}
+
+// Rename moves the entry and replaces anything at newPath. Missing parent buckets of newPath are created.
+func (c *CASFileSystem) Rename(ctx context.Context, oldPath string, newPath string) error {
+	if err := c.init(); err != nil {
+		return err
+	}
+	oldP, newP, err := checkOldNew(oldPath, newPath)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	oldParent, node, err := c.detach(oldP)
+	if err != nil {
+		return err
+	}
+	if oldP == newP {
+		return nil
+	}
+	delete(oldParent.Children, oldP.Name())
+	c.touch(oldParent)
+	if err := c.replace(newP, node); err != nil {
+		// put it back, the tree is unchanged
+		oldParent.Children[oldP.Name()] = node
+		return err
+	}
+	return c.save()
+}
+
+func (c *CASFileSystem) SymLink(ctx context.Context, oldPath string, newPath string) error {
+	return NewENOSYS("SymLink not supported", c)
+}
+
+// HardLink creates a new name for an existing blob, which shares the same inode and therefore the same content
+// address. Buckets are not supported and return EISDIR.
+func (c *CASFileSystem) HardLink(ctx context.Context, oldPath string, newPath string) error {
+	if err := c.init(); err != nil {
+		return err
+	}
+	oldP, newP, err := checkOldNew(oldPath, newPath)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	node, err := c.lookup(oldP)
+	if err != nil {
+		return err
+	}
+	if node.isDir() {
+		return &DefaultError{Message: oldP.String(), Code: EISDIR, DetailsPayload: []string{oldP.String()}}
+	}
+	parent, err := c.ensureBucket(newP.Parent())
+	if err != nil {
+		return err
+	}
+	if parent.Children[newP.Name()] != nil {
+		return &DefaultError{Message: newP.String(), Code: EEXIST, DetailsPayload: []string{newP.String()}}
+	}
+	parent.Children[newP.Name()] = &casNode{Inode: node.Inode}
+	c.links[node.Inode]++
+	c.touch(parent)
+	return c.save()
+}
+
+// RefLink copies blobs and buckets by reference, so only the metadata is copied and the content is shared
+// until one side is modified. An existing newPath is replaced.
+func (c *CASFileSystem) RefLink(ctx context.Context, oldPath string, newPath string) error {
+	if err := c.init(); err != nil {
+		return err
+	}
+	oldP, newP, err := checkOldNew(oldPath, newPath)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	node, err := c.lookup(oldP)
+	if err != nil {
+		return err
+	}
+	if oldP == newP {
+		return nil
+	}
+	if err := c.replace(newP, c.refCopy(node)); err != nil {
+		return err
+	}
+	return c.save()
+}
+
+// refCopy copies the node recursively into new inodes, which refer to the same chunks.
+func (c *CASFileSystem) refCopy(node *casNode) *casNode {
+	cpy := c.newNode(node.isDir())
+	src := c.index.Inodes[node.Inode]
+	dst := c.index.Inodes[cpy.Inode]
+	dst.Chunks = append([]string(nil), src.Chunks...)
+	dst.ChunkSize = src.ChunkSize
+	dst.Size = src.Size
+	c.retain(dst.Chunks)
+	for name, child := range node.Children {
+		cpy.Children[name] = c.refCopy(child)
+	}
+	return cpy
+}
+
+// Close does nothing, the index is always saved.
+func (c *CASFileSystem) Close() error {
+	return nil
+}
+
+func (c *CASFileSystem) String() string {
+	return "CASFileSystem(" + c.Dir + ")"
+}
+
+//==
+
+// A casReadBlob reads the chunks of an inode. The chunks are retained until the blob is closed.
+type casReadBlob struct {
+	fs        *CASFileSystem
+	chunks    []string
+	chunkSize int64
+	size      int64
+	lock      sync.Mutex // guards pos
+	pos       int64
+	closed    int32
+}
+
+func (b *casReadBlob) isClosed() bool {
+	return atomic.LoadInt32(&b.closed) == 1
+}
+
+func (b *casReadBlob) ReadAt(p []byte, off int64) (n int, err error) {
+	if b.isClosed() {
+		return 0, &DefaultError{Message: "blob already closed", Code: EBADF}
+	}
+	if off < 0 {
+		return 0, &DefaultError{Message: "negative offset", Code: EINVAL}
+	}
+	for n < len(p) && off < b.size {
+		idx := off / b.chunkSize
+		start := off % b.chunkSize
+		end := b.chunkSize
+		if remaining := b.size - idx*b.chunkSize; remaining < end {
+			end = remaining
+		}
+		if max := start + int64(len(p)-n); max < end {
+			end = max
+		}
+		read, err := b.readChunk(b.chunks[idx], p[n:n+int(end-start)], start)
+		n += read
+		off += int64(read)
+		if err != nil {
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (b *casReadBlob) readChunk(hash string, p []byte, off int64) (int, error) {
+	file, err := os.Open(b.fs.objectPath(hash))
+	if err != nil {
+		return 0, casIOError(err)
+	}
+	defer silentClose(file)
+	n, err := file.ReadAt(p, off)
+	if err != nil {
+		return n, casIOError(err)
+	}
+	return n, nil
+}
+
+func (b *casReadBlob) Read(p []byte) (n int, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	n, err = b.ReadAt(p, b.pos)
+	b.pos += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (b *casReadBlob) WriteAt(p []byte, off int64) (n int, err error) {
+	return 0, &DefaultError{Message: "blob opened read only", Code: EBADF}
+}
+
+func (b *casReadBlob) Write(p []byte) (n int, err error) {
+	return 0, &DefaultError{Message: "blob opened read only", Code: EBADF}
+}
+
+func (b *casReadBlob) Seek(offset int64, whence int) (int64, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.isClosed() {
+		return 0, &DefaultError{Message: "blob already closed", Code: EBADF}
+	}
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = b.pos + offset
+	case io.SeekEnd:
+		abs = b.size + offset
+	default:
+		return 0, &DefaultError{Message: "invalid whence", Code: EINVAL}
+	}
+	if abs < 0 {
+		return 0, &DefaultError{Message: "negative position", Code: EINVAL}
+	}
+	b.pos = abs
+	return abs, nil
+}
+
+// Close releases the chunks, further calls have no effect.
+func (b *casReadBlob) Close() error {
+	if !atomic.CompareAndSwapInt32(&b.closed, 0, 1) {
+		return nil
+	}
+	b.fs.lock.Lock()
+	defer b.fs.lock.Unlock()
+	b.fs.release(b.chunks)
+	return nil
+}
+
+// A casWriteBlob is a temporary file, which is stored into the inode when closed.
+type casWriteBlob struct {
+	fs     *CASFileSystem
+	inode  uint64
+	file   *os.File
+	flag   int
+	closed int32
+}
+
+func (b *casWriteBlob) readable() error {
+	if atomic.LoadInt32(&b.closed) == 1 {
+		return &DefaultError{Message: "blob already closed", Code: EBADF}
+	}
+	if b.flag&(os.O_WRONLY|os.O_RDWR) == os.O_WRONLY {
+		return &DefaultError{Message: "blob opened write only", Code: EBADF}
+	}
+	return nil
+}
+
+func (b *casWriteBlob) ReadAt(p []byte, off int64) (n int, err error) {
+	if err := b.readable(); err != nil {
+		return 0, err
+	}
+	return b.file.ReadAt(p, off)
+}
+
+func (b *casWriteBlob) Read(p []byte) (n int, err error) {
+	if err := b.readable(); err != nil {
+		return 0, err
+	}
+	return b.file.Read(p)
+}
+
+func (b *casWriteBlob) WriteAt(p []byte, off int64) (n int, err error) {
+	return b.file.WriteAt(p, off)
+}
+
+func (b *casWriteBlob) Write(p []byte) (n int, err error) {
+	return b.file.Write(p)
+}
+
+func (b *casWriteBlob) Seek(offset int64, whence int) (int64, error) {
+	return b.file.Seek(offset, whence)
+}
+
+// Close stores the content and removes the temporary file, further calls have no effect.
+func (b *casWriteBlob) Close() error {
+	if !atomic.CompareAndSwapInt32(&b.closed, 0, 1) {
+		return nil
+	}
+	defer func() {
+		_ = os.Remove(b.file.Name())
+	}()
+	err := b.fs.store(b.inode, b.file)
+	if closeErr := b.file.Close(); err == nil && closeErr != nil {
+		err = casIOError(closeErr)
+	}
+	return err
+}
@@ -0,0 +1,235 @@
+package vfs
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// casObjects returns the amount of stored objects
+func casObjects(t *testing.T, fs *CASFileSystem) int {
+	t.Helper()
+	count := 0
+	err := filepath.Walk(filepath.Join(fs.Dir, casObjectsName), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			count++
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func casAttrs(t *testing.T, fs FileSystem, path string) *CASAttrs {
+	t.Helper()
+	entry, err := fs.ReadAttrs(context.Background(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entry.Sys().(*CASAttrs)
+}
+
+func TestCASFileSystem_Dedup(t *testing.T) {
+	fs := &CASFileSystem{Dir: t.TempDir(), ChunkSize: 4}
+	ctx := context.Background()
+
+	memWrite(t, fs, "/a.jpg", "0123456789")
+	memWrite(t, fs, "/copy/b.jpg", "0123456789")
+	memWrite(t, fs, "/c.jpg", "01234567")
+	// 0123, 4567, 89
+	if n := casObjects(t, fs); n != 3 {
+		t.Fatal("expected 3 objects but got", n)
+	}
+	if str := memRead(t, fs, "/copy/b.jpg"); str != "0123456789" {
+		t.Fatal("expected 0123456789 but got", str)
+	}
+
+	blob, err := fs.Open(ctx, "/a.jpg", os.O_RDONLY, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silentClose(blob)
+	buf := make([]byte, 5)
+	if n, err := blob.ReadAt(buf, 3); n != 5 || err != nil || string(buf) != "34567" {
+		t.Fatal("expected 34567 but got", n, err, string(buf))
+	}
+	if n, err := blob.ReadAt(buf, 7); n != 3 || err != io.EOF || string(buf[:n]) != "789" {
+		t.Fatal("expected 789 and EOF but got", n, err, string(buf[:n]))
+	}
+
+	blob, err = fs.Open(ctx, "/c.jpg", os.O_RDWR|os.O_APPEND, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blob.Write([]byte("89")); err != nil {
+		t.Fatal(err)
+	}
+	if err := blob.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if str := memRead(t, fs, "/c.jpg"); str != "0123456789" {
+		t.Fatal("expected 0123456789 but got", str)
+	}
+	if n := casObjects(t, fs); n != 3 {
+		t.Fatal("expected 3 objects but got", n)
+	}
+}
+
+func TestCASFileSystem_Links(t *testing.T) {
+	fs := &CASFileSystem{Dir: t.TempDir(), ChunkSize: 4}
+	ctx := context.Background()
+
+	memWrite(t, fs, "/media/a.jpg", "aaaabbbb")
+	if err := fs.HardLink(ctx, "/media/a.jpg", "/media/hard.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.RefLink(ctx, "/media", "/backup"); err != nil {
+		t.Fatal(err)
+	}
+	if attrs := casAttrs(t, fs, "/media/hard.jpg"); attrs.Links != 2 || len(attrs.Chunks) != 2 {
+		t.Fatal("expected 2 links and 2 chunks but got", attrs)
+	}
+
+	// a hard link shares the writes, a ref link does not
+	memWrite(t, fs, "/media/hard.jpg", "cccc")
+	if str := memRead(t, fs, "/media/a.jpg"); str != "cccc" {
+		t.Fatal("expected cccc but got", str)
+	}
+	if str := memRead(t, fs, "/backup/a.jpg"); str != "aaaabbbb" {
+		t.Fatal("expected aaaabbbb but got", str)
+	}
+	if n := casObjects(t, fs); n != 3 {
+		t.Fatal("expected 3 objects but got", n)
+	}
+
+	// deleting the last reference collects the objects
+	if err := fs.Delete(ctx, "/backup"); err != nil {
+		t.Fatal(err)
+	}
+	if n := casObjects(t, fs); n != 1 {
+		t.Fatal("expected 1 object but got", n)
+	}
+	if err := fs.Delete(ctx, "/media/a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if n := casObjects(t, fs); n != 1 {
+		t.Fatal("expected 1 object but got", n)
+	}
+
+	// an opened blob keeps its content
+	blob, err := fs.Open(ctx, "/media/hard.jpg", os.O_RDONLY, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Delete(ctx, "/"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(blob, buf); err != nil || string(buf) != "cccc" {
+		t.Fatal("expected cccc but got", string(buf), err)
+	}
+	if err := blob.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := casObjects(t, fs); n != 0 {
+		t.Fatal("expected 0 objects but got", n)
+	}
+}
+
+func TestCASFileSystem_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	fs := &CASFileSystem{Dir: dir}
+	ctx := context.Background()
+	memWrite(t, fs, "/a/b.txt", "hello")
+	if err := fs.HardLink(ctx, "/a/b.txt", "/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.MkBucket(ctx, "/empty", nil); err != nil {
+		t.Fatal(err)
+	}
+	// an orphan from a crash
+	if err := os.MkdirAll(filepath.Join(dir, casObjectsName, "ab"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, casObjectsName, "ab", "abc"), nil, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	fs = &CASFileSystem{Dir: dir}
+	if str := memRead(t, fs, "/c.txt"); str != "hello" {
+		t.Fatal("expected hello but got", str)
+	}
+	if attrs := casAttrs(t, fs, "/a/b.txt"); attrs.Links != 2 {
+		t.Fatal("expected 2 links but got", attrs.Links)
+	}
+	if entry, err := fs.ReadAttrs(ctx, "/empty", nil); err != nil || !entry.IsDir() {
+		t.Fatal("expected a bucket but got", entry, err)
+	}
+	if n := casObjects(t, fs); n != 1 {
+		t.Fatal("expected 1 object but got", n)
+	}
+}
+
+func TestCASFileSystem_ConcurrentStore(t *testing.T) {
+	fs := &CASFileSystem{Dir: t.TempDir(), ChunkSize: 4}
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			path := "/" + strconv.Itoa(i) + ".jpg"
+			for j := 0; j < 3; j++ {
+				if _, err := WriteAllFS(ctx, fs, path, []byte("01234567"+strconv.Itoa(i%2))); err != nil {
+					t.Error(err)
+					return
+				}
+				if err := fs.Delete(ctx, path); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+			if _, err := WriteAllFS(ctx, fs, path, []byte("01234567"+strconv.Itoa(i%2))); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// 0123, 4567, 0, 1
+	if n := casObjects(t, fs); n != 4 {
+		t.Fatal("expected 4 objects but got", n)
+	}
+	if str := memRead(t, fs, "/3.jpg"); str != "012345671" {
+		t.Fatal("expected 012345671 but got", str)
+	}
+
+	// the content of a deleted blob is discarded
+	blob, err := fs.Open(ctx, "/new.jpg", os.O_RDWR|os.O_CREATE, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blob.Write([]byte("abcdefgh")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Delete(ctx, "/new.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := blob.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := casObjects(t, fs); n != 4 {
+		t.Fatal("expected 4 objects but got", n)
+	}
+	if files, err := ioutil.ReadDir(filepath.Join(fs.Dir, casTmpName)); err != nil || len(files) != 0 {
+		t.Fatal("expected no temporary files but got", len(files), err)
+	}
+}
@@ -2,6 +2,7 @@ package vfs
 
 import (
 	"context"
+	"strings"
 )
 
 var _ FileSystem = (*ChRoot)(nil)
@@ -22,6 +23,25 @@ func (f *ChRoot) Resolve(path string) string {
 	return f.Prefix.Add(Path(path).Normalize()).String()
 }
 
+// relativize removes the prefix from the affected paths of an error, so that the delegate does not leak paths
+// outside of the root.
+func (f *ChRoot) relativize(err error) error {
+	return rewritePaths(err, func(path string) string {
+		prefix := f.Prefix.String()
+		p := Path(path).String()
+		switch {
+		case prefix == "/":
+			return p
+		case p == prefix:
+			return "/"
+		case strings.HasPrefix(p, prefix+"/"):
+			return p[len(prefix):]
+		default:
+			return path
+		}
+	})
+}
+
 func (f *ChRoot) Connect(ctx context.Context, path string, options interface{}) (interface{}, error) {
 	return f.Delegate.Connect(ctx, f.Resolve(path), options)
 }
@@ -55,51 +75,56 @@ func (f *ChRoot) Rollback(ctx context.Context) error {
 }
 
 func (f *ChRoot) Open(ctx context.Context, path string, flag int, options interface{}) (Blob, error) {
-	return f.Delegate.Open(ctx, f.Resolve(path), flag, options)
+	blob, err := f.Delegate.Open(ctx, f.Resolve(path), flag, options)
+	return blob, f.relativize(err)
 }
 
 func (f *ChRoot) Delete(ctx context.Context, path string) error {
-	return f.Delegate.Delete(ctx, f.Resolve(path))
+	return f.relativize(f.Delegate.Delete(ctx, f.Resolve(path)))
 }
 
 func (f *ChRoot) ReadAttrs(ctx context.Context, path string, args interface{}) (Entry, error) {
-	return f.Delegate.ReadAttrs(ctx, f.Resolve(path), args)
+	entry, err := f.Delegate.ReadAttrs(ctx, f.Resolve(path), args)
+	return entry, f.relativize(err)
 }
 
 func (f *ChRoot) ReadForks(ctx context.Context, path string) ([]string, error) {
-	return f.Delegate.ReadForks(ctx, f.Resolve(path))
+	forks, err := f.Delegate.ReadForks(ctx, f.Resolve(path))
+	return forks, f.relativize(err)
 }
 
 func (f *ChRoot) WriteAttrs(ctx context.Context, path string, src interface{}) (Entry, error) {
-	return f.Delegate.WriteAttrs(ctx, f.Resolve(path), src)
+	entry, err := f.Delegate.WriteAttrs(ctx, f.Resolve(path), src)
+	return entry, f.relativize(err)
 }
 
 func (f *ChRoot) ReadBucket(ctx context.Context, path string, options interface{}) (ResultSet, error) {
-	return f.Delegate.ReadBucket(ctx, f.Resolve(path), options)
+	res, err := f.Delegate.ReadBucket(ctx, f.Resolve(path), options)
+	return res, f.relativize(err)
 }
 
 func (f *ChRoot) Invoke(ctx context.Context, endpoint string, args ...interface{}) (interface{}, error) {
-	return f.Delegate.Invoke(ctx, endpoint, args)
+	return f.Delegate.Invoke(ctx, endpoint, args...)
 }
 
 func (f *ChRoot) MkBucket(ctx context.Context, path string, options interface{}) error {
-	return f.Delegate.MkBucket(ctx, f.Resolve(path), options)
+	return f.relativize(f.Delegate.MkBucket(ctx, f.Resolve(path), options))
 }
 
 func (f *ChRoot) Rename(ctx context.Context, oldPath string, newPath string) error {
-	return f.Delegate.Rename(ctx, f.Resolve(oldPath), f.Resolve(newPath))
+	return f.relativize(f.Delegate.Rename(ctx, f.Resolve(oldPath), f.Resolve(newPath)))
 }
 
 func (f *ChRoot) SymLink(ctx context.Context, oldPath string, newPath string) error {
-	return f.Delegate.SymLink(ctx, f.Resolve(oldPath), f.Resolve(newPath))
+	return f.relativize(f.Delegate.SymLink(ctx, f.Resolve(oldPath), f.Resolve(newPath)))
 }
 
 func (f *ChRoot) HardLink(ctx context.Context, oldPath string, newPath string) error {
-	return f.Delegate.HardLink(ctx, f.Resolve(oldPath), f.Resolve(newPath))
+	return f.relativize(f.Delegate.HardLink(ctx, f.Resolve(oldPath), f.Resolve(newPath)))
 }
 
 func (f *ChRoot) RefLink(ctx context.Context, oldPath string, newPath string) error {
-	return f.Delegate.RefLink(ctx, f.Resolve(oldPath), f.Resolve(newPath))
+	return f.relativize(f.Delegate.RefLink(ctx, f.Resolve(oldPath), f.Resolve(newPath)))
 }
 
 func (f *ChRoot) String() string {
@@ -0,0 +1,621 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var _ FileSystem = (*HTTPFileSystem)(nil)
+
+// The keys of the Connect options of a HTTPFileSystem.
+const (
+	// HTTPOptionToken is the bearer token, which is sent with each request
+	HTTPOptionToken = "token"
+	// HTTPOptionRefreshToken is used to request a new bearer token from the TokenURL
+	HTTPOptionRefreshToken = "refreshToken"
+	// HTTPOptionUser is the user name for the initial token request
+	HTTPOptionUser = "user"
+	// HTTPOptionPassword is the password for the initial token request
+	HTTPOptionPassword = "pwd"
+)
+
+// A HTTPFileSystem is the client of a remote HTTPHandler. It is thread safe.
+//
+// Details
+//
+//  * Connect accepts nil or a map[string]interface{} with the HTTPOption* keys. If a user and password are given,
+//    a token is requested from the TokenURL using the OAuth2 password grant, otherwise the given token is used.
+//    Connect verifies the token by reading the attributes of the path.
+//  * if the server returns EPERM (401) and a refresh token is available, a new token is requested from the
+//    TokenURL and the request is repeated. The new tokens are stored back into the options map of Connect, so
+//    that it can be saved by the caller.
+//  * ReadBucket returns a ResultSet which loads the next page lazily
+//  * Open for reading returns a Blob, which uses Range requests for ReadAt and a streaming request for Read.
+//    Opened for writing, the content is kept in memory and is uploaded by Close.
+//  * the status codes of the server are preserved. Failed connections return EIO.
+//  * listeners, transactions, forks, links and WriteAttrs are not supported
+type HTTPFileSystem struct {
+	// URL is the base URL of the HTTPHandler, e.g. https://example.com/vfs
+	URL string
+	// TokenURL is the OAuth2 token endpoint to request a bearer token. Optional.
+	TokenURL string
+	// Client performs the requests, if nil the http.DefaultClient is used
+	Client *http.Client
+
+	lock    sync.Mutex // guards options
+	options map[string]interface{}
+}
+
+// httpToken is the OAuth2 token response
+type httpToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (h *HTTPFileSystem) client() *http.Client {
+	if h.Client == nil {
+		return http.DefaultClient
+	}
+	return h.Client
+}
+
+// url returns the escaped URL of the path and the optional query.
+func (h *HTTPFileSystem) url(path string, query url.Values) string {
+	u := strings.TrimSuffix(h.URL, "/") + (&url.URL{Path: path}).EscapedPath()
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+func (h *HTTPFileSystem) option(key string) string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	str, _ := h.options[key].(string)
+	return str
+}
+
+// do sends the request with the bearer token and refreshes the token once, if required. A non-2xx response is
+// returned as error, otherwise the caller must close the body.
+func (h *HTTPFileSystem) do(ctx context.Context, method string, path string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+	for retry := true; ; retry = false {
+		req, err := http.NewRequest(method, h.url(path, query), bytes.NewReader(body))
+		if err != nil {
+			return nil, &DefaultError{Message: "invalid request", Code: EINVAL, CausedBy: err}
+		}
+		req = req.WithContext(ctx)
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		if token := h.option(HTTPOptionToken); len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := h.client().Do(req)
+		if err != nil {
+			return nil, &DefaultError{Message: method + " " + path, Code: EIO, CausedBy: err}
+		}
+		if res.StatusCode >= 200 && res.StatusCode < 300 {
+			return res, nil
+		}
+		err = httpResponseError(res)
+		if retry && IsErr(err, EPERM) && len(h.option(HTTPOptionRefreshToken)) > 0 {
+			if err := h.refresh(ctx); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return nil, err
+	}
+}
+
+// httpResponseError reads and closes the body and returns the transported error. If the body contains no error,
+// the http status is translated.
+func httpResponseError(res *http.Response) error {
+	defer silentClose(res.Body)
+	data, _ := ioutil.ReadAll(res.Body)
+	httpErr := &httpError{}
+	if err := json.Unmarshal(data, httpErr); err == nil && httpErr.Code != EOK {
+		return &DefaultError{Message: httpErr.Message, Code: httpErr.Code}
+	}
+	code := EUNKOWN
+	switch {
+	case res.StatusCode == http.StatusUnauthorized:
+		code = EPERM
+	case res.StatusCode == http.StatusForbidden:
+		code = EACCES
+	case res.StatusCode == http.StatusNotFound:
+		code = ENOENT
+	case res.StatusCode == http.StatusNotImplemented:
+		code = ENOSYS
+	case res.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		code = EOF
+	case res.StatusCode >= 500:
+		code = EREMOTEIO
+	}
+	return &DefaultError{Message: res.Status, Code: code, DetailsPayload: res.StatusCode}
+}
+
+// doJSON performs the request and decodes the response into dst.
+func (h *HTTPFileSystem) doJSON(ctx context.Context, method string, path string, query url.Values, body []byte, dst interface{}) error {
+	res, err := h.do(ctx, method, path, query, body, nil)
+	if err != nil {
+		return err
+	}
+	defer silentClose(res.Body)
+	if err := json.NewDecoder(res.Body).Decode(dst); err != nil {
+		return &DefaultError{Message: "invalid response", Code: EPROTO, CausedBy: err}
+	}
+	return nil
+}
+
+// requestToken performs an OAuth2 token request and stores the tokens into the options.
+func (h *HTTPFileSystem) requestToken(ctx context.Context, form url.Values) error {
+	if len(h.TokenURL) == 0 {
+		return &DefaultError{Message: "no TokenURL to request a token", Code: EPERM}
+	}
+	req, err := http.NewRequest(http.MethodPost, h.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return &DefaultError{Message: "invalid TokenURL", Code: EINVAL, CausedBy: err}
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := h.client().Do(req)
+	if err != nil {
+		return &DefaultError{Message: "token request", Code: EIO, CausedBy: err}
+	}
+	defer silentClose(res.Body)
+	if res.StatusCode != http.StatusOK {
+		_, _ = io.Copy(ioutil.Discard, res.Body)
+		return &DefaultError{Message: "token request: " + res.Status, Code: EPERM, DetailsPayload: res.StatusCode}
+	}
+	token := &httpToken{}
+	if err := json.NewDecoder(res.Body).Decode(token); err != nil || len(token.AccessToken) == 0 {
+		return &DefaultError{Message: "invalid token response", Code: EPROTO, CausedBy: err}
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.options == nil {
+		h.options = make(map[string]interface{})
+	}
+	h.options[HTTPOptionToken] = token.AccessToken
+	if len(token.RefreshToken) > 0 {
+		h.options[HTTPOptionRefreshToken] = token.RefreshToken
+	}
+	return nil
+}
+
+func (h *HTTPFileSystem) refresh(ctx context.Context) error {
+	return h.requestToken(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {h.option(HTTPOptionRefreshToken)},
+	})
+}
+
+// Connect requests a token, if required, and verifies it. The options map is returned and updated with any
+// refreshed token.
+func (h *HTTPFileSystem) Connect(ctx context.Context, path string, options interface{}) (interface{}, error) {
+	opts, ok := options.(map[string]interface{})
+	if !ok && options != nil {
+		return nil, &DefaultError{Message: "options must be a map[string]interface{}", Code: EINVAL, DetailsPayload: options}
+	}
+	if opts == nil {
+		opts = make(map[string]interface{})
+	}
+	h.lock.Lock()
+	h.options = opts
+	h.lock.Unlock()
+
+	user, _ := opts[HTTPOptionUser].(string)
+	pwd, _ := opts[HTTPOptionPassword].(string)
+	var err error
+	switch {
+	case len(user) > 0:
+		err = h.requestToken(ctx, url.Values{"grant_type": {"password"}, "username": {user}, "password": {pwd}})
+	case len(h.option(HTTPOptionToken)) == 0 && len(h.option(HTTPOptionRefreshToken)) > 0:
+		err = h.refresh(ctx)
+	}
+	if err != nil {
+		return opts, err
+	}
+	_, err = h.ReadAttrs(ctx, path, nil)
+	return opts, err
+}
+
+// Disconnect forgets the options of Connect.
+func (h *HTTPFileSystem) Disconnect(ctx context.Context, path string) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.options = nil
+	return nil
+}
+
+func (h *HTTPFileSystem) FireEvent(ctx context.Context, path string, event interface{}) error {
+	return NewENOSYS("FireEvent not supported", h)
+}
+
+func (h *HTTPFileSystem) AddListener(ctx context.Context, path string, listener ResourceListener) (handle int, err error) {
+	return -1, NewENOSYS("AddListener not supported", h)
+}
+
+func (h *HTTPFileSystem) RemoveListener(ctx context.Context, handle int) error {
+	return NewENOSYS("RemoveListener not supported", h)
+}
+
+func (h *HTTPFileSystem) Begin(ctx context.Context, path string, options interface{}) (context.Context, error) {
+	return nil, NewENOSYS("Begin transaction not supported", h)
+}
+
+func (h *HTTPFileSystem) Commit(ctx context.Context) error {
+	return NewENOSYS("Commit transaction not supported", h)
+}
+
+func (h *HTTPFileSystem) Rollback(ctx context.Context) error {
+	return NewENOSYS("Rollback transaction not supported", h)
+}
+
+// Open reads the attributes and returns a streaming Blob for reading or an in-memory Blob for writing.
+func (h *HTTPFileSystem) Open(ctx context.Context, path string, flag int, options interface{}) (Blob, error) {
+	p := Path(path).Normalize()
+	entry, err := h.ReadAttrs(ctx, p.String(), nil)
+	switch {
+	case err != nil && (!IsErr(err, ENOENT) || flag&os.O_CREATE == 0):
+		return nil, err
+	case err == nil && entry.IsDir():
+		return nil, &DefaultError{Message: p.String(), Code: EISDIR, DetailsPayload: []string{p.String()}}
+	case err == nil && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &DefaultError{Message: p.String(), Code: EEXIST, DetailsPayload: []string{p.String()}}
+	}
+
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE) == 0 {
+		return &httpBlob{fs: h, ctx: ctx, path: p.String(), size: size(entry)}, nil
+	}
+
+	inode := &memInode{}
+	if err == nil && flag&os.O_TRUNC == 0 {
+		// modify the existing content
+		res, err := h.do(ctx, http.MethodGet, p.String(), nil, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		defer silentClose(res.Body)
+		if inode.data, err = ioutil.ReadAll(res.Body); err != nil {
+			return nil, &DefaultError{Message: "GET " + p.String(), Code: EIO, CausedBy: err}
+		}
+	}
+	blob := &httpWriteBlob{memBlob: memBlob{inode: inode, flag: flag}, fs: h, ctx: ctx, path: p.String()}
+	if err != nil || flag&os.O_TRUNC != 0 {
+		// upload a new or truncated blob, even if nothing is written
+		blob.dirty = 1
+	}
+	return blob, nil
+}
+
+func (h *HTTPFileSystem) Delete(ctx context.Context, path string) error {
+	res, err := h.do(ctx, http.MethodDelete, Path(path).Normalize().String(), nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+func (h *HTTPFileSystem) ReadAttrs(ctx context.Context, path string, args interface{}) (Entry, error) {
+	attrs := &httpEntry{}
+	if err := h.doJSON(ctx, http.MethodGet, Path(path).Normalize().String(), url.Values{httpQueryAttrs: {""}}, nil, attrs); err != nil {
+		return nil, err
+	}
+	return readEntryInto(attrs.entry(), args), nil
+}
+
+func (h *HTTPFileSystem) ReadForks(ctx context.Context, path string) ([]string, error) {
+	return nil, NewENOSYS("ReadForks not supported", h)
+}
+
+func (h *HTTPFileSystem) WriteAttrs(ctx context.Context, path string, src interface{}) (Entry, error) {
+	return nil, NewENOSYS("WriteAttrs not supported", h)
+}
+
+// ReadBucket returns the first page of the bucket.
+func (h *HTTPFileSystem) ReadBucket(ctx context.Context, path string, options interface{}) (ResultSet, error) {
+	res := &httpResultSet{fs: h, path: Path(path).Normalize().String(), page: -1}
+	if err := res.Next(ctx); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Invoke sends the arguments as JSON array and returns the decoded JSON result.
+func (h *HTTPFileSystem) Invoke(ctx context.Context, endpoint string, args ...interface{}) (interface{}, error) {
+	if args == nil {
+		args = []interface{}{}
+	}
+	body, err := json.Marshal(args)
+	if err != nil {
+		return nil, &DefaultError{Message: "arguments must be JSON serializable", Code: EINVAL, CausedBy: err}
+	}
+	var res interface{}
+	if err := h.doJSON(ctx, http.MethodPost, httpInvokePrefix+endpoint, nil, body, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (h *HTTPFileSystem) MkBucket(ctx context.Context, path string, options interface{}) error {
+	res, err := h.do(ctx, httpMethodMkCol, Path(path).Normalize().String(), nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+func (h *HTTPFileSystem) Rename(ctx context.Context, oldPath string, newPath string) error {
+	header := http.Header{}
+	header.Set(httpHeaderDestination, (&url.URL{Path: Path(newPath).Normalize().String()}).EscapedPath())
+	res, err := h.do(ctx, httpMethodMove, Path(oldPath).Normalize().String(), nil, nil, header)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+func (h *HTTPFileSystem) SymLink(ctx context.Context, oldPath string, newPath string) error {
+	return NewENOSYS("SymLink not supported", h)
+}
+
+func (h *HTTPFileSystem) HardLink(ctx context.Context, oldPath string, newPath string) error {
+	return NewENOSYS("HardLink not supported", h)
+}
+
+func (h *HTTPFileSystem) RefLink(ctx context.Context, oldPath string, newPath string) error {
+	return NewENOSYS("RefLink not supported", h)
+}
+
+func (h *HTTPFileSystem) Close() error {
+	return nil
+}
+
+func (h *HTTPFileSystem) String() string {
+	return "HTTPFileSystem(" + h.URL + ")"
+}
+
+// ModTime returns the modification time or the zero time, so that the Sys() payload of a HTTPFileSystem entry
+// looks like an os.FileInfo.
+func (e *httpEntry) ModTime() time.Time {
+	if e.Modified == nil {
+		return time.Time{}
+	}
+	return *e.Modified
+}
+
+func (e *httpEntry) entry() *DefaultEntry {
+	return &DefaultEntry{Id: e.Name, IsBucket: e.Bucket, Length: e.Size, Data: e}
+}
+
+// A httpResultSet holds a single page of a bucket and loads the next page on request.
+type httpResultSet struct {
+	DefaultResultSet
+	fs     *HTTPFileSystem
+	path   string
+	page   int
+	bucket *httpBucket
+}
+
+func (r *httpResultSet) Total() int64 {
+	return r.bucket.Total
+}
+
+func (r *httpResultSet) Pages() int64 {
+	return r.bucket.Pages
+}
+
+// Next loads the next page, the server returns EOF after the last page.
+func (r *httpResultSet) Next(ctx context.Context) error {
+	query := url.Values{httpQueryPage: {strconv.Itoa(r.page + 1)}}
+	bucket := &httpBucket{}
+	if err := r.fs.doJSON(ctx, http.MethodGet, r.path, query, nil, bucket); err != nil {
+		return err
+	}
+	r.page++
+	r.bucket = bucket
+	r.Entries = make([]*DefaultEntry, len(bucket.Entries))
+	for i, e := range bucket.Entries {
+		r.Entries[i] = e.entry()
+	}
+	return nil
+}
+
+// Sys returns the current page
+func (r *httpResultSet) Sys() interface{} {
+	return r.bucket
+}
+
+// A httpBlob reads a remote blob. ReadAt performs a Range request and Read streams the content from the
+// current position.
+type httpBlob struct {
+	fs     *HTTPFileSystem
+	ctx    context.Context
+	path   string
+	size   int64
+	lock   sync.Mutex // guards body and pos
+	body   io.ReadCloser
+	pos    int64
+	closed int32
+}
+
+func (b *httpBlob) isClosed() bool {
+	return atomic.LoadInt32(&b.closed) == 1
+}
+
+// get requests the content from the offset to the end or to the given length.
+func (b *httpBlob) get(off int64, length int) (io.ReadCloser, error) {
+	rng := "bytes=" + strconv.FormatInt(off, 10) + "-"
+	if length > 0 {
+		rng += strconv.FormatInt(off+int64(length)-1, 10)
+	}
+	res, err := b.fs.do(b.ctx, http.MethodGet, b.path, nil, nil, http.Header{"Range": {rng}})
+	if err != nil {
+		if IsErr(err, EOF) {
+			// 416 range not satisfiable
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	if res.StatusCode != http.StatusPartialContent && off > 0 {
+		// the server ignored the range
+		if _, err := io.CopyN(ioutil.Discard, res.Body, off); err != nil {
+			silentClose(res.Body)
+			return nil, io.EOF
+		}
+	}
+	return res.Body, nil
+}
+
+func (b *httpBlob) ReadAt(p []byte, off int64) (n int, err error) {
+	if b.isClosed() {
+		return 0, &DefaultError{Message: "blob already closed", Code: EBADF}
+	}
+	if off < 0 {
+		return 0, &DefaultError{Message: "negative offset", Code: EINVAL}
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if b.size >= 0 && off >= b.size {
+		return 0, io.EOF
+	}
+	body, err := b.get(off, len(p))
+	if err != nil {
+		return 0, err
+	}
+	defer silentClose(body)
+	n, err = io.ReadFull(body, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (b *httpBlob) Read(p []byte) (n int, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.isClosed() {
+		return 0, &DefaultError{Message: "blob already closed", Code: EBADF}
+	}
+	if b.body == nil {
+		if b.size >= 0 && b.pos >= b.size {
+			return 0, io.EOF
+		}
+		body, err := b.get(b.pos, 0)
+		if err != nil {
+			return 0, err
+		}
+		b.body = body
+	}
+	n, err = b.body.Read(p)
+	b.pos += int64(n)
+	return n, err
+}
+
+func (b *httpBlob) WriteAt(p []byte, off int64) (n int, err error) {
+	return 0, &DefaultError{Message: "blob opened read only", Code: EBADF}
+}
+
+func (b *httpBlob) Write(p []byte) (n int, err error) {
+	return 0, &DefaultError{Message: "blob opened read only", Code: EBADF}
+}
+
+// Seek closes the current stream, the next Read continues at the new position.
+func (b *httpBlob) Seek(offset int64, whence int) (int64, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.isClosed() {
+		return 0, &DefaultError{Message: "blob already closed", Code: EBADF}
+	}
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = b.pos + offset
+	case io.SeekEnd:
+		abs = b.size + offset
+	default:
+		return 0, &DefaultError{Message: "invalid whence", Code: EINVAL}
+	}
+	if abs < 0 {
+		return 0, &DefaultError{Message: "negative position", Code: EINVAL}
+	}
+	if abs != b.pos && b.body != nil {
+		silentClose(b.body)
+		b.body = nil
+	}
+	b.pos = abs
+	return abs, nil
+}
+
+func (b *httpBlob) Close() error {
+	atomic.StoreInt32(&b.closed, 1)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.body != nil {
+		err := b.body.Close()
+		b.body = nil
+		return err
+	}
+	return nil
+}
+
+// A httpWriteBlob keeps the content in memory and uploads it by Close, if it has been modified.
+type httpWriteBlob struct {
+	memBlob
+	fs    *HTTPFileSystem
+	ctx   context.Context
+	path  string
+	dirty int32
+}
+
+func (b *httpWriteBlob) WriteAt(p []byte, off int64) (n int, err error) {
+	n, err = b.memBlob.WriteAt(p, off)
+	if n > 0 {
+		atomic.StoreInt32(&b.dirty, 1)
+	}
+	return n, err
+}
+
+func (b *httpWriteBlob) Write(p []byte) (n int, err error) {
+	n, err = b.memBlob.Write(p)
+	if n > 0 {
+		atomic.StoreInt32(&b.dirty, 1)
+	}
+	return n, err
+}
+
+// Close uploads the content. A failed upload is reported but the blob is closed anyway.
+func (b *httpWriteBlob) Close() error {
+	if b.isClosed() {
+		return nil
+	}
+	_ = b.memBlob.Close()
+	if atomic.LoadInt32(&b.dirty) == 0 {
+		return nil
+	}
+	b.inode.lock.RLock()
+	data := b.inode.data
+	b.inode.lock.RUnlock()
+	res, err := b.fs.do(b.ctx, http.MethodPut, b.path, nil, data, nil)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
@@ -0,0 +1,251 @@
+package vfs
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testAuthServer serves a MemFS at /vfs, protected by a bearer token, which can be requested at /token.
+type testAuthServer struct {
+	fs       *MemFS
+	lock     sync.Mutex
+	token    string
+	refresh  string
+	requests int
+}
+
+func (s *testAuthServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.requests++
+		switch {
+		case r.FormValue("grant_type") == "password" && r.FormValue("username") == "alice" && r.FormValue("password") == "secret":
+		case r.FormValue("grant_type") == "refresh_token" && r.FormValue("refresh_token") == s.refresh:
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.token = "token-" + string(rune('a'+s.requests))
+		s.refresh = "refresh-" + string(rune('a'+s.requests))
+		_, _ = io.WriteString(w, `{"access_token":"`+s.token+`","refresh_token":"`+s.refresh+`","token_type":"bearer"}`)
+	})
+	vfs := http.StripPrefix("/vfs", &HTTPHandler{FileSystem: s.fs})
+	mux.HandleFunc("/vfs/", func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		valid := len(s.token) > 0 && r.Header.Get("Authorization") == "Bearer "+s.token
+		s.lock.Unlock()
+		if !valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		vfs.ServeHTTP(w, r)
+	})
+	return mux
+}
+
+// expire invalidates the current token, so that a refresh is required
+func (s *testAuthServer) expire() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.token = "expired"
+}
+
+func TestHTTPFileSystem_Connect(t *testing.T) {
+	auth := &testAuthServer{fs: &MemFS{}}
+	memWrite(t, auth.fs, "/a.txt", "a")
+	srv := httptest.NewServer(auth.handler())
+	defer srv.Close()
+
+	ctx := context.Background()
+	fs := &HTTPFileSystem{URL: srv.URL + "/vfs", TokenURL: srv.URL + "/token"}
+	if _, err := fs.ReadAttrs(ctx, "/a.txt", nil); !IsErr(err, EPERM) {
+		t.Fatal("expected EPERM but got", err)
+	}
+	if _, err := fs.Connect(ctx, "/", map[string]interface{}{HTTPOptionUser: "alice", HTTPOptionPassword: "wrong"}); !IsErr(err, EPERM) {
+		t.Fatal("expected EPERM but got", err)
+	}
+
+	props := map[string]interface{}{HTTPOptionUser: "alice", HTTPOptionPassword: "secret"}
+	if _, err := fs.Connect(ctx, "/", props); err != nil {
+		t.Fatal(err)
+	}
+	if props[HTTPOptionToken] != auth.token || props[HTTPOptionRefreshToken] != auth.refresh {
+		t.Fatal("expected tokens in the properties but got", props)
+	}
+
+	// the token is refreshed transparently and stored back into the properties
+	auth.expire()
+	if str := memRead(t, fs, "/a.txt"); str != "a" {
+		t.Fatal("expected a but got", str)
+	}
+	if props[HTTPOptionToken] != auth.token || auth.requests != 3 {
+		t.Fatal("expected a refreshed token but got", props, auth.requests)
+	}
+
+	// reconnect with the saved refresh token only
+	delete(props, HTTPOptionUser)
+	delete(props, HTTPOptionPassword)
+	delete(props, HTTPOptionToken)
+	fs = &HTTPFileSystem{URL: srv.URL + "/vfs", TokenURL: srv.URL + "/token"}
+	if _, err := fs.Connect(ctx, "/", props); err != nil {
+		t.Fatal(err)
+	}
+	if props[HTTPOptionToken] != auth.token {
+		t.Fatal("expected a refreshed token but got", props)
+	}
+	if err := fs.Disconnect(ctx, "/"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.ReadAttrs(ctx, "/a.txt", nil); !IsErr(err, EPERM) {
+		t.Fatal("expected EPERM but got", err)
+	}
+}
+
+func TestHTTPFileSystem(t *testing.T) {
+	mem := &MemFS{}
+	srv := httptest.NewServer(&HTTPHandler{FileSystem: mem})
+	defer srv.Close()
+	fs := &HTTPFileSystem{URL: srv.URL}
+	ctx := context.Background()
+
+	memWrite(t, fs, "/my docs/a.txt", "0123456789")
+	if str := memRead(t, mem, "/my docs/a.txt"); str != "0123456789" {
+		t.Fatal("expected 0123456789 but got", str)
+	}
+
+	blob, err := fs.Open(ctx, "/my docs/a.txt", os.O_RDONLY, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silentClose(blob)
+	buf := make([]byte, 3)
+	if n, err := blob.ReadAt(buf, 8); n != 2 || err != io.EOF || string(buf[:n]) != "89" {
+		t.Fatal("expected 89 and EOF but got", n, err, string(buf[:n]))
+	}
+	if _, err := blob.Seek(5, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadAll(blob); err != nil || string(data) != "56789" {
+		t.Fatal("expected 56789 but got", string(data), err)
+	}
+
+	blob, err = fs.Open(ctx, "/my docs/a.txt", os.O_RDWR, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blob.WriteAt([]byte("ab"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := blob.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if str := memRead(t, mem, "/my docs/a.txt"); str != "ab23456789" {
+		t.Fatal("expected ab23456789 but got", str)
+	}
+
+	if err := fs.MkBucket(ctx, "/my docs/img", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rename(ctx, "/my docs/a.txt", "/my docs/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	res, err := fs.ReadBucket(ctx, "/my docs", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Len() != 2 || res.ReadAttrs(0, nil).Name() != "b.txt" || !res.ReadAttrs(1, nil).IsDir() {
+		t.Fatal("unexpected result", res.Sys())
+	}
+	if err := res.Next(ctx); !IsErr(err, EOF) {
+		t.Fatal("expected EOF but got", err)
+	}
+	if err := fs.Delete(ctx, "/my docs"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Open(ctx, "/my docs/b.txt", os.O_RDONLY, nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+	if _, err := fs.Invoke(ctx, "echo"); !IsErr(err, ENOSYS) {
+		t.Fatal("expected ENOSYS but got", err)
+	}
+}
+
+// testPagedFS returns each entry of a bucket as a separate page
+type testPagedFS struct {
+	MemFS
+}
+
+type testPagedResultSet struct {
+	DefaultResultSet
+	all  []*DefaultEntry
+	page int
+}
+
+func (r *testPagedResultSet) Total() int64 {
+	return int64(len(r.all))
+}
+
+func (r *testPagedResultSet) Pages() int64 {
+	return int64(len(r.all))
+}
+
+func (r *testPagedResultSet) Next(ctx context.Context) error {
+	if r.page+1 >= len(r.all) {
+		return eof
+	}
+	r.page++
+	r.Entries = r.all[r.page : r.page+1]
+	return nil
+}
+
+func (f *testPagedFS) ReadBucket(ctx context.Context, path string, options interface{}) (ResultSet, error) {
+	all, err := readEntries(ctx, &f.MemFS, path, options)
+	if err != nil {
+		return nil, err
+	}
+	return &testPagedResultSet{DefaultResultSet: DefaultResultSet{Entries: all[:1]}, all: all}, nil
+}
+
+func TestHTTPFileSystem_Pages(t *testing.T) {
+	paged := &testPagedFS{}
+	for _, name := range []string{"a", "b", "c"} {
+		memWrite(t, &paged.MemFS, "/"+name, name)
+	}
+	srv := httptest.NewServer(&HTTPHandler{FileSystem: paged})
+	defer srv.Close()
+	fs := &HTTPFileSystem{URL: srv.URL}
+	ctx := context.Background()
+
+	res, err := fs.ReadBucket(ctx, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Total() != 3 || res.Pages() != 3 {
+		t.Fatal("expected 3 pages but got", res.Total(), res.Pages())
+	}
+	var names []string
+	for {
+		for i := 0; i < res.Len(); i++ {
+			names = append(names, res.ReadAttrs(i, nil).Name())
+		}
+		err := res.Next(ctx)
+		if IsErr(err, EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if strings.Join(names, ",") != "a,b,c" {
+		t.Fatal("expected a,b,c but got", names)
+	}
+}
@@ -0,0 +1,824 @@
+package vfs
+
+import (
+	"context"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var _ FileSystem = (*MemFS)(nil)
+
+// maxSymLinks is the amount of symbolic links which are followed while resolving a path, before ELOOP is returned.
+const maxSymLinks = 40
+
+// A MemFS is a thread safe FileSystem which keeps everything in memory. It is useful for hermetic tests of code
+// written against Default() or as a scratch area which is gone after the process terminates. The zero value is
+// an empty filesystem, ready to use.
+//
+// Details
+//
+//  * Open supports the os.O_* flags and forks (e.g. /my/file.jpg:thumb) and returns a Blob with ReadAt, WriteAt and Seek
+//  * ReadAttrs accepts nil, *DefaultEntry or map[string]interface{} and the Sys() payload is always a *MemAttrs
+//  * WriteAttrs accepts a map[string]interface{}, a nil value removes a key
+//  * symbolic links are resolved for every path segment, hard links share the content and the attributes
+//  * RefLink performs a deep copy, also of buckets
+//  * listeners are notified before an operation using the Event* constants and may cancel it. A listener
+//    receives the events of its path and all its children.
+//  * Connect and Disconnect do nothing, transactions and Invoke are not supported
+type MemFS struct {
+	root          *memNode
+	once          sync.Once
+	lock          sync.RWMutex
+	listeners     map[int]*builderPathListener
+	lastHandle    int
+	listenersLock sync.Mutex
+}
+
+// MemAttrs is the payload returned by Entry.Sys() for all entries of a MemFS.
+type MemAttrs struct {
+	// Modified is the last time, when the content has been changed
+	Modified time.Time
+	// Target is the not resolved path of a symbolic link or empty
+	Target string
+	// Values contains a copy of the attributes set by WriteAttrs
+	Values map[string]interface{}
+}
+
+// ModTime returns Modified, so that MemAttrs looks like an os.FileInfo.
+func (a *MemAttrs) ModTime() time.Time {
+	return a.Modified
+}
+
+// a memNode is a named entry in the tree. A bucket has non-nil children and a symbolic link a non-empty link.
+type memNode struct {
+	children map[string]*memNode
+	link     string
+	inode    *memInode
+}
+
+func (n *memNode) isDir() bool {
+	return n.children != nil
+}
+
+// a memInode is shared by hard links. Attributes and forks are guarded by the filesystem lock,
+// the data by its own lock.
+type memInode struct {
+	lock    sync.RWMutex
+	data    []byte
+	modTime time.Time
+	attrs   map[string]interface{}
+	forks   map[string]*memInode
+}
+
+func newMemBucket() *memNode {
+	return &memNode{children: make(map[string]*memNode), inode: &memInode{modTime: time.Now()}}
+}
+
+func (m *MemFS) getRoot() *memNode {
+	m.once.Do(func() {
+		m.root = newMemBucket()
+		m.listeners = make(map[int]*builderPathListener)
+	})
+	return m.root
+}
+
+// splitFork separates the path from an optional fork name, which is everything after the first colon.
+func splitFork(path string) (Path, string) {
+	idx := strings.Index(path, ":")
+	if idx < 0 {
+		return Path(path).Normalize(), ""
+	}
+	return Path(path[:idx]).Normalize(), path[idx+1:]
+}
+
+// lookup walks the tree and resolves symbolic links in all segments. If followLast is false, a symbolic link
+// in the last segment is not resolved.
+func (m *MemFS) lookup(path Path, followLast bool) (*memNode, error) {
+	names := path.Names()
+	root := m.getRoot()
+	node := root
+	resolved := Path("")
+	links := 0
+	for i := 0; i < len(names); i++ {
+		if !node.isDir() {
+			return nil, &DefaultError{Message: "not a bucket: " + resolved.String(), Code: ENOTDIR, DetailsPayload: []string{path.String()}}
+		}
+		child := node.children[names[i]]
+		if child == nil {
+			return nil, &DefaultError{Message: path.String(), Code: ENOENT, DetailsPayload: []string{path.String()}}
+		}
+		if len(child.link) > 0 && (i < len(names)-1 || followLast) {
+			links++
+			if links > maxSymLinks {
+				return nil, &DefaultError{Message: path.String(), Code: ELOOP, DetailsPayload: []string{path.String()}}
+			}
+			target := Path(child.link).Resolve(resolved)
+			names = append(target.Names(), names[i+1:]...)
+			node = root
+			resolved = ""
+			i = -1
+			continue
+		}
+		node = child
+		resolved = resolved.Child(names[i])
+	}
+	return node, nil
+}
+
+// ensureBucket creates all missing buckets of the path. Returns ENOTDIR if a segment is not a bucket.
+func (m *MemFS) ensureBucket(path Path) (*memNode, error) {
+	node := m.getRoot()
+	current := Path("")
+	for _, name := range path.Names() {
+		current = current.Child(name)
+		child := node.children[name]
+		if child == nil {
+			child = newMemBucket()
+			node.children[name] = child
+			node.inode.modTime = time.Now()
+		} else if len(child.link) > 0 {
+			resolved, err := m.lookup(current, true)
+			if err != nil {
+				return nil, err
+			}
+			child = resolved
+		}
+		if !child.isDir() {
+			return nil, &DefaultError{Message: "not a bucket: " + current.String(), Code: ENOTDIR, DetailsPayload: []string{current.String()}}
+		}
+		node = child
+	}
+	return node, nil
+}
+
+// entry creates a snapshot of the node, caller must hold the lock.
+func (m *MemFS) entry(name string, node *memNode) *DefaultEntry {
+	return memEntry(name, node.isDir(), node.link, node.inode)
+}
+
+func memEntry(name string, isDir bool, link string, inode *memInode) *DefaultEntry {
+	inode.lock.RLock()
+	defer inode.lock.RUnlock()
+	attrs := &MemAttrs{Modified: inode.modTime, Target: link}
+	if len(inode.attrs) > 0 {
+		attrs.Values = make(map[string]interface{}, len(inode.attrs))
+		for k, v := range inode.attrs {
+			attrs.Values[k] = v
+		}
+	}
+	length := int64(len(inode.data))
+	if isDir {
+		length = 0
+	}
+	return &DefaultEntry{Id: name, IsBucket: isDir, Length: length, Data: attrs}
+}
+
+func (m *MemFS) Connect(ctx context.Context, path string, options interface{}) (interface{}, error) {
+	return options, nil
+}
+
+func (m *MemFS) Disconnect(ctx context.Context, path string) error {
+	return nil
+}
+
+// FireEvent notifies all listeners which have been registered for the path or one of its parents. The first
+// error returned by a listener is returned immediately.
+func (m *MemFS) FireEvent(ctx context.Context, path string, event interface{}) error {
+	m.getRoot()
+	p := Path(path).Normalize()
+	m.listenersLock.Lock()
+	matching := make([]ResourceListener, 0, len(m.listeners))
+	for _, l := range m.listeners {
+		if p == l.path || l.path == "/" || strings.HasPrefix(string(p), string(l.path)+"/") {
+			matching = append(matching, l.listener)
+		}
+	}
+	m.listenersLock.Unlock()
+
+	for _, listener := range matching {
+		if err := listener.OnEvent(path, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemFS) AddListener(ctx context.Context, path string, listener ResourceListener) (handle int, err error) {
+	m.getRoot()
+	m.listenersLock.Lock()
+	defer m.listenersLock.Unlock()
+	m.lastHandle++
+	m.listeners[m.lastHandle] = &builderPathListener{path: Path(path).Normalize(), listener: listener}
+	return m.lastHandle, nil
+}
+
+func (m *MemFS) RemoveListener(ctx context.Context, handle int) error {
+	m.getRoot()
+	m.listenersLock.Lock()
+	defer m.listenersLock.Unlock()
+	delete(m.listeners, handle)
+	return nil
+}
+
+func (m *MemFS) Begin(ctx context.Context, path string, options interface{}) (context.Context, error) {
+	return nil, NewENOSYS("Begin transaction not supported", m)
+}
+
+func (m *MemFS) Commit(ctx context.Context) error {
+	return NewENOSYS("Commit transaction not supported", m)
+}
+
+func (m *MemFS) Rollback(ctx context.Context) error {
+	return NewENOSYS("Rollback transaction not supported", m)
+}
+
+func (m *MemFS) Open(ctx context.Context, path string, flag int, options interface{}) (Blob, error) {
+	if err := m.FireEvent(ctx, path, EventBeforeOpen); err != nil {
+		return nil, err
+	}
+	p, fork := splitFork(path)
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	create := flag&os.O_CREATE != 0
+
+	if writable || create {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+	} else {
+		m.lock.RLock()
+		defer m.lock.RUnlock()
+	}
+
+	node, err := m.lookup(p, true)
+	switch {
+	case err != nil && create && IsErr(err, ENOENT):
+		parent, err := m.ensureBucket(p.Parent())
+		if err != nil {
+			return nil, err
+		}
+		if p.NameCount() == 0 || parent.children[p.Name()] != nil {
+			// the root or a dangling symbolic link
+			return nil, &DefaultError{Message: "cannot create " + p.String(), Code: ENOENT, DetailsPayload: []string{p.String()}}
+		}
+		node = &memNode{inode: &memInode{modTime: time.Now()}}
+		parent.children[p.Name()] = node
+		parent.inode.modTime = time.Now()
+	case err != nil:
+		return nil, err
+	case create && flag&os.O_EXCL != 0:
+		return nil, &DefaultError{Message: p.String(), Code: EEXIST, DetailsPayload: []string{p.String()}}
+	}
+
+	if node.isDir() && len(fork) == 0 {
+		return nil, &DefaultError{Message: p.String(), Code: EISDIR, DetailsPayload: []string{p.String()}}
+	}
+
+	inode := node.inode
+	if len(fork) > 0 {
+		forkNode := inode.forks[fork]
+		if forkNode == nil {
+			if !create {
+				return nil, &DefaultError{Message: path, Code: ENOENT, DetailsPayload: []string{path}}
+			}
+			if inode.forks == nil {
+				inode.forks = make(map[string]*memInode)
+			}
+			forkNode = &memInode{modTime: time.Now()}
+			inode.forks[fork] = forkNode
+		}
+		inode = forkNode
+	}
+
+	if writable && flag&os.O_TRUNC != 0 {
+		inode.lock.Lock()
+		inode.data = nil
+		inode.modTime = time.Now()
+		inode.lock.Unlock()
+	}
+
+	return &memBlob{inode: inode, flag: flag}, nil
+}
+
+// Delete removes the entry and all its children. A symbolic link itself is removed and not its target.
+// A fork is removed, if the path contains a colon.
+func (m *MemFS) Delete(ctx context.Context, path string) error {
+	if err := m.FireEvent(ctx, path, EventBeforeDelete); err != nil {
+		return err
+	}
+	p, fork := splitFork(path)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if len(fork) > 0 {
+		node, err := m.lookup(p, true)
+		if err == nil {
+			delete(node.inode.forks, fork)
+		}
+		return nil
+	}
+
+	if p.NameCount() == 0 {
+		m.getRoot().children = make(map[string]*memNode)
+		return nil
+	}
+
+	parent, err := m.lookup(p.Parent(), true)
+	if err != nil || !parent.isDir() {
+		// does not exist anyway
+		return nil
+	}
+	if _, ok := parent.children[p.Name()]; ok {
+		delete(parent.children, p.Name())
+		parent.inode.modTime = time.Now()
+	}
+	return nil
+}
+
+func (m *MemFS) ReadAttrs(ctx context.Context, path string, args interface{}) (Entry, error) {
+	if err := m.FireEvent(ctx, path, EventBeforeReadAttrs); err != nil {
+		return nil, err
+	}
+	p, fork := splitFork(path)
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	node, err := m.lookup(p, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(fork) > 0 {
+		inode := node.inode.forks[fork]
+		if inode == nil {
+			return nil, &DefaultError{Message: path, Code: ENOENT, DetailsPayload: []string{path}}
+		}
+		return readEntryInto(memEntry(fork, false, "", inode), args), nil
+	}
+	return readEntryInto(m.entry(p.Name(), node), args), nil
+}
+
+// ReadForks returns the sorted names of all forks.
+func (m *MemFS) ReadForks(ctx context.Context, path string) ([]string, error) {
+	p, _ := splitFork(path)
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	node, err := m.lookup(p, true)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0, len(node.inode.forks))
+	for name := range node.inode.forks {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+// WriteAttrs merges the given map[string]interface{} into the attributes of the entry. Any other type results
+// in EUNATTR.
+func (m *MemFS) WriteAttrs(ctx context.Context, path string, src interface{}) (Entry, error) {
+	if err := m.FireEvent(ctx, path, EventBeforeWriteAttrs); err != nil {
+		return nil, err
+	}
+	values, ok := src.(map[string]interface{})
+	if !ok && src != nil {
+		return nil, NewErr().UnsupportedAttributes("WriteAttrs", src)
+	}
+	p, fork := splitFork(path)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	node, err := m.lookup(p, true)
+	if err != nil {
+		return nil, err
+	}
+	inode := node.inode
+	name := p.Name()
+	if len(fork) > 0 {
+		inode = node.inode.forks[fork]
+		if inode == nil {
+			return nil, &DefaultError{Message: path, Code: ENOENT, DetailsPayload: []string{path}}
+		}
+		name = fork
+	}
+
+	inode.lock.Lock()
+	for k, v := range values {
+		if v == nil {
+			delete(inode.attrs, k)
+			continue
+		}
+		if inode.attrs == nil {
+			inode.attrs = make(map[string]interface{})
+		}
+		inode.attrs[k] = v
+	}
+	inode.lock.Unlock()
+
+	if len(fork) > 0 {
+		return memEntry(name, false, "", inode), nil
+	}
+	return m.entry(name, node), nil
+}
+
+// ReadBucket returns all entries sorted by name within a single page. Symbolic links are not resolved.
+func (m *MemFS) ReadBucket(ctx context.Context, path string, options interface{}) (ResultSet, error) {
+	if err := m.FireEvent(ctx, path, EventBeforeBucketRead); err != nil {
+		return nil, err
+	}
+	if path == "/:" {
+		// we have no hidden endpoints
+		return &DefaultResultSet{}, nil
+	}
+	p, fork := splitFork(path)
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	node, err := m.lookup(p, true)
+	if err != nil {
+		return nil, err
+	}
+	if !node.isDir() || len(fork) > 0 {
+		return nil, &DefaultError{Message: "not a bucket: " + path, Code: ENOENT, DetailsPayload: []string{path}}
+	}
+
+	names := make([]string, 0, len(node.children))
+	for name := range node.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	entries := make([]*DefaultEntry, len(names))
+	for i, name := range names {
+		entries[i] = m.entry(name, node.children[name])
+	}
+	return &DefaultResultSet{entries}, nil
+}
+
+func (m *MemFS) Invoke(ctx context.Context, endpoint string, args ...interface{}) (interface{}, error) {
+	return nil, NewENOSYS("Invoke not supported", m)
+}
+
+// MkBucket creates all missing buckets and returns ENOTDIR if any segment refers to a blob.
+func (m *MemFS) MkBucket(ctx context.Context, path string, options interface{}) error {
+	if err := m.FireEvent(ctx, path, EventBeforeMkBucket); err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	_, err := m.ensureBucket(Path(path).Normalize())
+	return err
+}
+
+// detach looks up the parent of path and returns it together with the (not resolved) child.
+func (m *MemFS) detach(path Path) (parent *memNode, child *memNode, err error) {
+	parent, err = m.lookup(path.Parent(), true)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !parent.isDir() {
+		return nil, nil, &DefaultError{Message: "not a bucket: " + path.Parent().String(), Code: ENOTDIR, DetailsPayload: []string{path.String()}}
+	}
+	child = parent.children[path.Name()]
+	if child == nil {
+		return nil, nil, &DefaultError{Message: path.String(), Code: ENOENT, DetailsPayload: []string{path.String()}}
+	}
+	return parent, child, nil
+}
+
+// checkOldNew normalizes both paths, rejects forks and the root and checks that newPath is not inside oldPath.
+func checkOldNew(oldPath string, newPath string) (Path, Path, error) {
+	oldP, oldFork := splitFork(oldPath)
+	newP, newFork := splitFork(newPath)
+	if len(oldFork) > 0 || len(newFork) > 0 {
+		return "", "", &DefaultError{Message: "forks are not supported", Code: EINVAL, DetailsPayload: []string{oldPath, newPath}}
+	}
+	if oldP.NameCount() == 0 || newP.NameCount() == 0 {
+		return "", "", &DefaultError{Message: "the root is not supported", Code: EINVAL, DetailsPayload: []string{oldPath, newPath}}
+	}
+	if strings.HasPrefix(string(newP), string(oldP)+"/") {
+		return "", "", &DefaultError{Message: "cannot move into itself", Code: EINVAL, DetailsPayload: []string{oldPath, newPath}}
+	}
+	return oldP, newP, nil
+}
+
+// Rename moves the entry and replaces anything at newPath. Missing parent buckets of newPath are created.
+func (m *MemFS) Rename(ctx context.Context, oldPath string, newPath string) error {
+	if err := m.FireEvent(ctx, oldPath, EventBeforeRename); err != nil {
+		return err
+	}
+	oldP, newP, err := checkOldNew(oldPath, newPath)
+	if err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	oldParent, node, err := m.detach(oldP)
+	if err != nil {
+		return err
+	}
+	if oldP == newP {
+		return nil
+	}
+	newParent, err := m.ensureBucket(newP.Parent())
+	if err != nil {
+		return err
+	}
+	delete(oldParent.children, oldP.Name())
+	newParent.children[newP.Name()] = node
+	oldParent.inode.modTime = time.Now()
+	newParent.inode.modTime = time.Now()
+	return nil
+}
+
+// SymLink creates a symbolic link at newPath which points to oldPath. A relative oldPath is resolved against
+// the parent of newPath. The target does not need to exist.
+func (m *MemFS) SymLink(ctx context.Context, oldPath string, newPath string) error {
+	if err := m.FireEvent(ctx, newPath, EventBeforeSymLink); err != nil {
+		return err
+	}
+	newP, fork := splitFork(newPath)
+	if len(fork) > 0 || newP.NameCount() == 0 || len(oldPath) == 0 {
+		return &DefaultError{Message: "invalid link", Code: EINVAL, DetailsPayload: []string{oldPath, newPath}}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	parent, err := m.ensureBucket(newP.Parent())
+	if err != nil {
+		return err
+	}
+	if parent.children[newP.Name()] != nil {
+		return &DefaultError{Message: newP.String(), Code: EEXIST, DetailsPayload: []string{newP.String()}}
+	}
+	parent.children[newP.Name()] = &memNode{link: oldPath, inode: &memInode{modTime: time.Now()}}
+	parent.inode.modTime = time.Now()
+	return nil
+}
+
+// HardLink creates a new named entry for an existing blob. Buckets are not supported and return EISDIR.
+func (m *MemFS) HardLink(ctx context.Context, oldPath string, newPath string) error {
+	if err := m.FireEvent(ctx, newPath, EventBeforeHardLink); err != nil {
+		return err
+	}
+	oldP, newP, err := checkOldNew(oldPath, newPath)
+	if err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	node, err := m.lookup(oldP, true)
+	if err != nil {
+		return err
+	}
+	if node.isDir() {
+		return &DefaultError{Message: oldP.String(), Code: EISDIR, DetailsPayload: []string{oldP.String()}}
+	}
+	parent, err := m.ensureBucket(newP.Parent())
+	if err != nil {
+		return err
+	}
+	if parent.children[newP.Name()] != nil {
+		return &DefaultError{Message: newP.String(), Code: EEXIST, DetailsPayload: []string{newP.String()}}
+	}
+	parent.children[newP.Name()] = &memNode{inode: node.inode}
+	parent.inode.modTime = time.Now()
+	return nil
+}
+
+// RefLink performs a deep copy of blobs and buckets, including attributes and forks. An existing newPath
+// is replaced.
+func (m *MemFS) RefLink(ctx context.Context, oldPath string, newPath string) error {
+	if err := m.FireEvent(ctx, newPath, EventBeforeRefLink); err != nil {
+		return err
+	}
+	oldP, newP, err := checkOldNew(oldPath, newPath)
+	if err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	node, err := m.lookup(oldP, true)
+	if err != nil {
+		return err
+	}
+	if oldP == newP {
+		return nil
+	}
+	parent, err := m.ensureBucket(newP.Parent())
+	if err != nil {
+		return err
+	}
+	parent.children[newP.Name()] = node.deepCopy()
+	parent.inode.modTime = time.Now()
+	return nil
+}
+
+func (n *memNode) deepCopy() *memNode {
+	cpy := &memNode{link: n.link, inode: n.inode.deepCopy()}
+	if n.children != nil {
+		cpy.children = make(map[string]*memNode, len(n.children))
+		for name, child := range n.children {
+			cpy.children[name] = child.deepCopy()
+		}
+	}
+	return cpy
+}
+
+func (i *memInode) deepCopy() *memInode {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	cpy := &memInode{modTime: time.Now(), data: append([]byte(nil), i.data...)}
+	if i.attrs != nil {
+		cpy.attrs = make(map[string]interface{}, len(i.attrs))
+		for k, v := range i.attrs {
+			cpy.attrs[k] = v
+		}
+	}
+	if i.forks != nil {
+		cpy.forks = make(map[string]*memInode, len(i.forks))
+		for name, fork := range i.forks {
+			cpy.forks[name] = fork.deepCopy()
+		}
+	}
+	return cpy
+}
+
+// Close does nothing, the content is kept.
+func (m *MemFS) Close() error {
+	return nil
+}
+
+func (m *MemFS) String() string {
+	return "MemFS"
+}
+
+//==
+
+// A memBlob is an opened memInode with its own position.
+type memBlob struct {
+	inode  *memInode
+	flag   int
+	lock   sync.Mutex // guards pos
+	pos    int64
+	closed int32
+}
+
+func (b *memBlob) isClosed() bool {
+	return atomic.LoadInt32(&b.closed) == 1
+}
+
+func (b *memBlob) readable() error {
+	if b.isClosed() {
+		return &DefaultError{Message: "blob already closed", Code: EBADF}
+	}
+	if b.flag&(os.O_WRONLY|os.O_RDWR) == os.O_WRONLY {
+		return &DefaultError{Message: "blob opened write only", Code: EBADF}
+	}
+	return nil
+}
+
+func (b *memBlob) writable() error {
+	if b.isClosed() {
+		return &DefaultError{Message: "blob already closed", Code: EBADF}
+	}
+	if b.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return &DefaultError{Message: "blob opened read only", Code: EBADF}
+	}
+	return nil
+}
+
+func (b *memBlob) ReadAt(p []byte, off int64) (n int, err error) {
+	if err := b.readable(); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, &DefaultError{Message: "negative offset", Code: EINVAL}
+	}
+	return b.readAt(p, off)
+}
+
+func (b *memBlob) readAt(p []byte, off int64) (n int, err error) {
+	b.inode.lock.RLock()
+	defer b.inode.lock.RUnlock()
+	if off >= int64(len(b.inode.data)) {
+		return 0, io.EOF
+	}
+	n = copy(p, b.inode.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (b *memBlob) Read(p []byte) (n int, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err := b.readable(); err != nil {
+		return 0, err
+	}
+	n, err = b.readAt(p, b.pos)
+	b.pos += int64(n)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (b *memBlob) WriteAt(p []byte, off int64) (n int, err error) {
+	if err := b.writable(); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, &DefaultError{Message: "negative offset", Code: EINVAL}
+	}
+	if b.flag&os.O_APPEND != 0 {
+		return 0, &DefaultError{Message: "WriteAt not allowed with O_APPEND", Code: EINVAL}
+	}
+	b.writeAt(p, off, false)
+	return len(p), nil
+}
+
+// writeAt grows the data if required and returns the offset after the last written byte.
+func (b *memBlob) writeAt(p []byte, off int64, appending bool) int64 {
+	b.inode.lock.Lock()
+	defer b.inode.lock.Unlock()
+	if appending {
+		off = int64(len(b.inode.data))
+	}
+	end := off + int64(len(p))
+	if end > int64(len(b.inode.data)) {
+		if end > int64(cap(b.inode.data)) {
+			grown := make([]byte, end, end*2)
+			copy(grown, b.inode.data)
+			b.inode.data = grown
+		} else {
+			// the spare capacity may contain stale bytes of a truncated content
+			oldLen := len(b.inode.data)
+			b.inode.data = b.inode.data[:end]
+			for i := oldLen; i < int(off); i++ {
+				b.inode.data[i] = 0
+			}
+		}
+	}
+	copy(b.inode.data[off:], p)
+	b.inode.modTime = time.Now()
+	return end
+}
+
+func (b *memBlob) Write(p []byte) (n int, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err := b.writable(); err != nil {
+		return 0, err
+	}
+	b.pos = b.writeAt(p, b.pos, b.flag&os.O_APPEND != 0)
+	return len(p), nil
+}
+
+func (b *memBlob) Seek(offset int64, whence int) (int64, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.isClosed() {
+		return 0, &DefaultError{Message: "blob already closed", Code: EBADF}
+	}
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = b.pos + offset
+	case io.SeekEnd:
+		b.inode.lock.RLock()
+		abs = int64(len(b.inode.data)) + offset
+		b.inode.lock.RUnlock()
+	default:
+		return 0, &DefaultError{Message: "invalid whence", Code: EINVAL}
+	}
+	if abs < 0 {
+		return 0, &DefaultError{Message: "negative position", Code: EINVAL}
+	}
+	b.pos = abs
+	return abs, nil
+}
+
+// Close marks the blob as closed, so that further reads or writes fail with EBADF.
+func (b *memBlob) Close() error {
+	atomic.StoreInt32(&b.closed, 1)
+	return nil
+}
@@ -0,0 +1,446 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func memWrite(t *testing.T, fs FileSystem, path string, data string) {
+	t.Helper()
+	blob, err := fs.Open(context.Background(), path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blob.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := blob.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func memRead(t *testing.T, fs FileSystem, path string) string {
+	t.Helper()
+	blob, err := fs.Open(context.Background(), path, os.O_RDONLY, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silentClose(blob)
+	data, err := ioutil.ReadAll(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestMemFS_OpenReadWrite(t *testing.T) {
+	fs := &MemFS{}
+	ctx := context.Background()
+
+	_, err := fs.Open(ctx, "/a/b.txt", os.O_RDONLY, nil)
+	if !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+
+	memWrite(t, fs, "/a/b.txt", "hello world")
+	if str := memRead(t, fs, "/a/b.txt"); str != "hello world" {
+		t.Fatal("expected hello world but got", str)
+	}
+
+	blob, err := fs.Open(ctx, "/a/b.txt", os.O_RDWR, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blob.WriteAt([]byte("W"), 6); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blob.WriteAt([]byte("!"), 13); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	n, err := blob.ReadAt(buf, 6)
+	if err != nil || n != 5 || string(buf) != "World" {
+		t.Fatal("unexpected ReadAt", n, err, string(buf))
+	}
+	n, err = blob.ReadAt(buf, 12)
+	if err != io.EOF || n != 2 || !bytes.Equal(buf[:2], []byte{0, '!'}) {
+		t.Fatal("expected EOF with 2 bytes but got", n, err)
+	}
+	pos, err := blob.Seek(-3, io.SeekEnd)
+	if err != nil || pos != 11 {
+		t.Fatal("unexpected seek", pos, err)
+	}
+	if _, err := blob.Seek(-1, io.SeekStart); !IsErr(err, EINVAL) {
+		t.Fatal("expected EINVAL but got", err)
+	}
+	if err := blob.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blob.Read(buf); !IsErr(err, EBADF) {
+		t.Fatal("expected EBADF but got", err)
+	}
+
+	// append and read only
+	blob, err = fs.Open(ctx, "/a/b.txt", os.O_WRONLY|os.O_APPEND, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blob.Write([]byte("?")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blob.Read(buf); !IsErr(err, EBADF) {
+		t.Fatal("expected EBADF but got", err)
+	}
+	silentClose(blob)
+	if str := memRead(t, fs, "/a/b.txt"); str != "hello World\x00\x00!?" {
+		t.Fatal("unexpected content", strconv.Quote(str))
+	}
+
+	_, err = fs.Open(ctx, "/a/b.txt", os.O_CREATE|os.O_EXCL|os.O_WRONLY, nil)
+	if !IsErr(err, EEXIST) {
+		t.Fatal("expected EEXIST but got", err)
+	}
+
+	_, err = fs.Open(ctx, "/a", os.O_RDONLY, nil)
+	if !IsErr(err, EISDIR) {
+		t.Fatal("expected EISDIR but got", err)
+	}
+
+	_, err = fs.Open(ctx, "/a/b.txt/c", os.O_CREATE|os.O_WRONLY, nil)
+	if !IsErr(err, ENOTDIR) {
+		t.Fatal("expected ENOTDIR but got", err)
+	}
+}
+
+func TestMemFS_TruncateSparse(t *testing.T) {
+	fs := &MemFS{}
+	ctx := context.Background()
+	memWrite(t, fs, "/a.txt", "hello")
+
+	blob, err := fs.Open(ctx, "/a.txt", os.O_WRONLY|os.O_TRUNC, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blob.Seek(5, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blob.Write([]byte("X")); err != nil {
+		t.Fatal(err)
+	}
+	if err := blob.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if str := memRead(t, fs, "/a.txt"); str != "\x00\x00\x00\x00\x00X" {
+		t.Fatal("expected a sparse content but got", []byte(str))
+	}
+
+	// a sparse write into the spare capacity
+	blob, err = fs.Open(ctx, "/a.txt", os.O_WRONLY, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blob.Seek(8, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blob.Write([]byte("Y")); err != nil {
+		t.Fatal(err)
+	}
+	_ = blob.Close()
+	if str := memRead(t, fs, "/a.txt"); str != "\x00\x00\x00\x00\x00X\x00\x00Y" {
+		t.Fatal("expected a sparse content but got", []byte(str))
+	}
+}
+
+func TestMemFS_Buckets(t *testing.T) {
+	fs := &MemFS{}
+	ctx := context.Background()
+
+	_, err := fs.ReadBucket(ctx, "/missing", nil)
+	if !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+
+	if err := fs.MkBucket(ctx, "/x/y/z", nil); err != nil {
+		t.Fatal(err)
+	}
+	memWrite(t, fs, "/x/b", "1")
+	memWrite(t, fs, "/x/a", "22")
+
+	res, err := fs.ReadBucket(ctx, "/x", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Len() != 3 {
+		t.Fatal("expected 3 but got", res.Len())
+	}
+	expected := []string{"a", "b", "y"}
+	for i, name := range expected {
+		entry := res.ReadAttrs(i, &DefaultEntry{})
+		if entry.Name() != name {
+			t.Fatal("expected", name, "but got", entry.Name())
+		}
+	}
+	if err := res.Next(ctx); !IsErr(err, EOF) {
+		t.Fatal("expected EOF but got", err)
+	}
+
+	if err := fs.MkBucket(ctx, "/x/a/c", nil); !IsErr(err, ENOTDIR) {
+		t.Fatal("expected ENOTDIR but got", err)
+	}
+
+	if _, err := fs.ReadBucket(ctx, "/x/a", nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+
+	if err := fs.Delete(ctx, "/x/y"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Delete(ctx, "/x/y"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Delete(ctx, "/not/existing/at/all"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.ReadAttrs(ctx, "/x/y/z", nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+
+	info, err := memStat(fs, "/x/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 2 || info.IsDir() || info.ModTime().IsZero() {
+		t.Fatal("unexpected info", info.Size(), info.IsDir(), info.ModTime())
+	}
+}
+
+// memStat is like Stat but for the given filesystem
+func memStat(fs FileSystem, path string) (os.FileInfo, error) {
+	entry, err := fs.ReadAttrs(context.Background(), path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return entryDelegator{entry}, nil
+}
+
+func TestMemFS_RenameAndLinks(t *testing.T) {
+	fs := &MemFS{}
+	ctx := context.Background()
+
+	memWrite(t, fs, "/a", "a")
+	memWrite(t, fs, "/b", "b")
+
+	if err := fs.Rename(ctx, "/missing", "/c"); !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+	if err := fs.Rename(ctx, "/a", "/b"); err != nil {
+		t.Fatal(err)
+	}
+	if str := memRead(t, fs, "/b"); str != "a" {
+		t.Fatal("expected a but got", str)
+	}
+	if _, err := fs.ReadAttrs(ctx, "/a", nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+	if err := fs.MkBucket(ctx, "/dir", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rename(ctx, "/dir", "/dir/sub"); !IsErr(err, EINVAL) {
+		t.Fatal("expected EINVAL but got", err)
+	}
+
+	// hard links share the content
+	if err := fs.HardLink(ctx, "/b", "/dir/hard"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.HardLink(ctx, "/b", "/dir/hard"); !IsErr(err, EEXIST) {
+		t.Fatal("expected EEXIST but got", err)
+	}
+	if err := fs.HardLink(ctx, "/dir", "/dir2"); !IsErr(err, EISDIR) {
+		t.Fatal("expected EISDIR but got", err)
+	}
+	memWrite(t, fs, "/dir/hard", "changed")
+	if str := memRead(t, fs, "/b"); str != "changed" {
+		t.Fatal("expected changed but got", str)
+	}
+
+	// ref links are copies
+	if err := fs.RefLink(ctx, "/b", "/dir/ref"); err != nil {
+		t.Fatal(err)
+	}
+	memWrite(t, fs, "/dir/ref", "copy")
+	if str := memRead(t, fs, "/b"); str != "changed" {
+		t.Fatal("expected changed but got", str)
+	}
+
+	// symbolic links are resolved, also relative and in the middle
+	if err := fs.SymLink(ctx, "dir", "/sym"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.SymLink(ctx, "dir", "/sym"); !IsErr(err, EEXIST) {
+		t.Fatal("expected EEXIST but got", err)
+	}
+	if str := memRead(t, fs, "/sym/ref"); str != "copy" {
+		t.Fatal("expected copy but got", str)
+	}
+	res, err := fs.ReadBucket(ctx, "/sym", nil)
+	if err != nil || res.Len() != 2 {
+		t.Fatal("unexpected bucket", res, err)
+	}
+	if err := fs.SymLink(ctx, "/loop2", "/loop1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.SymLink(ctx, "/loop1", "/loop2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.ReadAttrs(ctx, "/loop1", nil); !IsErr(err, ELOOP) {
+		t.Fatal("expected ELOOP but got", err)
+	}
+	entry, err := fs.ReadAttrs(ctx, "/sym", nil)
+	if err != nil || !entry.IsDir() {
+		t.Fatal("expected resolved bucket", entry, err)
+	}
+
+	// deleting the link keeps the target
+	if err := fs.Delete(ctx, "/sym"); err != nil {
+		t.Fatal(err)
+	}
+	if str := memRead(t, fs, "/dir/ref"); str != "copy" {
+		t.Fatal("expected copy but got", str)
+	}
+}
+
+func TestMemFS_AttrsAndForks(t *testing.T) {
+	fs := &MemFS{}
+	ctx := context.Background()
+	memWrite(t, fs, "/img.jpg", "image")
+	memWrite(t, fs, "/img.jpg:thumbs/720p", "thumb")
+
+	forks, err := fs.ReadForks(ctx, "/img.jpg")
+	if err != nil || len(forks) != 1 || forks[0] != "thumbs/720p" {
+		t.Fatal("unexpected forks", forks, err)
+	}
+	if str := memRead(t, fs, "/img.jpg:thumbs/720p"); str != "thumb" {
+		t.Fatal("expected thumb but got", str)
+	}
+	if str := memRead(t, fs, "/img.jpg"); str != "image" {
+		t.Fatal("expected image but got", str)
+	}
+
+	_, err = fs.WriteAttrs(ctx, "/img.jpg", map[string]interface{}{"rating": 5, "tag": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = fs.WriteAttrs(ctx, "/img.jpg", map[string]interface{}{"tag": nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fs.WriteAttrs(ctx, "/img.jpg", 5); !IsErr(err, EUNATTR) {
+		t.Fatal("expected EUNATTR but got", err)
+	}
+
+	dst := make(map[string]interface{})
+	entry, err := fs.ReadAttrs(ctx, "/img.jpg", dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs := entry.Sys().(*MemAttrs)
+	if len(attrs.Values) != 1 || attrs.Values["rating"] != 5 {
+		t.Fatal("unexpected attributes", attrs.Values)
+	}
+	if dst[mapEntrySize] != int64(5) {
+		t.Fatal("expected 5 but got", dst[mapEntrySize])
+	}
+
+	if err := fs.Delete(ctx, "/img.jpg:thumbs/720p"); err != nil {
+		t.Fatal(err)
+	}
+	forks, _ = fs.ReadForks(ctx, "/img.jpg")
+	if len(forks) != 0 {
+		t.Fatal("expected no forks but got", forks)
+	}
+}
+
+type memTestListener struct {
+	events []string
+	err    error
+}
+
+func (l *memTestListener) OnEvent(path string, event interface{}) error {
+	l.events = append(l.events, fmt.Sprintf("%v %s", event, path))
+	return l.err
+}
+
+func TestMemFS_Listener(t *testing.T) {
+	fs := &MemFS{}
+	ctx := context.Background()
+	listener := &memTestListener{}
+	hnd, err := fs.AddListener(ctx, "/a", listener)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memWrite(t, fs, "/a/b", "x")
+	memWrite(t, fs, "/ab", "x")
+	if len(listener.events) != 1 || listener.events[0] != EventBeforeOpen+" /a/b" {
+		t.Fatal("unexpected events", listener.events)
+	}
+
+	listener.err = &DefaultError{Code: EACCES}
+	if err := fs.Delete(ctx, "/a/b"); !IsErr(err, EACCES) {
+		t.Fatal("expected EACCES but got", err)
+	}
+	listener.err = nil
+	if str := memTryRead(fs, "/a/b"); str != "x" {
+		t.Fatal("delete should have been intercepted")
+	}
+
+	if err := fs.RemoveListener(ctx, hnd); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Delete(ctx, "/a/b"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func memTryRead(fs FileSystem, path string) string {
+	blob, err := fs.Open(context.Background(), path, os.O_RDONLY, nil)
+	if err != nil {
+		return ""
+	}
+	defer silentClose(blob)
+	data, _ := ioutil.ReadAll(blob)
+	return string(data)
+}
+
+func TestMemFS_Concurrent(t *testing.T) {
+	fs := &MemFS{}
+	ctx := context.Background()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				path := fmt.Sprintf("/dir%d/file%d", i%2, j)
+				blob, err := fs.Open(ctx, path, os.O_CREATE|os.O_RDWR, nil)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				_, _ = blob.WriteAt([]byte{byte(i)}, int64(i))
+				_, _ = blob.ReadAt(make([]byte, 1), 0)
+				_ = blob.Close()
+				_, _ = fs.ReadBucket(ctx, fmt.Sprintf("/dir%d", i%2), nil)
+				_ = fs.Delete(ctx, path)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
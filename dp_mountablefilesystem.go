@@ -2,23 +2,25 @@ package vfs
 
 import (
 	"context"
+	"os"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 var _ FileSystem = (*MountableFileSystem)(nil)
 
+// a virtualDir is a node of the mount tree, which may contain a mounted FileSystem and other nodes
 type virtualDir struct {
-	children []*namedEntry
+	name     string
+	children []*virtualDir
+	// mount is nil, if the node is only an intermediate path
+	mount *MountInfo
 }
 
-type namedEntry struct {
-	name string
-	// either a *virtualEntry or a FileSystem
-	data interface{}
-}
-
-// Returns the namedEntry or nil
-func (d *virtualDir) ChildByName(name string) *namedEntry {
+// Returns the child or nil
+func (d *virtualDir) ChildByName(name string) *virtualDir {
 	for _, child := range d.children {
 		if child.name == name {
 			return child
@@ -27,55 +29,100 @@ func (d *virtualDir) ChildByName(name string) *namedEntry {
 	return nil
 }
 
-// Removes and returns the child, if any
-func (d *virtualDir) RemoveChild(name string) *namedEntry {
-	index := -1
-	for idx, child := range d.children {
-		if child.name == name {
-			index = idx
-			break
+// entries returns synthetic bucket entries for all children, sorted by name
+func (d *virtualDir) entries() []*DefaultEntry {
+	entries := make([]*DefaultEntry, 0, len(d.children))
+	for _, child := range d.children {
+		entries = append(entries, &DefaultEntry{Id: child.name, IsBucket: true, Length: -1})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Id < entries[j].Id
+	})
+	return entries
+}
+
+// with returns a copy of the node, in which apply has been invoked on a copy of the node of the path. Nodes
+// without mount and children are removed.
+func (d *virtualDir) with(names []string, apply func(node *virtualDir)) *virtualDir {
+	node := &virtualDir{name: d.name, mount: d.mount}
+	if len(names) == 0 {
+		node.children = d.children
+		apply(node)
+		return node
+	}
+	node.children = make([]*virtualDir, 0, len(d.children)+1)
+	child := &virtualDir{name: names[0]}
+	for _, c := range d.children {
+		if c.name == names[0] {
+			child = c
+			continue
 		}
+		node.children = append(node.children, c)
 	}
-	if index == -1 {
-		return nil
+	if child = child.with(names[1:], apply); child.mount != nil || len(child.children) > 0 {
+		node.children = append(node.children, child)
 	}
-	c := d.children[index]
-	d.children = append(d.children[:index], d.children[index+1:]...)
-	return c
+	return node
+}
+
+// MountOptions configure a single mount point.
+type MountOptions struct {
+	// ReadOnly rejects all modifications with EROFS
+	ReadOnly bool
+}
+
+// MountInfo describes a mounted FileSystem.
+type MountInfo struct {
+	// MountPoint is the absolute path of the mount
+	MountPoint string
+	// Provider is the mounted FileSystem
+	Provider FileSystem
+	// Options of the mount
+	Options MountOptions
 }
 
+// a wrappedHandle is a listener of the MountableFileSystem. It is registered at the FileSystem which has been
+// mounted at the path at that time, if any.
 type wrappedHandle struct {
-	handle int
-	fs     FileSystem
+	handle   int
+	fs       FileSystem
+	path     Path
+	listener ResourceListener
 }
 
-// A MountableFileSystem contains only other DataProviders mounted under a path. Mounting cross paths is not
-// supported.
+// A MountableFileSystem contains only other DataProviders mounted under a path. Mount points can be nested,
+// the longest matching mount point wins.
 //
 // Example
 //
-// If you have /my/dir/provider0 and mount /my/dir/provider0/some/dir/provider1 the existing provider0 will be removed.
+// If you have /my/dir/provider0 and mount /my/dir/provider0/some/dir/provider1, all paths below
+// /my/dir/provider0/some/dir/provider1 are resolved by provider1 and all others by provider0.
+//
+// Details
+//
+//  * Rename across mount points falls back to a copy followed by a delete of the old path, see CopyFS.
+//    If TransactionalRename is set and both mounted FileSystems support Begin, the copy and the delete are
+//    performed in transactions. The new path is committed first, so a failure never loses data.
+//  * RefLink across mount points falls back to a streaming copy
+//  * HardLink and SymLink across mount points return EXDEV
+//  * the intermediate paths of mount points, including the root, are virtual buckets. ReadAttrs and ReadBucket
+//    return synthetic bucket entries for them, so that Walk and Copy can traverse the whole mounted tree.
+//    The listing of a mounted bucket also contains the nested mount points.
+//  * modifications of a ReadOnly mount return EROFS
+//  * Mount and Unmount fire EventMount and EventUnmount with the mount point to all listeners of the parent
+//    paths. Listeners are also registered at the FileSystem which is mounted at their path at that time.
+//  * it is safe for concurrent use. The mount tree is immutable and replaced on each change, so that resolving
+//    a path never blocks and in-flight calls keep using the FileSystem which has been resolved.
 type MountableFileSystem struct {
-	root       *virtualDir
+	// TransactionalRename performs a Rename across mount points within transactions, if possible
+	TransactionalRename bool
+
+	// tree contains the immutable root *virtualDir, which is replaced on each change
+	tree       atomic.Value
 	lastHandle int
 	handles    map[int]wrappedHandle
-	lock       sync.Mutex
-}
-
-func (p *MountableFileSystem) wrapHandle(fs FileSystem, handle int) int {
-	if p.handles == nil {
-		p.handles = make(map[int]wrappedHandle)
-	}
-	p.lastHandle++
-	p.handles[p.lastHandle] = wrappedHandle{handle, fs}
-	return p.lastHandle
-}
-
-func (p *MountableFileSystem) unwrapHandle(handle int) wrappedHandle {
-	if p.handles == nil {
-		return wrappedHandle{}
-	}
-	return p.handles[handle]
+	// lock serializes the changes of the tree and guards the handles
+	lock sync.RWMutex
 }
 
 func (p *MountableFileSystem) Connect(ctx context.Context, path string, options interface{}) (interface{}, error) {
@@ -86,57 +133,80 @@ func (p *MountableFileSystem) Connect(ctx context.Context, path string, options
 	return dp.Connect(ctx, providerPath, options)
 }
 
-// Disconnect tries to dispatch the call to a mounted vfs. In any case, the vfs (leaf) is removed from the tree, even
-// if disconnect has returned an error. If you need a different behavior, you can use #Resolve() to grab the
-// actual vfs instance.
+// Disconnect tries to dispatch the call to a mounted vfs. In any case, the vfs is unmounted, even if disconnect has
+// returned an error. Use Unmount to remove a mount point without disconnecting.
 func (p *MountableFileSystem) Disconnect(ctx context.Context, path string) error {
-	p.lock.Lock()
-	defer p.lock.Unlock()
-
-	_, providerPath, dp, err := p.Resolve(path)
+	mountPoint, providerPath, dp, err := p.Resolve(path)
 	if err != nil {
 		return err
 	}
 	err = dp.Disconnect(ctx, providerPath)
-	names := Path(path).Names()
-	root := p.root
-	for i, name := range names {
-		if i == len(names)-1 {
-			root.RemoveChild(name) //remove the leaf which is the mounted vfs
-		} else {
-			child := root.ChildByName(name)
-			root = child.data.(*virtualDir) // we know that because of resolve and early exit
-		}
-	}
-	if err != nil {
-		return err
+	if unmountErr := p.Unmount(mountPoint); err == nil {
+		err = unmountErr
 	}
-	return nil
+	return err
 }
 
+// FireEvent dispatches the event to the mounted FileSystem. For a virtual bucket, the listeners of the
+// MountableFileSystem are notified instead.
 func (p *MountableFileSystem) FireEvent(ctx context.Context, path string, event interface{}) error {
 	_, providerPath, dp, err := p.Resolve(path)
 	if err != nil {
+		if p.virtualDir(path) != nil {
+			return p.notify(path, path, event)
+		}
 		return err
 	}
 	return dp.FireEvent(ctx, providerPath, event)
 }
 
+// notify invokes all listeners of the parent path and its parents and returns the first error.
+func (p *MountableFileSystem) notify(parent string, path string, event interface{}) error {
+	dir := Path(parent).Normalize()
+	p.lock.RLock()
+	matching := make([]ResourceListener, 0, len(p.handles))
+	for _, h := range p.handles {
+		if dir == h.path || h.path == "/" || strings.HasPrefix(string(dir), string(h.path)+"/") {
+			matching = append(matching, h.listener)
+		}
+	}
+	p.lock.RUnlock()
+	for _, listener := range matching {
+		if err := listener.OnEvent(path, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddListener registers the listener for mount events and at the FileSystem which is currently mounted at the
+// path, if any.
 func (p *MountableFileSystem) AddListener(ctx context.Context, path string, listener ResourceListener) (handle int, err error) {
+	h := wrappedHandle{path: Path(path).Normalize(), listener: listener}
 	prefix, providerPath, dp, err := p.Resolve(path)
-	if err != nil {
-		return -1, err
+	if err == nil {
+		hnd, err := dp.AddListener(ctx, providerPath, &mountpointListener{prefix, listener})
+		if err != nil {
+			return hnd, err
+		}
+		h.fs, h.handle = dp, hnd
 	}
-	hnd, err := dp.AddListener(ctx, providerPath, &mountpointListener{prefix, listener})
-	if err != nil {
-		return hnd, err
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.handles == nil {
+		p.handles = make(map[int]wrappedHandle)
 	}
-	return p.wrapHandle(dp, hnd), nil
+	p.lastHandle++
+	p.handles[p.lastHandle] = h
+	return p.lastHandle, nil
 }
 
 func (p *MountableFileSystem) RemoveListener(ctx context.Context, handle int) error {
-	unwrapped := p.unwrapHandle(handle)
-	if unwrapped.fs != nil {
+	p.lock.Lock()
+	unwrapped, ok := p.handles[handle]
+	delete(p.handles, handle)
+	p.lock.Unlock()
+	if ok && unwrapped.fs != nil {
 		return unwrapped.fs.RemoveListener(ctx, unwrapped.handle)
 	}
 	return nil
@@ -178,52 +248,92 @@ func (p *MountableFileSystem) Rollback(ctx context.Context) error {
 }
 
 func (p *MountableFileSystem) Open(ctx context.Context, path string, flag int, options interface{}) (Blob, error) {
-	_, providerPath, dp, err := p.Resolve(path)
+	resolve := p.Resolve
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		resolve = p.resolveWritable
+	}
+	mountPoint, providerPath, dp, err := resolve(path)
 	if err != nil {
 		return nil, err
 	}
-	return dp.Open(ctx, providerPath, flag, options)
+	blob, err := dp.Open(ctx, providerPath, flag, options)
+	return blob, absolutize(mountPoint, err)
 }
 
 func (p *MountableFileSystem) Delete(ctx context.Context, path string) error {
-	_, providerPath, dp, err := p.Resolve(path)
+	mountPoint, providerPath, dp, err := p.resolveWritable(path)
 	if err != nil {
 		return err
 	}
-	return dp.Delete(ctx, providerPath)
+	return absolutize(mountPoint, dp.Delete(ctx, providerPath))
 }
 
+// ReadAttrs returns a synthetic bucket entry for virtual buckets.
 func (p *MountableFileSystem) ReadAttrs(ctx context.Context, path string, args interface{}) (Entry, error) {
-	_, providerPath, dp, err := p.Resolve(path)
-	if err != nil {
-		return nil, err
+	mountPoint, providerPath, dp, err := p.Resolve(path)
+	if err == nil {
+		entry, err := dp.ReadAttrs(ctx, providerPath, args)
+		if err == nil || !isNotFound(err) || p.virtualDir(path) == nil {
+			return entry, absolutize(mountPoint, err)
+		}
+	}
+	if p.virtualDir(path) != nil {
+		return readEntryInto(&DefaultEntry{Id: Path(path).Name(), IsBucket: true, Length: -1}, args), nil
 	}
-	return dp.ReadAttrs(ctx, providerPath, args)
+	return nil, err
 }
 
 func (p *MountableFileSystem) ReadForks(ctx context.Context, path string) ([]string, error) {
-	_, providerPath, dp, err := p.Resolve(path)
+	mountPoint, providerPath, dp, err := p.Resolve(path)
 	if err != nil {
 		return nil, err
 	}
-	return dp.ReadForks(ctx, providerPath)
+	forks, err := dp.ReadForks(ctx, providerPath)
+	return forks, absolutize(mountPoint, err)
 }
 
 func (p *MountableFileSystem) WriteAttrs(ctx context.Context, path string, src interface{}) (Entry, error) {
-	_, providerPath, dp, err := p.Resolve(path)
+	mountPoint, providerPath, dp, err := p.resolveWritable(path)
 	if err != nil {
 		return nil, err
 	}
-	return dp.WriteAttrs(ctx, providerPath, src)
-
+	entry, err := dp.WriteAttrs(ctx, providerPath, src)
+	return entry, absolutize(mountPoint, err)
 }
 
+// ReadBucket returns synthetic bucket entries for virtual buckets. The listing of a mounted bucket is
+// completed by the nested mount points.
 func (p *MountableFileSystem) ReadBucket(ctx context.Context, path string, options interface{}) (ResultSet, error) {
-	_, providerPath, dp, err := p.Resolve(path)
+	mountPoint, providerPath, dp, err := p.Resolve(path)
+	vdir := p.virtualDir(path)
 	if err != nil {
+		if vdir != nil {
+			return &DefaultResultSet{Entries: vdir.entries()}, nil
+		}
 		return nil, err
 	}
-	return dp.ReadBucket(ctx, providerPath, options)
+	if vdir == nil || len(vdir.children) == 0 {
+		res, err := dp.ReadBucket(ctx, providerPath, options)
+		return res, absolutize(mountPoint, err)
+	}
+
+	entries, err := readEntries(ctx, dp, providerPath, options)
+	if err != nil && !isNotFound(err) {
+		return nil, absolutize(mountPoint, err)
+	}
+	for _, mount := range vdir.entries() {
+		found := false
+		for _, entry := range entries {
+			if entry.Id == mount.Id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			entries = append(entries, mount)
+		}
+	}
+	return &DefaultResultSet{Entries: entries}, nil
 }
 
 // Invoke also relies on the prefixed endpoint
@@ -232,70 +342,151 @@ func (p *MountableFileSystem) Invoke(ctx context.Context, endpoint string, args
 	if err != nil {
 		return nil, err
 	}
-	return dp.Invoke(ctx, providerPath, args)
+	return dp.Invoke(ctx, providerPath, args...)
 }
 
 func (p *MountableFileSystem) MkBucket(ctx context.Context, path string, options interface{}) error {
-	_, providerPath, dp, err := p.Resolve(path)
+	mountPoint, providerPath, dp, err := p.resolveWritable(path)
 	if err != nil {
 		return err
 	}
-	return dp.MkBucket(ctx, providerPath, options)
+	return absolutize(mountPoint, dp.MkBucket(ctx, providerPath, options))
 }
 
-func (p *MountableFileSystem) resolveOldNewPath(oldPath string, newPath string) (dp FileSystem, oldP string, newP string, err error) {
-	mp0, _, dp0, err0 := p.Resolve(oldPath)
-	mp1, _, _, err1 := p.Resolve(newPath)
+// resolveOldNewPath returns EXDEV, if both paths are not within the same mount point. The newPath must be
+// writable and if move is true, also the oldPath.
+func (p *MountableFileSystem) resolveOldNewPath(oldPath string, newPath string, move bool) (mountPoint string, dp FileSystem, oldP string, newP string, err error) {
+	resolve := p.Resolve
+	if move {
+		resolve = p.resolveWritable
+	}
+	mp0, _, dp0, err0 := resolve(oldPath)
+	mp1, _, _, err1 := p.resolveWritable(newPath)
 
 	if err0 != nil {
-		return nil, "", "", err0
+		return "", nil, "", "", err0
 	}
 
 	if err1 != nil {
-		return nil, "", "", err1
+		return "", nil, "", "", err1
 	}
 
 	if mp0 != mp1 {
-		return nil, "", "", &DefaultError{Message: "cannot operate across mount points: " + mp0 + " -> " + mp1, Code: EINVAL}
+		return "", nil, "", "", &DefaultError{Message: "cannot operate across mount points: " + mp0 + " -> " + mp1, Code: EXDEV, DetailsPayload: []string{oldPath, newPath}}
 	}
 
 	unwrapedOld := Path(oldPath).TrimPrefix(Path(mp0))
 	unwrappedNew := Path(newPath).TrimPrefix(Path(mp1))
 
-	return dp0, unwrapedOld.String(), unwrappedNew.String(), nil
+	return mp0, dp0, unwrapedOld.String(), unwrappedNew.String(), nil
 }
 
+// Rename moves the entry. Across mount points, the entry is copied and deleted afterwards.
 func (p *MountableFileSystem) Rename(ctx context.Context, oldPath string, newPath string) error {
-	dp, oldP, newP, err := p.resolveOldNewPath(oldPath, newPath)
+	mountPoint, dp, oldP, newP, err := p.resolveOldNewPath(oldPath, newPath, true)
+	if IsErr(err, EXDEV) {
+		return p.move(ctx, oldPath, newPath)
+	}
+	if err != nil {
+		return err
+	}
+	return absolutize(mountPoint, dp.Rename(ctx, oldP, newP))
+}
+
+// move copies the entry across mount points and deletes the old path
+func (p *MountableFileSystem) move(ctx context.Context, oldPath string, newPath string) error {
+	_, oldP, srcFs, err := p.Resolve(oldPath)
 	if err != nil {
 		return err
 	}
-	return dp.Rename(ctx, oldP, newP)
+	_, newP, dstFs, err := p.Resolve(newPath)
+	if err != nil {
+		return err
+	}
+	if p.TransactionalRename {
+		txCtx, err := beginBoth(ctx, srcFs, oldP, dstFs, newP)
+		if err != nil {
+			return err
+		}
+		if txCtx != nil {
+			if err := CopyFS(txCtx, srcFs, oldP, dstFs, newP, nil); err != nil {
+				_ = srcFs.Rollback(txCtx)
+				_ = dstFs.Rollback(txCtx)
+				return err
+			}
+			if err := srcFs.Delete(txCtx, oldP); err != nil {
+				_ = srcFs.Rollback(txCtx)
+				_ = dstFs.Rollback(txCtx)
+				return err
+			}
+			if err := dstFs.Commit(txCtx); err != nil {
+				_ = srcFs.Rollback(txCtx)
+				return err
+			}
+			return srcFs.Commit(txCtx)
+		}
+	}
+	if err := CopyFS(ctx, srcFs, oldP, dstFs, newP, nil); err != nil {
+		return err
+	}
+	return srcFs.Delete(ctx, oldP)
+}
+
+// beginBoth starts a transaction on both FileSystems and returns a context containing both. If any of them does
+// not support transactions, a nil context is returned.
+func beginBoth(ctx context.Context, srcFs FileSystem, src string, dstFs FileSystem, dst string) (context.Context, error) {
+	dstCtx, err := dstFs.Begin(ctx, dst, nil)
+	if IsErr(err, ENOSYS) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	txCtx, err := srcFs.Begin(dstCtx, src, nil)
+	if err != nil {
+		_ = dstFs.Rollback(dstCtx)
+		if IsErr(err, ENOSYS) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return txCtx, nil
 }
 
 func (p *MountableFileSystem) SymLink(ctx context.Context, oldPath string, newPath string) error {
-	dp, oldP, newP, err := p.resolveOldNewPath(oldPath, newPath)
+	mountPoint, dp, oldP, newP, err := p.resolveOldNewPath(oldPath, newPath, false)
 	if err != nil {
 		return err
 	}
-	return dp.SymLink(ctx, oldP, newP)
+	return absolutize(mountPoint, dp.SymLink(ctx, oldP, newP))
 }
 
 func (p *MountableFileSystem) HardLink(ctx context.Context, oldPath string, newPath string) error {
-	dp, oldP, newP, err := p.resolveOldNewPath(oldPath, newPath)
+	mountPoint, dp, oldP, newP, err := p.resolveOldNewPath(oldPath, newPath, false)
 	if err != nil {
 		return err
 	}
-	return dp.HardLink(ctx, oldP, newP)
+	return absolutize(mountPoint, dp.HardLink(ctx, oldP, newP))
 }
 
-// RefLink is like RefLink
+// RefLink is like RefLink. Across mount points, the entry is copied.
 func (p *MountableFileSystem) RefLink(ctx context.Context, oldPath string, newPath string) error {
-	dp, oldP, newP, err := p.resolveOldNewPath(oldPath, newPath)
+	mountPoint, dp, oldP, newP, err := p.resolveOldNewPath(oldPath, newPath, false)
+	if IsErr(err, EXDEV) {
+		_, oldP, srcFs, err := p.Resolve(oldPath)
+		if err != nil {
+			return err
+		}
+		_, newP, dstFs, err := p.Resolve(newPath)
+		if err != nil {
+			return err
+		}
+		return CopyFS(ctx, srcFs, oldP, dstFs, newP, nil)
+	}
 	if err != nil {
 		return err
 	}
-	return dp.RefLink(ctx, oldP, newP)
+	return absolutize(mountPoint, dp.RefLink(ctx, oldP, newP))
 }
 
 func (p *MountableFileSystem) String() string {
@@ -307,38 +498,68 @@ func (p *MountableFileSystem) Close() error {
 	return nil
 }
 
+// getRoot returns the current immutable tree, which can be used without locking
 func (p *MountableFileSystem) getRoot() *virtualDir {
-	if p.root == nil {
-		p.root = &virtualDir{}
+	if root, ok := p.tree.Load().(*virtualDir); ok {
+		return root
 	}
-	return p.root
+	return &virtualDir{}
 }
 
-// Mount includes the given provider into the leaf of the path. Important: you cannot mount one provider into another.
+// Mount includes the given provider into the leaf of the path. An existing mount at the same path is replaced,
+// nested mount points are kept.
 func (p *MountableFileSystem) Mount(mountPoint Path, provider FileSystem) {
-	parent := p.getRoot()
-	names := mountPoint.Names()
-	// ensure the path
-	for _, name := range names[0 : len(names)-1] {
-		child := parent.ChildByName(name)
-		if child == nil {
-			child = &namedEntry{name: name, data: &virtualDir{}}
-			parent.children = append(parent.children, child)
+	p.MountWithOptions(mountPoint, provider, MountOptions{})
+}
+
+// MountWithOptions includes the given provider into the leaf of the path, just like Mount.
+func (p *MountableFileSystem) MountWithOptions(mountPoint Path, provider FileSystem, options MountOptions) {
+	mountPoint = mountPoint.Normalize()
+	mount := &MountInfo{MountPoint: mountPoint.String(), Provider: provider, Options: options}
+	p.lock.Lock()
+	p.tree.Store(p.getRoot().with(mountPoint.Names(), func(node *virtualDir) {
+		node.mount = mount
+	}))
+	p.lock.Unlock()
+
+	_ = p.notify(mountPoint.Parent().Normalize().String(), mountPoint.String(), EventMount)
+}
+
+// Unmount removes the mounted FileSystem from the path without disconnecting it. Nested mount points are kept.
+// Returns ENOMP, if nothing is mounted at the path.
+func (p *MountableFileSystem) Unmount(path string) error {
+	mountPoint := Path(path).Normalize()
+	p.lock.Lock()
+	if node := p.virtualDir(path); node == nil || node.mount == nil {
+		p.lock.Unlock()
+		return &DefaultError{Code: ENOMP, DetailsPayload: []string{path}, Message: "mount point not found"}
+	}
+	p.tree.Store(p.getRoot().with(mountPoint.Names(), func(node *virtualDir) {
+		node.mount = nil
+	}))
+	p.lock.Unlock()
+
+	_ = p.notify(mountPoint.Parent().Normalize().String(), mountPoint.String(), EventUnmount)
+	return nil
+}
+
+// Mounts returns all mounted FileSystems sorted by their mount point.
+func (p *MountableFileSystem) Mounts() []MountInfo {
+	var res []MountInfo
+	var collect func(node *virtualDir)
+	collect = func(node *virtualDir) {
+		if node.mount != nil {
+			res = append(res, *node.mount)
 		}
-		if vdir, ok := child.data.(*virtualDir); ok {
-			parent = vdir
-		} else {
-			//mounting on a leaf or similar
-			vdir = &virtualDir{}
-			child.data = vdir
-			parent = vdir
+		for _, child := range node.children {
+			collect(child)
 		}
 	}
-
-	//now attach the child
-	name := names[len(names)-1]
-	parent.RemoveChild(name)
-	parent.children = append(parent.children, &namedEntry{name, provider})
+	collect(p.getRoot())
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].MountPoint < res[j].MountPoint
+	})
+	return res
 }
 
 // Mounted returns the mounted filesystem or nil if the path cannot be resolved to a mountpoint.
@@ -347,29 +568,65 @@ func (p *MountableFileSystem) Mounted(path string) FileSystem {
 	return vfs
 }
 
-// Resolve searches the virtual structure and returns a provider and the according data or nil and empty paths
+// Resolve searches the virtual structure and returns the provider with the longest matching mount point and the
+// according data or nil and empty paths
 func (p *MountableFileSystem) Resolve(path string) (mountPoint string, providerPath string, provider FileSystem, err error) {
-	names := Path(path).Names()
-	parent := p.getRoot()
-	var child *namedEntry
-	for _, name := range names {
-		child = parent.ChildByName(name)
-		if child == nil {
-			return "", "", nil, &DefaultError{Code: ENOMP, DetailsPayload: path, Message: "mount point not found"}
-		}
+	mount, err := p.resolve(path)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return mount.MountPoint, Path(path).Normalize().TrimPrefix(Path(mount.MountPoint)).String(), mount.Provider, nil
+}
+
+// resolveWritable is like Resolve but returns EROFS for a read only mount point
+func (p *MountableFileSystem) resolveWritable(path string) (mountPoint string, providerPath string, provider FileSystem, err error) {
+	mount, err := p.resolve(path)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if mount.Options.ReadOnly {
+		return "", "", nil, &DefaultError{Code: EROFS, DetailsPayload: []string{path}, Message: "read only mount point: " + mount.MountPoint}
+	}
+	return mount.MountPoint, Path(path).Normalize().TrimPrefix(Path(mount.MountPoint)).String(), mount.Provider, nil
+}
 
-		mountPoint = Path(mountPoint).Child(name).String()
-		if dp, ok := child.data.(FileSystem); ok {
-			//found the mount point
-			return mountPoint, Path(path).TrimPrefix(Path(mountPoint)).String(), dp, nil
+// absolutize prefixes the affected paths of an error of a mounted FileSystem with the mount point
+func absolutize(mountPoint string, err error) error {
+	return rewritePaths(err, func(path string) string {
+		return Path(mountPoint).Add(Path(path)).String()
+	})
+}
+
+// resolve returns the mount with the longest matching mount point
+func (p *MountableFileSystem) resolve(path string) (*MountInfo, error) {
+	node := p.getRoot()
+	mount := node.mount
+	for _, name := range Path(path).Names() {
+		node = node.ChildByName(name)
+		if node == nil {
+			break
+		}
+		if node.mount != nil {
+			mount = node.mount
 		}
-		if vdir, ok := child.data.(*virtualDir); ok {
-			parent = vdir
-		} else {
-			panic("implementation assertion")
+	}
+	if mount == nil {
+		return nil, &DefaultError{Code: ENOMP, DetailsPayload: []string{path}, Message: "mount point not found"}
+	}
+	return mount, nil
+}
+
+// virtualDir returns the node of the path or nil, if the path does not denote a mount point or an intermediate
+// path of mount points.
+func (p *MountableFileSystem) virtualDir(path string) *virtualDir {
+	node := p.getRoot()
+	for _, name := range Path(path).Names() {
+		node = node.ChildByName(name)
+		if node == nil {
+			return nil
 		}
 	}
-	return "", "", nil, &DefaultError{Code: ENOMP, DetailsPayload: path, Message: "mount point not found"}
+	return node
 }
 
 type mountpointListener struct {
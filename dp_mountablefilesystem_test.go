@@ -0,0 +1,265 @@
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMountableFileSystem_RenameAcross(t *testing.T) {
+	local, ftp := &MemFS{}, &MemFS{}
+	mfs := &MountableFileSystem{}
+	mfs.Mount("/media/local", local)
+	mfs.Mount("/media/ftp", ftp)
+	ctx := context.Background()
+	memWrite(t, local, "/a.jpg", "a")
+	memWrite(t, local, "/album/b.jpg", "b")
+
+	if err := mfs.Rename(ctx, "/media/local/a.jpg", "/media/ftp/a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.Rename(ctx, "/media/local/album", "/media/ftp/album"); err != nil {
+		t.Fatal(err)
+	}
+	if str := memRead(t, ftp, "/a.jpg") + memRead(t, ftp, "/album/b.jpg"); str != "ab" {
+		t.Fatal("expected ab but got", str)
+	}
+	for _, path := range []string{"/a.jpg", "/album"} {
+		if _, err := local.ReadAttrs(ctx, path, nil); !IsErr(err, ENOENT) {
+			t.Fatal("expected ENOENT but got", err)
+		}
+	}
+	if err := mfs.Rename(ctx, "/media/local/a.jpg", "/media/ftp/c.jpg"); !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+
+	if err := mfs.RefLink(ctx, "/media/ftp/a.jpg", "/media/local/a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if str := memRead(t, local, "/a.jpg") + memRead(t, ftp, "/a.jpg"); str != "aa" {
+		t.Fatal("expected aa but got", str)
+	}
+	if err := mfs.HardLink(ctx, "/media/ftp/a.jpg", "/media/local/b.jpg"); !IsErr(err, EXDEV) {
+		t.Fatal("expected EXDEV but got", err)
+	}
+}
+
+func TestMountableFileSystem_RenameAcrossTx(t *testing.T) {
+	local, ftp := &MemFS{}, &MemFS{}
+	mfs := &MountableFileSystem{TransactionalRename: true}
+	mfs.Mount("/media/local", &TxFileSystem{Delegate: local})
+	mfs.Mount("/media/ftp", &TxFileSystem{Delegate: ftp})
+	ctx := context.Background()
+	memWrite(t, local, "/album/b.jpg", "b")
+
+	if err := mfs.Rename(ctx, "/media/local/album", "/media/ftp/album"); err != nil {
+		t.Fatal(err)
+	}
+	if str := memRead(t, ftp, "/album/b.jpg"); str != "b" {
+		t.Fatal("expected b but got", str)
+	}
+	if _, err := local.ReadAttrs(ctx, "/album", nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+
+	// without transaction support on one side, the plain fallback is used
+	mfs.Mount("/media/plain", &MemFS{})
+	if err := mfs.Rename(ctx, "/media/ftp/album", "/media/plain/album"); err != nil {
+		t.Fatal(err)
+	}
+	if str := memRead(t, mfs, "/media/plain/album/b.jpg"); str != "b" {
+		t.Fatal("expected b but got", str)
+	}
+}
+
+func TestMountableFileSystem_VirtualDir(t *testing.T) {
+	local, ftp := &MemFS{}, &MemFS{}
+	mfs := &MountableFileSystem{}
+	mfs.Mount("/media/local", local)
+	mfs.Mount("/media/remote/ftp", ftp)
+	mfs.Mount("/tmp", &MemFS{})
+	ctx := context.Background()
+	memWrite(t, local, "/a.jpg", "a")
+	memWrite(t, ftp, "/album/b.jpg", "b")
+
+	if names := overlayNames(t, mfs, "/"); len(names) != 2 || names[0] != "media" || names[1] != "tmp" {
+		t.Fatal("unexpected listing", names)
+	}
+	if names := overlayNames(t, mfs, "/media"); len(names) != 2 || names[0] != "local" || names[1] != "remote" {
+		t.Fatal("unexpected listing", names)
+	}
+	entry, err := mfs.ReadAttrs(ctx, "/media/remote", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !entry.IsDir() || entry.Name() != "remote" {
+		t.Fatal("expected a bucket but got", entry)
+	}
+	if _, err := mfs.ReadAttrs(ctx, "/media/other", nil); !IsErr(err, ENOMP) {
+		t.Fatal("expected ENOMP but got", err)
+	}
+
+	var paths []string
+	err = WalkFS(ctx, mfs, "/", func(path string, info Entry, err error) error {
+		if err != nil {
+			return err
+		}
+		paths = append(paths, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 8 {
+		t.Fatal("expected 8 entries but got", paths)
+	}
+
+	backup := &MemFS{}
+	if err := CopyFS(ctx, mfs, "/media", backup, "/backup", nil); err != nil {
+		t.Fatal(err)
+	}
+	if str := memRead(t, backup, "/backup/local/a.jpg") + memRead(t, backup, "/backup/remote/ftp/album/b.jpg"); str != "ab" {
+		t.Fatal("expected ab but got", str)
+	}
+}
+
+type testMountListener struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (l *testMountListener) OnEvent(path string, event interface{}) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.events = append(l.events, fmt.Sprint(event, " ", path))
+	return nil
+}
+
+func TestMountableFileSystem_Nested(t *testing.T) {
+	media, usb := &MemFS{}, &MemFS{}
+	mfs := &MountableFileSystem{}
+	ctx := context.Background()
+	listener := &testMountListener{}
+	if _, err := mfs.AddListener(ctx, "/media", listener); err != nil {
+		t.Fatal(err)
+	}
+	mfs.Mount("/media", media)
+	mfs.MountWithOptions("/media/usb", usb, MountOptions{ReadOnly: true})
+	memWrite(t, mfs, "/media/a.jpg", "a")
+	memWrite(t, usb, "/b.jpg", "b")
+
+	if str := memRead(t, mfs, "/media/usb/b.jpg"); str != "b" {
+		t.Fatal("expected b but got", str)
+	}
+	if names := overlayNames(t, mfs, "/media"); len(names) != 2 || names[0] != "a.jpg" || names[1] != "usb" {
+		t.Fatal("unexpected listing", names)
+	}
+	if _, err := mfs.Open(ctx, "/media/usb/c.jpg", os.O_CREATE|os.O_WRONLY, nil); !IsErr(err, EROFS) {
+		t.Fatal("expected EROFS but got", err)
+	}
+	if err := mfs.Rename(ctx, "/media/usb/b.jpg", "/media/b.jpg"); !IsErr(err, EROFS) {
+		t.Fatal("expected EROFS but got", err)
+	}
+	if err := mfs.RefLink(ctx, "/media/usb/b.jpg", "/media/b.jpg"); err != nil {
+		t.Fatal(err)
+	}
+
+	mounts := mfs.Mounts()
+	if len(mounts) != 2 || mounts[0].Provider != media || mounts[1].MountPoint != "/media/usb" || !mounts[1].Options.ReadOnly {
+		t.Fatal("unexpected mounts", mounts)
+	}
+	if err := mfs.Unmount("/media"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mfs.Unmount("/media"); !IsErr(err, ENOMP) {
+		t.Fatal("expected ENOMP but got", err)
+	}
+	if _, err := mfs.ReadAttrs(ctx, "/media/a.jpg", nil); !IsErr(err, ENOMP) {
+		t.Fatal("expected ENOMP but got", err)
+	}
+	if str := memRead(t, mfs, "/media/usb/b.jpg"); str != "b" {
+		t.Fatal("expected b but got", str)
+	}
+	if err := mfs.Unmount("/media/usb"); err != nil {
+		t.Fatal(err)
+	}
+	if names := overlayNames(t, mfs, "/"); len(names) != 0 {
+		t.Fatal("expected an empty root but got", names)
+	}
+
+	events := strings.Join(listener.events, ",")
+	if events != "Mount /media/usb,Unmount /media/usb" {
+		t.Fatal("unexpected events", events)
+	}
+}
+
+func TestMountableFileSystem_Invoke(t *testing.T) {
+	var endpoint string
+	var args []interface{}
+	mfs := &MountableFileSystem{}
+	mfs.Mount("/api", &AbstractFileSystem{FInvoke: func(ctx context.Context, e string, a ...interface{}) (interface{}, error) {
+		endpoint = e
+		args = a
+		return len(a), nil
+	}})
+	res, err := mfs.Invoke(context.Background(), "/api/echo", "a", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != 2 || endpoint != "/echo" || args[0] != "a" || args[1] != 2 {
+		t.Fatal("expected the variadic arguments but got", endpoint, args)
+	}
+}
+
+func TestMountableFileSystem_Concurrent(t *testing.T) {
+	mfs := &MountableFileSystem{}
+	mfs.Mount("/", &MemFS{})
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			account := fmt.Sprintf("/users/%d", i)
+			for j := 0; j < 50; j++ {
+				mfs.Mount(Path(account), &MemFS{})
+				handle, err := mfs.AddListener(ctx, account, &testMountListener{})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err := WriteAllFS(ctx, mfs, account+"/a.txt", []byte("a")); err != nil {
+					t.Error(err)
+					return
+				}
+				// other accounts may disappear while walking
+				err = WalkFS(ctx, mfs, "/users", func(path string, info Entry, err error) error {
+					if isNotFound(err) {
+						return nil
+					}
+					return err
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				_ = mfs.Mounts()
+				if err := mfs.RemoveListener(ctx, handle); err != nil {
+					t.Error(err)
+					return
+				}
+				if err := mfs.Unmount(account); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if mounts := mfs.Mounts(); len(mounts) != 1 || mounts[0].MountPoint != "/" {
+		t.Fatal("expected only the root mount but got", mounts)
+	}
+}
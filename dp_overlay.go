@@ -0,0 +1,581 @@
+package vfs
+
+import (
+	"context"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+var _ FileSystem = (*OverlayFileSystem)(nil)
+
+const (
+	// overlayWhiteoutPrefix marks a deleted entry of a lower layer, e.g. /dir/.wh.file hides /dir/file
+	overlayWhiteoutPrefix = ".wh."
+	// overlayOpaqueMarker hides the entire content of the lower layers of a bucket
+	overlayOpaqueMarker = ".wh..wh..opq"
+)
+
+// An OverlayFileSystem stacks a writable Upper layer over read only Lower layers, like a union mount. The first lower
+// layer has the highest priority. Markers use the same naming as AUFS and OCI image layers.
+//
+// Details
+//
+//  * ReadAttrs and Open return the entry of the topmost layer
+//  * ReadBucket merges the entries of all layers, sorted by name within a single page
+//  * modifications are only applied to the Upper layer. Open with O_WRONLY, O_RDWR or O_CREATE and WriteAttrs copy
+//    a blob from a lower layer up first (copy-on-write), Rename also copies entire buckets.
+//  * Delete removes the entry from the Upper layer and creates a whiteout marker (.wh.<name>), so that lower
+//    entries stay hidden. A bucket which is created again, gets an opaque marker (.wh..wh..opq). Markers are
+//    never returned.
+//  * listeners, events and transactions are delegated to the Upper layer
+type OverlayFileSystem struct {
+	// Upper receives all modifications
+	Upper FileSystem
+	// Lower layers are never modified, the first layer shadows the next one
+	Lower []FileSystem
+}
+
+func whiteoutOf(path Path) string {
+	return path.Parent().Child(overlayWhiteoutPrefix + path.Name()).String()
+}
+
+func isOverlayMarker(name string) bool {
+	return strings.HasPrefix(name, overlayWhiteoutPrefix)
+}
+
+// exists checks if the path can be resolved.
+func exists(ctx context.Context, fs FileSystem, path string) (bool, error) {
+	_, err := fs.ReadAttrs(ctx, path, nil)
+	if err == nil {
+		return true, nil
+	}
+	if isNotFound(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+// isNotFound returns true for ENOENT, ENOTDIR and not translated os errors of a missing file.
+func isNotFound(err error) bool {
+	return IsErr(err, ENOENT) || IsErr(err, ENOTDIR) || os.IsNotExist(err)
+}
+
+// lowerVisible returns false, if the path or one of its parents has been deleted or made opaque in the Upper layer.
+func (o *OverlayFileSystem) lowerVisible(ctx context.Context, path Path) (bool, error) {
+	current := Path("")
+	for _, name := range path.Names() {
+		parent := current
+		current = current.Child(name)
+		if isOverlayMarker(name) {
+			return false, nil
+		}
+		if ok, err := exists(ctx, o.Upper, whiteoutOf(current)); ok || err != nil {
+			return false, err
+		}
+		if ok, err := exists(ctx, o.Upper, parent.Child(overlayOpaqueMarker).String()); ok || err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// layerOf returns the topmost layer which contains the path or ENOENT.
+func (o *OverlayFileSystem) layerOf(ctx context.Context, path Path) (FileSystem, Entry, error) {
+	if path.NameCount() > 0 && isOverlayMarker(path.Name()) {
+		return nil, nil, &DefaultError{Message: path.String(), Code: ENOENT, DetailsPayload: []string{path.String()}}
+	}
+	entry, err := o.Upper.ReadAttrs(ctx, path.String(), nil)
+	if err == nil {
+		return o.Upper, entry, nil
+	}
+	if !isNotFound(err) {
+		return nil, nil, err
+	}
+	visible, err := o.lowerVisible(ctx, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if visible {
+		for _, layer := range o.Lower {
+			entry, err := layer.ReadAttrs(ctx, path.String(), nil)
+			if err == nil {
+				return layer, entry, nil
+			}
+			if !isNotFound(err) {
+				return nil, nil, err
+			}
+		}
+	}
+	return nil, nil, &DefaultError{Message: path.String(), Code: ENOENT, DetailsPayload: []string{path.String()}}
+}
+
+// prepareUpper creates the parent buckets of the path in the Upper layer and removes any whiteout of the path
+// and its parents. Recreated buckets become opaque, so that deleted lower content stays hidden. Returns true, if
+// the path itself has been whited out.
+func (o *OverlayFileSystem) prepareUpper(ctx context.Context, path Path) (bool, error) {
+	current := Path("")
+	names := path.Names()
+	for i, name := range names {
+		current = current.Child(name)
+		whiteout := whiteoutOf(current)
+		ok, err := exists(ctx, o.Upper, whiteout)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			continue
+		}
+		if err := o.Upper.Delete(ctx, whiteout); err != nil {
+			return false, err
+		}
+		if i == len(names)-1 {
+			return true, nil
+		}
+		if err := o.makeOpaque(ctx, current); err != nil {
+			return false, err
+		}
+	}
+	if path.NameCount() > 1 {
+		return false, o.Upper.MkBucket(ctx, path.Parent().String(), nil)
+	}
+	return false, nil
+}
+
+func (o *OverlayFileSystem) makeOpaque(ctx context.Context, path Path) error {
+	return o.touch(ctx, path.Child(overlayOpaqueMarker))
+}
+
+// touch creates an empty blob in the Upper layer.
+func (o *OverlayFileSystem) touch(ctx context.Context, path Path) error {
+	if err := o.Upper.MkBucket(ctx, path.Parent().String(), nil); err != nil {
+		return err
+	}
+	blob, err := o.Upper.Open(ctx, path.String(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, nil)
+	if err != nil {
+		return err
+	}
+	return blob.Close()
+}
+
+// copyUp copies the entry, including the content of buckets, into the Upper layer, if it is only available in
+// a lower layer.
+func (o *OverlayFileSystem) copyUp(ctx context.Context, path Path) error {
+	layer, entry, err := o.layerOf(ctx, path)
+	if err != nil {
+		return err
+	}
+	if layer == o.Upper {
+		return nil
+	}
+	if _, err := o.prepareUpper(ctx, path); err != nil {
+		return err
+	}
+	if entry.IsDir() {
+		if err := o.Upper.MkBucket(ctx, path.String(), nil); err != nil {
+			return err
+		}
+		res, err := o.ReadBucket(ctx, path.String(), nil)
+		if err != nil {
+			return err
+		}
+		for _, child := range res.(*DefaultResultSet).Entries {
+			if err := o.copyUp(ctx, path.Child(child.Id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	src, err := layer.Open(ctx, path.String(), os.O_RDONLY, nil)
+	if err != nil {
+		return err
+	}
+	defer silentClose(src)
+	dst, err := o.Upper.Open(ctx, path.String(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// whiteout hides the path of all lower layers, if required.
+func (o *OverlayFileSystem) whiteout(ctx context.Context, path Path) error {
+	visible, err := o.lowerVisible(ctx, path)
+	if err != nil || !visible {
+		return err
+	}
+	for _, layer := range o.Lower {
+		ok, err := exists(ctx, layer, path.String())
+		if err != nil {
+			return err
+		}
+		if ok {
+			return o.touch(ctx, Path(whiteoutOf(path)))
+		}
+	}
+	return nil
+}
+
+// Connect connects the Upper layer and all lower layers and returns the result of the Upper layer.
+func (o *OverlayFileSystem) Connect(ctx context.Context, path string, options interface{}) (interface{}, error) {
+	for _, layer := range o.Lower {
+		if _, err := layer.Connect(ctx, path, options); err != nil {
+			return nil, err
+		}
+	}
+	return o.Upper.Connect(ctx, path, options)
+}
+
+// Disconnect disconnects all layers and returns the first error.
+func (o *OverlayFileSystem) Disconnect(ctx context.Context, path string) error {
+	err := o.Upper.Disconnect(ctx, path)
+	for _, layer := range o.Lower {
+		if e := layer.Disconnect(ctx, path); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (o *OverlayFileSystem) FireEvent(ctx context.Context, path string, event interface{}) error {
+	return o.Upper.FireEvent(ctx, path, event)
+}
+
+func (o *OverlayFileSystem) AddListener(ctx context.Context, path string, listener ResourceListener) (handle int, err error) {
+	return o.Upper.AddListener(ctx, path, listener)
+}
+
+func (o *OverlayFileSystem) RemoveListener(ctx context.Context, handle int) error {
+	return o.Upper.RemoveListener(ctx, handle)
+}
+
+func (o *OverlayFileSystem) Begin(ctx context.Context, path string, options interface{}) (context.Context, error) {
+	return o.Upper.Begin(ctx, path, options)
+}
+
+func (o *OverlayFileSystem) Commit(ctx context.Context) error {
+	return o.Upper.Commit(ctx)
+}
+
+func (o *OverlayFileSystem) Rollback(ctx context.Context) error {
+	return o.Upper.Rollback(ctx)
+}
+
+// Open copies the blob into the Upper layer, if opened for writing. O_TRUNC avoids copying the content.
+func (o *OverlayFileSystem) Open(ctx context.Context, path string, flag int, options interface{}) (Blob, error) {
+	p := Path(path).Normalize()
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE) == 0 {
+		layer, _, err := o.layerOf(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		return layer.Open(ctx, p.String(), flag, options)
+	}
+
+	if p.NameCount() > 0 && isOverlayMarker(p.Name()) {
+		return nil, &DefaultError{Message: "reserved name: " + p.String(), Code: EINVAL, DetailsPayload: []string{p.String()}}
+	}
+	layer, entry, err := o.layerOf(ctx, p)
+	switch {
+	case err != nil && !IsErr(err, ENOENT):
+		return nil, err
+	case err == nil && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &DefaultError{Message: p.String(), Code: EEXIST, DetailsPayload: []string{p.String()}}
+	case err == nil && entry.IsDir():
+		return nil, &DefaultError{Message: p.String(), Code: EISDIR, DetailsPayload: []string{p.String()}}
+	case err == nil && layer != o.Upper && flag&os.O_TRUNC == 0:
+		if err := o.copyUp(ctx, p); err != nil {
+			return nil, err
+		}
+	case err == nil && layer != o.Upper:
+		if _, err := o.prepareUpper(ctx, p); err != nil {
+			return nil, err
+		}
+		flag |= os.O_CREATE
+	case err != nil:
+		if flag&os.O_CREATE == 0 {
+			return nil, err
+		}
+		if _, err := o.prepareUpper(ctx, p); err != nil {
+			return nil, err
+		}
+	}
+	return o.Upper.Open(ctx, p.String(), flag, options)
+}
+
+// Delete removes the entry from the Upper layer and creates a whiteout, if a lower layer contains the path.
+// Deleting the root makes it opaque.
+func (o *OverlayFileSystem) Delete(ctx context.Context, path string) error {
+	p := Path(path).Normalize()
+	if p.NameCount() > 0 && isOverlayMarker(p.Name()) {
+		return nil
+	}
+	if err := o.Upper.Delete(ctx, p.String()); err != nil {
+		return err
+	}
+	if p.NameCount() == 0 {
+		return o.makeOpaque(ctx, p)
+	}
+	return o.whiteout(ctx, p)
+}
+
+func (o *OverlayFileSystem) ReadAttrs(ctx context.Context, path string, args interface{}) (Entry, error) {
+	p := Path(path).Normalize()
+	layer, _, err := o.layerOf(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return layer.ReadAttrs(ctx, p.String(), args)
+}
+
+func (o *OverlayFileSystem) ReadForks(ctx context.Context, path string) ([]string, error) {
+	p := Path(path).Normalize()
+	layer, _, err := o.layerOf(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return layer.ReadForks(ctx, p.String())
+}
+
+// WriteAttrs copies the entry into the Upper layer first.
+func (o *OverlayFileSystem) WriteAttrs(ctx context.Context, path string, src interface{}) (Entry, error) {
+	p := Path(path).Normalize()
+	if err := o.copyUp(ctx, p); err != nil {
+		return nil, err
+	}
+	return o.Upper.WriteAttrs(ctx, p.String(), src)
+}
+
+// ReadBucket merges the entries of all layers, which are not hidden by a whiteout or an opaque marker.
+func (o *OverlayFileSystem) ReadBucket(ctx context.Context, path string, options interface{}) (ResultSet, error) {
+	p := Path(path).Normalize()
+	_, entry, err := o.layerOf(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if !entry.IsDir() {
+		return nil, &DefaultError{Message: "not a bucket: " + p.String(), Code: ENOENT, DetailsPayload: []string{p.String()}}
+	}
+
+	entries := make(map[string]*DefaultEntry)
+	hidden := make(map[string]bool)
+	opaque := false
+	upper, err := readEntries(ctx, o.Upper, p.String(), options)
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	for _, e := range upper {
+		switch {
+		case e.Id == overlayOpaqueMarker:
+			opaque = true
+		case isOverlayMarker(e.Id):
+			hidden[strings.TrimPrefix(e.Id, overlayWhiteoutPrefix)] = true
+		default:
+			entries[e.Id] = e
+		}
+	}
+
+	visible, err := o.lowerVisible(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if visible && !opaque {
+		for _, layer := range o.Lower {
+			lower, err := readEntries(ctx, layer, p.String(), options)
+			if err != nil && !isNotFound(err) {
+				return nil, err
+			}
+			for _, e := range lower {
+				if entries[e.Id] == nil && !hidden[e.Id] {
+					entries[e.Id] = e
+				}
+			}
+		}
+	}
+
+	res := &DefaultResultSet{Entries: make([]*DefaultEntry, 0, len(entries))}
+	for _, e := range entries {
+		res.Entries = append(res.Entries, e)
+	}
+	sort.Slice(res.Entries, func(i, j int) bool {
+		return res.Entries[i].Id < res.Entries[j].Id
+	})
+	return res, nil
+}
+
+// readEntries reads all pages of a bucket.
+func readEntries(ctx context.Context, fs FileSystem, path string, options interface{}) ([]*DefaultEntry, error) {
+	res, err := fs.ReadBucket(ctx, path, options)
+	if err != nil {
+		return nil, err
+	}
+	var entries []*DefaultEntry
+	for {
+		for i := 0; i < res.Len(); i++ {
+			entry := res.ReadAttrs(i, nil)
+			entries = append(entries, &DefaultEntry{Id: entry.Name(), IsBucket: entry.IsDir(), Length: size(entry), Data: entry.Sys()})
+		}
+		err = res.Next(ctx)
+		if err != nil {
+			if IsErr(err, EOF) || err == io.EOF {
+				return entries, nil
+			}
+			return entries, err
+		}
+	}
+}
+
+// Invoke tries the Upper layer first and then all lower layers, as long as ENOSYS is returned.
+func (o *OverlayFileSystem) Invoke(ctx context.Context, endpoint string, args ...interface{}) (interface{}, error) {
+	res, err := o.Upper.Invoke(ctx, endpoint, args...)
+	for i := 0; i < len(o.Lower) && IsErr(err, ENOSYS); i++ {
+		res, err = o.Lower[i].Invoke(ctx, endpoint, args...)
+	}
+	return res, err
+}
+
+// MkBucket creates the bucket in the Upper layer. A bucket which has been deleted before becomes opaque.
+func (o *OverlayFileSystem) MkBucket(ctx context.Context, path string, options interface{}) error {
+	p := Path(path).Normalize()
+	layer, entry, err := o.layerOf(ctx, p)
+	if err != nil && !IsErr(err, ENOENT) {
+		return err
+	}
+	if err == nil {
+		if !entry.IsDir() {
+			return &DefaultError{Message: "not a bucket: " + p.String(), Code: ENOTDIR, DetailsPayload: []string{p.String()}}
+		}
+		if layer == o.Upper {
+			return nil
+		}
+	}
+	whitedOut, err := o.prepareUpper(ctx, p)
+	if err != nil {
+		return err
+	}
+	if err := o.Upper.MkBucket(ctx, p.String(), options); err != nil {
+		return err
+	}
+	if whitedOut {
+		return o.makeOpaque(ctx, p)
+	}
+	return nil
+}
+
+// Rename copies the entry into the Upper layer, renames it and hides the old path. A renamed bucket becomes
+// opaque, so that it is not merged with a lower bucket of the same name.
+func (o *OverlayFileSystem) Rename(ctx context.Context, oldPath string, newPath string) error {
+	oldP, newP, err := checkOldNew(oldPath, newPath)
+	if err != nil {
+		return err
+	}
+	if oldP == newP {
+		_, _, err := o.layerOf(ctx, oldP)
+		return err
+	}
+	if err := o.copyUp(ctx, oldP); err != nil {
+		return err
+	}
+	entry, err := o.Upper.ReadAttrs(ctx, oldP.String(), nil)
+	if err != nil {
+		return err
+	}
+	if _, err := o.prepareUpper(ctx, newP); err != nil {
+		return err
+	}
+	if err := o.Upper.Delete(ctx, newP.String()); err != nil {
+		return err
+	}
+	if err := o.Upper.Rename(ctx, oldP.String(), newP.String()); err != nil {
+		return err
+	}
+	if entry.IsDir() {
+		if err := o.makeOpaque(ctx, newP); err != nil {
+			return err
+		}
+	}
+	return o.whiteout(ctx, oldP)
+}
+
+// SymLink returns EEXIST, if the new path exists in any layer.
+func (o *OverlayFileSystem) SymLink(ctx context.Context, oldPath string, newPath string) error {
+	newP := Path(newPath).Normalize()
+	if err := o.checkAbsent(ctx, newP); err != nil {
+		return err
+	}
+	if _, err := o.prepareUpper(ctx, newP); err != nil {
+		return err
+	}
+	return o.Upper.SymLink(ctx, oldPath, newP.String())
+}
+
+// HardLink copies the blob into the Upper layer first. Returns EEXIST, if the new path exists in any layer.
+func (o *OverlayFileSystem) HardLink(ctx context.Context, oldPath string, newPath string) error {
+	oldP, newP, err := checkOldNew(oldPath, newPath)
+	if err != nil {
+		return err
+	}
+	if err := o.checkAbsent(ctx, newP); err != nil {
+		return err
+	}
+	if err := o.copyUp(ctx, oldP); err != nil {
+		return err
+	}
+	if _, err := o.prepareUpper(ctx, newP); err != nil {
+		return err
+	}
+	return o.Upper.HardLink(ctx, oldP.String(), newP.String())
+}
+
+// checkAbsent returns EEXIST, if the path exists in the merged view, so that a lower entry is not shadowed.
+func (o *OverlayFileSystem) checkAbsent(ctx context.Context, path Path) error {
+	_, err := o.ReadAttrs(ctx, path.String(), nil)
+	if err == nil {
+		return &DefaultError{Message: path.String(), Code: EEXIST, DetailsPayload: []string{path.String()}}
+	}
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// RefLink copies the entry into the Upper layer first.
+func (o *OverlayFileSystem) RefLink(ctx context.Context, oldPath string, newPath string) error {
+	oldP, newP, err := checkOldNew(oldPath, newPath)
+	if err != nil {
+		return err
+	}
+	if err := o.copyUp(ctx, oldP); err != nil {
+		return err
+	}
+	if _, err := o.prepareUpper(ctx, newP); err != nil {
+		return err
+	}
+	return o.Upper.RefLink(ctx, oldP.String(), newP.String())
+}
+
+// Close closes all layers and returns the first error.
+func (o *OverlayFileSystem) Close() error {
+	err := o.Upper.Close()
+	for _, layer := range o.Lower {
+		if e := layer.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (o *OverlayFileSystem) String() string {
+	names := make([]string, 0, len(o.Lower)+1)
+	names = append(names, o.Upper.String())
+	for _, layer := range o.Lower {
+		names = append(names, layer.String())
+	}
+	return "OverlayFileSystem(" + strings.Join(names, ", ") + ")"
+}
@@ -0,0 +1,197 @@
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+)
+
+func overlayNames(t *testing.T, fs FileSystem, path string) []string {
+	t.Helper()
+	res, err := fs.ReadBucket(context.Background(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for i := 0; i < res.Len(); i++ {
+		names = append(names, res.ReadAttrs(i, nil).Name())
+	}
+	return names
+}
+
+func newTestOverlay(t *testing.T) (*OverlayFileSystem, *MemFS, *MemFS) {
+	upper, lower0, lower1 := &MemFS{}, &MemFS{}, &MemFS{}
+	memWrite(t, lower0, "/assets/a.txt", "a0")
+	memWrite(t, lower1, "/assets/a.txt", "a1")
+	memWrite(t, lower1, "/assets/b.txt", "b1")
+	memWrite(t, lower1, "/assets/img/logo.png", "png")
+	return &OverlayFileSystem{Upper: upper, Lower: []FileSystem{lower0, lower1}}, upper, lower1
+}
+
+func TestOverlayFileSystem_Read(t *testing.T) {
+	fs, upper, _ := newTestOverlay(t)
+	memWrite(t, upper, "/assets/c.txt", "c")
+
+	if names := overlayNames(t, fs, "/assets"); len(names) != 4 || names[0] != "a.txt" || names[3] != "img" {
+		t.Fatal("unexpected listing", names)
+	}
+	if str := memRead(t, fs, "/assets/a.txt"); str != "a0" {
+		t.Fatal("expected a0 but got", str)
+	}
+	if str := memRead(t, fs, "/assets/b.txt"); str != "b1" {
+		t.Fatal("expected b1 but got", str)
+	}
+	if _, err := fs.ReadAttrs(context.Background(), "/assets/missing", nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+}
+
+func TestOverlayFileSystem_CopyUp(t *testing.T) {
+	fs, upper, lower := newTestOverlay(t)
+	ctx := context.Background()
+
+	blob, err := fs.Open(ctx, "/assets/b.txt", os.O_RDWR, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blob.WriteAt([]byte("B"), 0); err != nil {
+		t.Fatal(err)
+	}
+	silentClose(blob)
+
+	if str := memRead(t, fs, "/assets/b.txt"); str != "B1" {
+		t.Fatal("expected B1 but got", str)
+	}
+	if str := memRead(t, upper, "/assets/b.txt"); str != "B1" {
+		t.Fatal("expected B1 but got", str)
+	}
+	if str := memRead(t, lower, "/assets/b.txt"); str != "b1" {
+		t.Fatal("expected unmodified lower layer but got", str)
+	}
+
+	if _, err := fs.WriteAttrs(ctx, "/assets/img/logo.png", map[string]interface{}{"tag": "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if str := memRead(t, upper, "/assets/img/logo.png"); str != "png" {
+		t.Fatal("expected png but got", str)
+	}
+}
+
+func TestOverlayFileSystem_Whiteout(t *testing.T) {
+	fs, _, lower := newTestOverlay(t)
+	ctx := context.Background()
+
+	if err := fs.Delete(ctx, "/assets/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.ReadAttrs(ctx, "/assets/a.txt", nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+	if names := overlayNames(t, fs, "/assets"); len(names) != 2 || names[0] != "b.txt" {
+		t.Fatal("unexpected listing", names)
+	}
+	if str := memRead(t, lower, "/assets/a.txt"); str != "a1" {
+		t.Fatal("expected unmodified lower layer but got", str)
+	}
+
+	// recreate a deleted blob
+	memWrite(t, fs, "/assets/a.txt", "new")
+	if str := memRead(t, fs, "/assets/a.txt"); str != "new" {
+		t.Fatal("expected new but got", str)
+	}
+
+	// a recreated bucket must not reveal the deleted content
+	if err := fs.Delete(ctx, "/assets/img"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.MkBucket(ctx, "/assets/img", nil); err != nil {
+		t.Fatal(err)
+	}
+	if names := overlayNames(t, fs, "/assets/img"); len(names) != 0 {
+		t.Fatal("expected empty bucket but got", names)
+	}
+	memWrite(t, fs, "/assets/img/icon.png", "icon")
+	if names := overlayNames(t, fs, "/assets/img"); len(names) != 1 || names[0] != "icon.png" {
+		t.Fatal("unexpected listing", names)
+	}
+
+	if _, err := fs.Open(ctx, "/assets/.wh.b.txt", os.O_CREATE|os.O_WRONLY, nil); !IsErr(err, EINVAL) {
+		t.Fatal("expected EINVAL but got", err)
+	}
+}
+
+func TestOverlayFileSystem_Rename(t *testing.T) {
+	fs, _, _ := newTestOverlay(t)
+	ctx := context.Background()
+
+	if err := fs.Rename(ctx, "/assets", "/static"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.ReadAttrs(ctx, "/assets", nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+	if names := overlayNames(t, fs, "/static"); len(names) != 3 {
+		t.Fatal("unexpected listing", names)
+	}
+	if str := memRead(t, fs, "/static/img/logo.png"); str != "png" {
+		t.Fatal("expected png but got", str)
+	}
+	if names := overlayNames(t, fs, "/"); len(names) != 1 || names[0] != "static" {
+		t.Fatal("unexpected listing", names)
+	}
+}
+
+func TestOverlayFileSystem_Links(t *testing.T) {
+	fs, upper, _ := newTestOverlay(t)
+	ctx := context.Background()
+	memWrite(t, upper, "/assets/c.txt", "c")
+
+	// b.txt only exists in the lower layer
+	if err := fs.HardLink(ctx, "/assets/c.txt", "/assets/b.txt"); !IsErr(err, EEXIST) {
+		t.Fatal("expected EEXIST but got", err)
+	}
+	if err := fs.SymLink(ctx, "/assets/c.txt", "/assets/b.txt"); !IsErr(err, EEXIST) {
+		t.Fatal("expected EEXIST but got", err)
+	}
+	if str := memRead(t, fs, "/assets/b.txt"); str != "b1" {
+		t.Fatal("expected b1 but got", str)
+	}
+
+	if err := fs.HardLink(ctx, "/assets/b.txt", "/assets/d.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if str := memRead(t, fs, "/assets/d.txt"); str != "b1" {
+		t.Fatal("expected b1 but got", str)
+	}
+
+	// a hidden lower entry does not exist anymore
+	if err := fs.Delete(ctx, "/assets/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.HardLink(ctx, "/assets/c.txt", "/assets/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if str := memRead(t, fs, "/assets/a.txt"); str != "c" {
+		t.Fatal("expected c but got", str)
+	}
+}
+
+func TestOverlayFileSystem_Mount(t *testing.T) {
+	data := createTestZip(t)
+	lower, err := NewZipFileSystem(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	overlay := &OverlayFileSystem{Upper: &MemFS{}, Lower: []FileSystem{lower}}
+	mfs := &MountableFileSystem{}
+	mfs.Mount("/assets", &ChRoot{Prefix: "/assets", Delegate: overlay})
+
+	memWrite(t, mfs, "/assets/readme.txt", "user override")
+	if str := memRead(t, mfs, "/assets/readme.txt"); str != "user override" {
+		t.Fatal("expected user override but got", str)
+	}
+	if str := memRead(t, lower, "/assets/readme.txt"); str != "hello zip" {
+		t.Fatal("expected hello zip but got", str)
+	}
+}
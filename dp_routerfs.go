@@ -0,0 +1,181 @@
+package vfs
+
+import (
+	"context"
+	"fmt"
+)
+
+var _ FileSystem = (*RouterFileSystem)(nil)
+
+// A RouterFileSystem serves a virtual tree from Router patterns, e.g. to expose a REST-like structure like
+// /users/{id}/photos/{photoId} as a FileSystem. Each operation has its own route table and the first matching
+// pattern is invoked, see Router.Match.
+//
+// Details
+//
+//  * if nothing matches, ENOENT is returned. An operation without any route returns ENOSYS.
+//  * an unmatched Delete returns nil, because deleting a non-existing resource is not an error by spec
+//  * the arguments of the operation are available by RoutingContext.Args:
+//      * Open: the flag (int) and the options, a Blob is expected as result
+//      * ReadBucket: the options, a ResultSet is expected as result
+//      * ReadAttrs: the args, an Entry is expected as result. The callback should respect the args just like
+//        the DefaultResultSet does, see also readEntryInto.
+//      * Delete: no arguments, the result is ignored
+//      * MkBucket: the options, the result is ignored
+//      * WriteAttrs: the src, an Entry is expected as result
+//      * Invoke: the args of the invocation, the result is returned as is
+//  * a result of the wrong type returns EINVAL
+//  * links, Rename, transactions and listeners are not supported
+type RouterFileSystem struct {
+	// Name is returned by String
+	Name string
+
+	OpenRoutes       Router
+	ReadBucketRoutes Router
+	ReadAttrsRoutes  Router
+	DeleteRoutes     Router
+	MkBucketRoutes   Router
+	WriteAttrsRoutes Router
+	InvokeRoutes     Router
+}
+
+// dispatch invokes the first matching route and translates the result.
+func (r *RouterFileSystem) dispatch(ctx context.Context, op string, router *Router, path string, args ...interface{}) (interface{}, error) {
+	if router.empty() {
+		return nil, NewENOSYS(op+" not supported", r)
+	}
+	res, matched, err := router.dispatch(ctx, Path(path), args...)
+	if !matched {
+		return nil, &DefaultError{Message: "no " + op + " route: " + path, Code: ENOENT, DetailsPayload: []string{path}}
+	}
+	return res, err
+}
+
+// invalidResult returns EINVAL for a route which has returned the wrong type
+func (r *RouterFileSystem) invalidResult(op string, path string, res interface{}) error {
+	return &DefaultError{Message: fmt.Sprintf("%s route %s returned %T", op, path, res), Code: EINVAL, DetailsPayload: []string{path}}
+}
+
+func (r *RouterFileSystem) Connect(ctx context.Context, path string, options interface{}) (interface{}, error) {
+	return nil, NewENOSYS("Connect not supported", r)
+}
+
+func (r *RouterFileSystem) Disconnect(ctx context.Context, path string) error {
+	return nil
+}
+
+func (r *RouterFileSystem) FireEvent(ctx context.Context, path string, event interface{}) error {
+	return NewENOSYS("FireEvent not supported", r)
+}
+
+func (r *RouterFileSystem) AddListener(ctx context.Context, path string, listener ResourceListener) (handle int, err error) {
+	return -1, NewENOSYS("AddListener not supported", r)
+}
+
+func (r *RouterFileSystem) RemoveListener(ctx context.Context, handle int) error {
+	return NewENOSYS("RemoveListener not supported", r)
+}
+
+func (r *RouterFileSystem) Begin(ctx context.Context, path string, options interface{}) (context.Context, error) {
+	return nil, NewENOSYS("Begin transaction not supported", r)
+}
+
+func (r *RouterFileSystem) Commit(ctx context.Context) error {
+	return NewENOSYS("Commit transaction not supported", r)
+}
+
+func (r *RouterFileSystem) Rollback(ctx context.Context) error {
+	return NewENOSYS("Rollback transaction not supported", r)
+}
+
+func (r *RouterFileSystem) Open(ctx context.Context, path string, flag int, options interface{}) (Blob, error) {
+	res, err := r.dispatch(ctx, "Open", &r.OpenRoutes, path, flag, options)
+	if res == nil {
+		return nil, err
+	}
+	if blob, ok := res.(Blob); ok {
+		return blob, err
+	}
+	return nil, r.invalidResult("Open", path, res)
+}
+
+func (r *RouterFileSystem) Delete(ctx context.Context, path string) error {
+	if r.DeleteRoutes.empty() {
+		return NewENOSYS("Delete not supported", r)
+	}
+	_, _, err := r.DeleteRoutes.dispatch(ctx, Path(path))
+	return err
+}
+
+func (r *RouterFileSystem) ReadAttrs(ctx context.Context, path string, args interface{}) (Entry, error) {
+	res, err := r.dispatch(ctx, "ReadAttrs", &r.ReadAttrsRoutes, path, args)
+	if res == nil {
+		return nil, err
+	}
+	if entry, ok := res.(Entry); ok {
+		return entry, err
+	}
+	return nil, r.invalidResult("ReadAttrs", path, res)
+}
+
+func (r *RouterFileSystem) ReadForks(ctx context.Context, path string) ([]string, error) {
+	return nil, NewENOSYS("ReadForks not supported", r)
+}
+
+func (r *RouterFileSystem) WriteAttrs(ctx context.Context, path string, src interface{}) (Entry, error) {
+	res, err := r.dispatch(ctx, "WriteAttrs", &r.WriteAttrsRoutes, path, src)
+	if res == nil {
+		return nil, err
+	}
+	if entry, ok := res.(Entry); ok {
+		return entry, err
+	}
+	return nil, r.invalidResult("WriteAttrs", path, res)
+}
+
+func (r *RouterFileSystem) ReadBucket(ctx context.Context, path string, options interface{}) (ResultSet, error) {
+	res, err := r.dispatch(ctx, "ReadBucket", &r.ReadBucketRoutes, path, options)
+	if res == nil {
+		return nil, err
+	}
+	if rs, ok := res.(ResultSet); ok {
+		return rs, err
+	}
+	return nil, r.invalidResult("ReadBucket", path, res)
+}
+
+func (r *RouterFileSystem) Invoke(ctx context.Context, endpoint string, args ...interface{}) (interface{}, error) {
+	return r.dispatch(ctx, "Invoke", &r.InvokeRoutes, endpoint, args...)
+}
+
+func (r *RouterFileSystem) MkBucket(ctx context.Context, path string, options interface{}) error {
+	_, err := r.dispatch(ctx, "MkBucket", &r.MkBucketRoutes, path, options)
+	return err
+}
+
+func (r *RouterFileSystem) Rename(ctx context.Context, oldPath string, newPath string) error {
+	return NewENOSYS("Rename not supported", r)
+}
+
+func (r *RouterFileSystem) SymLink(ctx context.Context, oldPath string, newPath string) error {
+	return NewENOSYS("SymLink not supported", r)
+}
+
+func (r *RouterFileSystem) HardLink(ctx context.Context, oldPath string, newPath string) error {
+	return NewENOSYS("HardLink not supported", r)
+}
+
+func (r *RouterFileSystem) RefLink(ctx context.Context, oldPath string, newPath string) error {
+	return NewENOSYS("RefLink not supported", r)
+}
+
+func (r *RouterFileSystem) Close() error {
+	return nil
+}
+
+func (r *RouterFileSystem) String() string {
+	if len(r.Name) == 0 {
+		return "RouterFileSystem"
+	}
+	return r.Name
+}
@@ -0,0 +1,88 @@
+package vfs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRouterFileSystem(t *testing.T) {
+	photos := map[string][]string{"alice": {"1.jpg", "2.jpg"}, "bob": {}}
+	fs := &RouterFileSystem{}
+	fs.ReadBucketRoutes.MatchResultSet("/users", func(ctx RoutingContext) (ResultSet, error) {
+		return &DefaultResultSet{Entries: []*DefaultEntry{{Id: "alice", IsBucket: true}, {Id: "bob", IsBucket: true}}}, nil
+	})
+	fs.ReadBucketRoutes.MatchResultSet("/users/{id}/photos", func(ctx RoutingContext) (ResultSet, error) {
+		names, ok := photos[ctx.ValueOf("id")]
+		if !ok {
+			return nil, &DefaultError{Message: "unknown user", Code: ENOENT}
+		}
+		res := &DefaultResultSet{}
+		for _, name := range names {
+			res.Entries = append(res.Entries, &DefaultEntry{Id: name, Length: int64(len(name))})
+		}
+		return res, nil
+	})
+	fs.OpenRoutes.MatchBlob("/users/{id}/photos/{photoId}", func(ctx RoutingContext) (Blob, error) {
+		if flag := ctx.Args()[0].(int); flag != os.O_RDONLY {
+			return nil, &DefaultError{Message: "read only", Code: EROFS}
+		}
+		return &BlobAdapter{Delegate: strings.NewReader(ctx.ValueOf("id") + "/" + ctx.ValueOf("photoId"))}, nil
+	})
+	fs.InvokeRoutes.Match("/users/{id}/share", func(ctx RoutingContext) (interface{}, error) {
+		return ctx.ValueOf("id") + " with " + ctx.Args()[0].(string), nil
+	})
+	ctx := context.Background()
+
+	res, err := fs.ReadBucket(ctx, "/users/alice/photos", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Len() != 2 || res.ReadAttrs(1, nil).Name() != "2.jpg" {
+		t.Fatal("unexpected listing", res.Len())
+	}
+	if _, err := fs.ReadBucket(ctx, "/users/carol/photos", nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+	if _, err := fs.ReadBucket(ctx, "/groups", nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+
+	blob, err := fs.Open(ctx, "/users/alice/photos/1.jpg", os.O_RDONLY, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "alice/1.jpg" {
+		t.Fatal("expected alice/1.jpg but got", string(data))
+	}
+	if _, err := fs.Open(ctx, "/users/alice/photos/1.jpg", os.O_WRONLY, nil); !IsErr(err, EROFS) {
+		t.Fatal("expected EROFS but got", err)
+	}
+
+	res2, err := fs.Invoke(ctx, "/users/bob/share", "alice")
+	if err != nil || res2 != "bob with alice" {
+		t.Fatal("unexpected result", res2, err)
+	}
+	if _, err := fs.ReadAttrs(ctx, "/users", nil); !IsErr(err, ENOSYS) {
+		t.Fatal("expected ENOSYS but got", err)
+	}
+
+	// an unmatched Delete is not an error
+	deleted := ""
+	fs.DeleteRoutes.Match("/users/{id}", func(ctx RoutingContext) (interface{}, error) {
+		deleted = ctx.ValueOf("id")
+		return nil, nil
+	})
+	if err := fs.Delete(ctx, "/groups/admins"); err != nil {
+		t.Fatal("expected nil but got", err)
+	}
+	if err := fs.Delete(ctx, "/users/bob"); err != nil || deleted != "bob" {
+		t.Fatal("expected a deleted bob but got", deleted, err)
+	}
+}
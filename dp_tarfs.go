@@ -0,0 +1,368 @@
+package vfs
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ FileSystem = (*TarFileSystem)(nil)
+
+// A TarFileSystem provides the read only content of a tar or a gzip compressed tar archive. It is thread safe.
+//
+// Details
+//
+//  * the archive is indexed once by the first access. For uncompressed archives the index contains the offset of each
+//    entry, so that Open returns a Blob with ReadAt which reads directly from the archive. Compressed or sparse
+//    entries can only be read sequentially, Seek is emulated by skipping or decompressing again.
+//  * ReadAttrs and ReadBucket return DefaultEntry instances with a *TarAttrs payload. Missing parent buckets are
+//    synthesized, they have no Sys() payload.
+//  * symbolic links are resolved for every path segment. Hard links share the content of the former entry.
+//  * all modifications return EROFS. Listeners, forks, transactions and Invoke are not supported.
+type TarFileSystem struct {
+	reader   io.ReaderAt
+	size     int64
+	gzip     bool
+	closer   io.Closer
+	once     sync.Once
+	index    *archiveIndex
+	indexErr error
+}
+
+// TarAttrs is the payload returned by Entry.Sys() for all non-synthetic entries of a TarFileSystem.
+type TarAttrs struct {
+	// Header is the original header of the entry
+	Header *tar.Header
+}
+
+// ModTime returns the modification time of the entry, so that TarAttrs looks like an os.FileInfo.
+func (a *TarAttrs) ModTime() time.Time {
+	return a.Header.ModTime
+}
+
+// Mode returns the permission and mode bits.
+func (a *TarAttrs) Mode() os.FileMode {
+	return a.Header.FileInfo().Mode()
+}
+
+// Uid returns the user id of the owner.
+func (a *TarAttrs) Uid() int {
+	return a.Header.Uid
+}
+
+// Gid returns the group id of the owner.
+func (a *TarAttrs) Gid() int {
+	return a.Header.Gid
+}
+
+// Uname returns the user name of the owner.
+func (a *TarAttrs) Uname() string {
+	return a.Header.Uname
+}
+
+// Gname returns the group name of the owner.
+func (a *TarAttrs) Gname() string {
+	return a.Header.Gname
+}
+
+// PAXRecords returns the PAX extended header records, e.g. SCHILY.xattr.* for extended attributes.
+func (a *TarAttrs) PAXRecords() map[string]string {
+	return a.Header.PAXRecords
+}
+
+// Linkname returns the not resolved target of a symbolic or hard link.
+func (a *TarAttrs) Linkname() string {
+	return a.Header.Linkname
+}
+
+// a tarEntry is the payload of an index node. The offset is -1 if the data cannot be accessed directly.
+type tarEntry struct {
+	ordinal int
+	offset  int64
+	size    int64
+}
+
+// NewTarFileSystem returns a TarFileSystem for an uncompressed tar archive.
+func NewTarFileSystem(r io.ReaderAt, size int64) *TarFileSystem {
+	return &TarFileSystem{reader: r, size: size}
+}
+
+// NewTarGzFileSystem returns a TarFileSystem for a gzip compressed tar archive.
+func NewTarGzFileSystem(r io.ReaderAt, size int64) *TarFileSystem {
+	return &TarFileSystem{reader: r, size: size, gzip: true}
+}
+
+// OpenTarFileSystem opens a local tar or tar.gz file, the compression is detected automatically. The file is
+// closed by Close.
+func OpenTarFileSystem(name string) (*TarFileSystem, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	magic := make([]byte, 2)
+	_, err = file.ReadAt(magic, 0)
+	isGzip := err == nil && magic[0] == 0x1f && magic[1] == 0x8b
+	return &TarFileSystem{reader: file, size: stat.Size(), gzip: isGzip, closer: file}, nil
+}
+
+// stream returns the uncompressed archive from the beginning.
+func (t *TarFileSystem) stream() (io.ReadCloser, error) {
+	section := io.NewSectionReader(t.reader, 0, t.size)
+	if !t.gzip {
+		return ioutil.NopCloser(section), nil
+	}
+	gz, err := gzip.NewReader(section)
+	if err != nil {
+		return nil, &DefaultError{Message: "invalid gzip stream", Code: EILSEQ, CausedBy: err}
+	}
+	return gz, nil
+}
+
+// getIndex scans the archive once.
+func (t *TarFileSystem) getIndex() (*archiveIndex, error) {
+	t.once.Do(func() {
+		t.index, t.indexErr = t.scan()
+	})
+	return t.index, t.indexErr
+}
+
+func (t *TarFileSystem) scan() (*archiveIndex, error) {
+	var section *io.SectionReader
+	var r io.Reader
+	if t.gzip {
+		gz, err := t.stream()
+		if err != nil {
+			return nil, err
+		}
+		defer silentClose(gz)
+		r = gz
+	} else {
+		// the tar reader seeks over the data of the entries
+		section = io.NewSectionReader(t.reader, 0, t.size)
+		r = section
+	}
+
+	index := newArchiveIndex()
+	tr := tar.NewReader(r)
+	for ordinal := 0; ; ordinal++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return index, nil
+		}
+		if err != nil {
+			return nil, &DefaultError{Message: "invalid tar archive", Code: EILSEQ, CausedBy: err}
+		}
+		path := Path(hdr.Name).Normalize()
+		entry := &DefaultEntry{Length: hdr.Size, Data: &TarAttrs{Header: hdr}}
+		var payload interface{} = &tarEntry{ordinal: ordinal, offset: -1, size: hdr.Size}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			entry.IsBucket = true
+			entry.Length = 0
+			payload = nil
+		case tar.TypeSymlink:
+			entry.Length = 0
+			index.put(path, entry, nil).link = hdr.Linkname
+			continue
+		case tar.TypeLink:
+			// the target must be a former entry of the archive
+			payload = nil
+			entry.Length = 0
+			if target, err := index.get(Path(hdr.Linkname).Normalize()); err == nil && target.children == nil {
+				payload = target.payload
+				entry.Length = target.entry.Length
+			}
+		default:
+			if section != nil && !isSparse(hdr) {
+				offset, err := section.Seek(0, io.SeekCurrent)
+				if err != nil {
+					return nil, err
+				}
+				payload.(*tarEntry).offset = offset
+			}
+		}
+		index.put(path, entry, payload)
+	}
+}
+
+func isSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for key := range hdr.PAXRecords {
+		if strings.HasPrefix(key, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// openEntry decompresses the archive and returns the reader of the nth entry.
+func (t *TarFileSystem) openEntry(ordinal int) (io.ReadCloser, error) {
+	r, err := t.stream()
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(r)
+	for i := 0; i <= ordinal; i++ {
+		if _, err := tr.Next(); err != nil {
+			_ = r.Close()
+			return nil, &DefaultError{Message: "invalid tar archive", Code: EILSEQ, CausedBy: err}
+		}
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{tr, r}, nil
+}
+
+func (t *TarFileSystem) Connect(ctx context.Context, path string, options interface{}) (interface{}, error) {
+	return options, nil
+}
+
+func (t *TarFileSystem) Disconnect(ctx context.Context, path string) error {
+	return nil
+}
+
+func (t *TarFileSystem) FireEvent(ctx context.Context, path string, event interface{}) error {
+	return NewENOSYS("FireEvent not supported", t)
+}
+
+func (t *TarFileSystem) AddListener(ctx context.Context, path string, listener ResourceListener) (handle int, err error) {
+	return -1, NewENOSYS("AddListener not supported", t)
+}
+
+func (t *TarFileSystem) RemoveListener(ctx context.Context, handle int) error {
+	return NewENOSYS("RemoveListener not supported", t)
+}
+
+func (t *TarFileSystem) Begin(ctx context.Context, path string, options interface{}) (context.Context, error) {
+	return nil, NewENOSYS("Begin transaction not supported", t)
+}
+
+func (t *TarFileSystem) Commit(ctx context.Context) error {
+	return NewENOSYS("Commit transaction not supported", t)
+}
+
+func (t *TarFileSystem) Rollback(ctx context.Context) error {
+	return NewENOSYS("Rollback transaction not supported", t)
+}
+
+// Open returns a read only Blob. Any other flag than O_RDONLY results in EROFS.
+func (t *TarFileSystem) Open(ctx context.Context, path string, flag int, options interface{}) (Blob, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE) != 0 {
+		return nil, t.readOnly("Open")
+	}
+	index, err := t.getIndex()
+	if err != nil {
+		return nil, err
+	}
+	p := Path(path).Normalize()
+	node, err := index.get(p)
+	if err != nil {
+		return nil, err
+	}
+	if node.children != nil {
+		return nil, &DefaultError{Message: p.String(), Code: EISDIR, DetailsPayload: []string{p.String()}}
+	}
+	entry, ok := node.payload.(*tarEntry)
+	if !ok {
+		// e.g. a hard link with a missing target
+		return nil, &DefaultError{Message: p.String(), Code: ENOENT, DetailsPayload: []string{p.String()}}
+	}
+	blob := &archiveBlob{size: entry.size, open: func() (io.ReadCloser, error) {
+		return t.openEntry(entry.ordinal)
+	}}
+	if entry.offset >= 0 {
+		blob.section = io.NewSectionReader(t.reader, entry.offset, entry.size)
+	}
+	return blob, nil
+}
+
+func (t *TarFileSystem) readOnly(op string) error {
+	return &DefaultError{Message: op + ": tar archive is read only", Code: EROFS}
+}
+
+func (t *TarFileSystem) Delete(ctx context.Context, path string) error {
+	return t.readOnly("Delete")
+}
+
+// ReadAttrs resolves symbolic links.
+func (t *TarFileSystem) ReadAttrs(ctx context.Context, path string, args interface{}) (Entry, error) {
+	index, err := t.getIndex()
+	if err != nil {
+		return nil, err
+	}
+	node, err := index.get(Path(path).Normalize())
+	if err != nil {
+		return nil, err
+	}
+	entry := *node.entry
+	return readEntryInto(&entry, args), nil
+}
+
+func (t *TarFileSystem) ReadForks(ctx context.Context, path string) ([]string, error) {
+	return nil, NewENOSYS("ReadForks not supported", t)
+}
+
+func (t *TarFileSystem) WriteAttrs(ctx context.Context, path string, src interface{}) (Entry, error) {
+	return nil, t.readOnly("WriteAttrs")
+}
+
+// ReadBucket returns all entries sorted by name within a single page. Symbolic links are not resolved.
+func (t *TarFileSystem) ReadBucket(ctx context.Context, path string, options interface{}) (ResultSet, error) {
+	index, err := t.getIndex()
+	if err != nil {
+		return nil, err
+	}
+	return index.list(Path(path).Normalize())
+}
+
+func (t *TarFileSystem) Invoke(ctx context.Context, endpoint string, args ...interface{}) (interface{}, error) {
+	return nil, NewENOSYS("Invoke not supported", t)
+}
+
+func (t *TarFileSystem) MkBucket(ctx context.Context, path string, options interface{}) error {
+	return t.readOnly("MkBucket")
+}
+
+func (t *TarFileSystem) Rename(ctx context.Context, oldPath string, newPath string) error {
+	return t.readOnly("Rename")
+}
+
+func (t *TarFileSystem) SymLink(ctx context.Context, oldPath string, newPath string) error {
+	return t.readOnly("SymLink")
+}
+
+func (t *TarFileSystem) HardLink(ctx context.Context, oldPath string, newPath string) error {
+	return t.readOnly("HardLink")
+}
+
+func (t *TarFileSystem) RefLink(ctx context.Context, oldPath string, newPath string) error {
+	return t.readOnly("RefLink")
+}
+
+// Close closes the file opened by OpenTarFileSystem.
+func (t *TarFileSystem) Close() error {
+	if t.closer != nil {
+		closer := t.closer
+		t.closer = nil
+		return closer.Close()
+	}
+	return nil
+}
+
+func (t *TarFileSystem) String() string {
+	return "TarFileSystem"
+}
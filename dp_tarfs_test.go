@@ -0,0 +1,139 @@
+package vfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func createTestTar(t *testing.T) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	modTime := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+	write := func(hdr *tar.Header, data string) {
+		hdr.ModTime = modTime
+		hdr.Size = int64(len(data))
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(&tar.Header{Name: "backup/", Typeflag: tar.TypeDir, Mode: 0755}, "")
+	write(&tar.Header{Name: "backup/data/a.txt", Typeflag: tar.TypeReg, Mode: 0640, Uid: 1000, Gid: 100,
+		Uname: "alice", Gname: "users", PAXRecords: map[string]string{"SCHILY.xattr.user.tag": "blue"}}, "0123456789")
+	write(&tar.Header{Name: "backup/b.txt", Typeflag: tar.TypeReg, Mode: 0644}, "hello tar")
+	write(&tar.Header{Name: "backup/links/sym.txt", Typeflag: tar.TypeSymlink, Linkname: "../data/a.txt"}, "")
+	write(&tar.Header{Name: "backup/links/dir", Typeflag: tar.TypeSymlink, Linkname: "/backup/data"}, "")
+	write(&tar.Header{Name: "backup/links/hard.txt", Typeflag: tar.TypeLink, Linkname: "backup/b.txt"}, "")
+	write(&tar.Header{Name: "backup/links/loop", Typeflag: tar.TypeSymlink, Linkname: "loop"}, "")
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	if _, err := gz.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestTarFileSystem(t *testing.T) {
+	data := createTestTar(t)
+	gzData := gzipBytes(t, data)
+	for _, tfs := range []*TarFileSystem{
+		NewTarFileSystem(bytes.NewReader(data), int64(len(data))),
+		NewTarGzFileSystem(bytes.NewReader(gzData), int64(len(gzData))),
+	} {
+		testTarFileSystem(t, tfs)
+	}
+}
+
+func testTarFileSystem(t *testing.T, tfs *TarFileSystem) {
+	ctx := context.Background()
+	res, err := tfs.ReadBucket(ctx, "/backup", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Len() != 3 || res.ReadAttrs(0, nil).Name() != "b.txt" || !res.ReadAttrs(1, nil).IsDir() {
+		t.Fatal("unexpected result", res.Sys())
+	}
+
+	entry, err := tfs.ReadAttrs(ctx, "/backup/data/a.txt", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs := entry.Sys().(*TarAttrs)
+	if attrs.Uid() != 1000 || attrs.Gid() != 100 || attrs.Uname() != "alice" || attrs.Mode().Perm() != 0640 {
+		t.Fatal("unexpected attributes", attrs.Header)
+	}
+	if attrs.PAXRecords()["SCHILY.xattr.user.tag"] != "blue" {
+		t.Fatal("expected pax record but got", attrs.PAXRecords())
+	}
+	if !attrs.ModTime().Equal(time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Fatal("unexpected mod time", attrs.ModTime())
+	}
+
+	if str := memRead(t, tfs, "/backup/links/sym.txt"); str != "0123456789" {
+		t.Fatal("expected 0123456789 but got", str)
+	}
+	if str := memRead(t, tfs, "/backup/links/dir/a.txt"); str != "0123456789" {
+		t.Fatal("expected 0123456789 but got", str)
+	}
+	if str := memRead(t, tfs, "/backup/links/hard.txt"); str != "hello tar" {
+		t.Fatal("expected hello tar but got", str)
+	}
+	if _, err := tfs.Open(ctx, "/backup/links/loop", os.O_RDONLY, nil); !IsErr(err, ELOOP) {
+		t.Fatal("expected ELOOP but got", err)
+	}
+	if _, err := tfs.Open(ctx, "/backup/missing", os.O_RDONLY, nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+	if _, err := tfs.Open(ctx, "/backup/new", os.O_CREATE|os.O_WRONLY, nil); !IsErr(err, EROFS) {
+		t.Fatal("expected EROFS but got", err)
+	}
+
+	blob, err := tfs.Open(ctx, "/backup/data/a.txt", os.O_RDONLY, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silentClose(blob)
+	buf := make([]byte, 3)
+	if _, err := blob.Seek(4, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(blob, buf); err != nil || string(buf) != "456" {
+		t.Fatal("expected 456 but got", string(buf), err)
+	}
+	_, err = blob.ReadAt(buf, 7)
+	if tfs.gzip {
+		if !IsErr(err, ENOSYS) {
+			t.Fatal("expected ENOSYS but got", err)
+		}
+	} else if err != nil || string(buf) != "789" {
+		t.Fatal("expected 789 but got", string(buf), err)
+	}
+}
+
+func TestTarFileSystem_Invalid(t *testing.T) {
+	data := []byte("this is not a tar archive, but it has at least some bytes")
+	tfs := NewTarGzFileSystem(bytes.NewReader(data), int64(len(data)))
+	if _, err := tfs.ReadBucket(context.Background(), "/", nil); !IsErr(err, EILSEQ) {
+		t.Fatal("expected EILSEQ but got", err)
+	}
+}
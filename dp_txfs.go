@@ -0,0 +1,515 @@
+package vfs
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var _ FileSystem = (*TxFileSystem)(nil)
+
+// TxOptionIsolation is the key of the Begin options map to request an IsolationLevel.
+const TxOptionIsolation = "isolation"
+
+// An IsolationLevel defines the visibility of changes between transactions.
+type IsolationLevel string
+
+const (
+	// IsolationNone lets the implementation choose the isolation level
+	IsolationNone IsolationLevel = "none"
+	// IsolationReadCommitted guarantees that changes of a transaction are only visible after a Commit
+	IsolationReadCommitted IsolationLevel = "read-committed"
+	// IsolationRepeatableRead guarantees that an entry does not change while a transaction is running
+	IsolationRepeatableRead IsolationLevel = "repeatable-read"
+	// IsolationSerializable guarantees that concurrent transactions are applied as if they were executed serially
+	IsolationSerializable IsolationLevel = "serializable"
+)
+
+const (
+	defaultTxShadow = "/.vfstx"
+	txJournalName   = "journal.json"
+	txDeletedMarker = "deleted"
+)
+
+// A TxFileSystem decorates any FileSystem with transactions. All changes of a transaction are staged in a shadow
+// bucket of the Delegate and are applied by Commit using a journal, which is published by an atomic rename.
+//
+// Details
+//
+//  * Begin accepts nil or a map[string]interface{} with TxOptionIsolation. IsolationNone and
+//    IsolationReadCommitted are supported, other levels return EINISOL. A nested Begin returns EDEADLK, as long
+//    as the outer transaction has not been finished.
+//  * the path of Begin defines the scope, accessing paths outside of it within the transaction returns EINVAL
+//  * within a transaction, all changes are visible to the transaction itself but not to others until Commit.
+//    The staged view works like an OverlayFileSystem, so renaming a bucket copies it into the shadow bucket.
+//  * Commit writes the journal, applies the deletes, buckets and blobs in that order and removes the staged data.
+//    Commits are serialized but concurrent changes are not detected, the last Commit wins.
+//  * if the process dies during Commit, Recover replays all published journals and discards everything else
+//  * the Delegate must support Rename. Outside of a transaction, all calls are delegated as is.
+//  * the Shadow bucket is hidden and cannot be accessed through the TxFileSystem
+type TxFileSystem struct {
+	// Delegate contains the data and the Shadow bucket
+	Delegate FileSystem
+	// Shadow is the bucket of staged data and journals. It must not be inside of a transaction scope, defaults
+	// to /.vfstx
+	Shadow Path
+	// lock serializes Commit and Recover
+	lock    sync.Mutex
+	counter int64
+}
+
+type txContextKey struct {
+	fs *TxFileSystem
+}
+
+// a transaction is the state stored in the context of Begin
+type transaction struct {
+	id    string
+	scope Path
+	dir   Path
+	view  *OverlayFileSystem
+	done  int32
+}
+
+// a txJournal contains all changes of a transaction, relative to the root of the Delegate.
+type txJournal struct {
+	Id      string   `json:"id"`
+	Deletes []string `json:"deletes"`
+	Buckets []string `json:"buckets"`
+	Blobs   []string `json:"blobs"`
+}
+
+func (t *TxFileSystem) shadow() Path {
+	if len(t.Shadow) == 0 {
+		return defaultTxShadow
+	}
+	return t.Shadow.Normalize()
+}
+
+// isSubPath returns true, if path equals parent or is a child of it. Both paths must be normalized.
+func isSubPath(path Path, parent Path) bool {
+	return path == parent || parent == "/" || strings.HasPrefix(string(path), string(parent)+"/")
+}
+
+// transaction returns the running transaction or nil. Returns ETXINVALID if the transaction has been finished.
+func (t *TxFileSystem) transaction(ctx context.Context) (*transaction, error) {
+	if ctx == nil {
+		return nil, nil
+	}
+	tx, _ := ctx.Value(txContextKey{t}).(*transaction)
+	if tx != nil && atomic.LoadInt32(&tx.done) == 1 {
+		return nil, &DefaultError{Message: "transaction " + tx.id + " already finished", Code: ETXINVALID}
+	}
+	return tx, nil
+}
+
+// route returns either the Delegate or the view of the running transaction.
+func (t *TxFileSystem) route(ctx context.Context, path string) (FileSystem, error) {
+	p := Path(path).Normalize()
+	if isSubPath(p, t.shadow()) {
+		return nil, &DefaultError{Message: "transaction shadow is not accessible: " + p.String(), Code: EACCES, DetailsPayload: []string{p.String()}}
+	}
+	tx, err := t.transaction(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if tx == nil {
+		return t.Delegate, nil
+	}
+	if !isSubPath(p, tx.scope) {
+		return nil, &DefaultError{Message: "outside of transaction scope " + tx.scope.String() + ": " + p.String(), Code: EINVAL, DetailsPayload: []string{p.String()}}
+	}
+	return tx.view, nil
+}
+
+func (t *TxFileSystem) route2(ctx context.Context, oldPath string, newPath string) (FileSystem, error) {
+	fs, err := t.route(ctx, oldPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := t.route(ctx, newPath); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+func (t *TxFileSystem) Connect(ctx context.Context, path string, options interface{}) (interface{}, error) {
+	return t.Delegate.Connect(ctx, path, options)
+}
+
+func (t *TxFileSystem) Disconnect(ctx context.Context, path string) error {
+	return t.Delegate.Disconnect(ctx, path)
+}
+
+func (t *TxFileSystem) FireEvent(ctx context.Context, path string, event interface{}) error {
+	return t.Delegate.FireEvent(ctx, path, event)
+}
+
+func (t *TxFileSystem) AddListener(ctx context.Context, path string, listener ResourceListener) (handle int, err error) {
+	return t.Delegate.AddListener(ctx, path, listener)
+}
+
+func (t *TxFileSystem) RemoveListener(ctx context.Context, handle int) error {
+	return t.Delegate.RemoveListener(ctx, handle)
+}
+
+// Begin starts a transaction for the given bucket and creates its shadow bucket.
+func (t *TxFileSystem) Begin(ctx context.Context, path string, options interface{}) (context.Context, error) {
+	// a finished transaction of the context does not prevent a new one
+	if tx, _ := t.transaction(ctx); tx != nil {
+		return nil, &DefaultError{Message: "nested transactions are not supported", Code: EDEADLK}
+	}
+	level := IsolationReadCommitted
+	if options != nil {
+		opts, ok := options.(map[string]interface{})
+		if !ok {
+			return nil, &DefaultError{Message: "options must be a map[string]interface{}", Code: EINVAL, DetailsPayload: options}
+		}
+		switch l := opts[TxOptionIsolation].(type) {
+		case nil:
+		case string:
+			level = IsolationLevel(l)
+		case IsolationLevel:
+			level = l
+		default:
+			return nil, &DefaultError{Message: "invalid isolation level", Code: EINISOL, DetailsPayload: l}
+		}
+	}
+	if level != IsolationNone && level != IsolationReadCommitted {
+		return nil, &DefaultError{Message: "unsupported isolation level: " + string(level), Code: EINISOL, DetailsPayload: level}
+	}
+
+	scope := Path(path).Normalize()
+	if isSubPath(scope, t.shadow()) {
+		return nil, &DefaultError{Message: "invalid transaction scope: " + scope.String(), Code: EINVAL, DetailsPayload: []string{scope.String()}}
+	}
+	id := strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatInt(atomic.AddInt64(&t.counter, 1), 36)
+	dir := t.shadow().Child(id)
+	if err := t.Delegate.MkBucket(ctx, dir.Child("data").String(), nil); err != nil {
+		return nil, err
+	}
+	tx := &transaction{id: id, scope: scope, dir: dir}
+	tx.view = &OverlayFileSystem{
+		Upper: &ChRoot{Prefix: dir.Child("data"), Delegate: t.Delegate},
+		Lower: []FileSystem{t.Delegate},
+	}
+	return context.WithValue(ctx, txContextKey{t}, tx), nil
+}
+
+// finish marks the transaction of the context as done. Returns ETXINVALID if there is no running transaction.
+func (t *TxFileSystem) finish(ctx context.Context) (*transaction, error) {
+	tx, err := t.transaction(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if tx == nil || !atomic.CompareAndSwapInt32(&tx.done, 0, 1) {
+		return nil, &DefaultError{Message: "no pending transaction", Code: ETXINVALID}
+	}
+	return tx, nil
+}
+
+// Commit publishes the journal of the transaction and applies it.
+func (t *TxFileSystem) Commit(ctx context.Context) error {
+	tx, err := t.finish(ctx)
+	if err != nil {
+		return err
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	journal := &txJournal{Id: tx.id}
+	if err := t.collect(ctx, tx.view.Upper, "/", journal); err != nil {
+		_ = t.Delegate.Delete(ctx, tx.dir.String())
+		return err
+	}
+	data, err := json.Marshal(journal)
+	if err != nil {
+		return err
+	}
+	tmp := tx.dir.Child(txJournalName + ".tmp").String()
+	if _, err := WriteAllFS(ctx, t.Delegate, tmp, data); err != nil {
+		_ = t.Delegate.Delete(ctx, tx.dir.String())
+		return err
+	}
+	// this is the point of no return
+	if err := t.Delegate.Rename(ctx, tmp, tx.dir.Child(txJournalName).String()); err != nil {
+		_ = t.Delegate.Delete(ctx, tx.dir.String())
+		return err
+	}
+	return t.apply(ctx, tx.dir, journal)
+}
+
+// collect walks the staged data and translates the markers of the overlay into the journal.
+func (t *TxFileSystem) collect(ctx context.Context, staged FileSystem, path Path, journal *txJournal) error {
+	entries, err := readEntries(ctx, staged, path.String(), nil)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Id == overlayOpaqueMarker {
+			journal.Deletes = append(journal.Deletes, path.String())
+			journal.Buckets = append(journal.Buckets, path.String())
+		}
+	}
+	for _, entry := range entries {
+		child := path.Child(entry.Id)
+		switch {
+		case entry.Id == overlayOpaqueMarker:
+		case isOverlayMarker(entry.Id):
+			journal.Deletes = append(journal.Deletes, path.Child(strings.TrimPrefix(entry.Id, overlayWhiteoutPrefix)).String())
+		case entry.IsBucket:
+			journal.Buckets = append(journal.Buckets, child.String())
+			if err := t.collect(ctx, staged, child, journal); err != nil {
+				return err
+			}
+		default:
+			journal.Blobs = append(journal.Blobs, child.String())
+		}
+	}
+	return nil
+}
+
+// apply performs the journal idempotently. A marker protects the deletes from being repeated, because they
+// would remove already moved blobs.
+func (t *TxFileSystem) apply(ctx context.Context, dir Path, journal *txJournal) error {
+	marker := dir.Child(txDeletedMarker).String()
+	done, err := exists(ctx, t.Delegate, marker)
+	if err != nil {
+		return err
+	}
+	if !done {
+		for _, path := range journal.Deletes {
+			if err := t.deleteTree(ctx, Path(path)); err != nil {
+				return err
+			}
+		}
+		if _, err := WriteAllFS(ctx, t.Delegate, marker, nil); err != nil {
+			return err
+		}
+	}
+	sort.Strings(journal.Buckets)
+	for _, path := range journal.Buckets {
+		if err := t.Delegate.MkBucket(ctx, path, nil); err != nil {
+			return err
+		}
+	}
+	data := dir.Child("data")
+	for _, path := range journal.Blobs {
+		staged := data.Add(Path(path)).String()
+		ok, err := exists(ctx, t.Delegate, staged)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// already applied
+			continue
+		}
+		if err := t.Delegate.Rename(ctx, staged, path); err != nil {
+			return err
+		}
+	}
+	return t.Delegate.Delete(ctx, dir.String())
+}
+
+// deleteTree deletes the path but keeps the shadow bucket.
+func (t *TxFileSystem) deleteTree(ctx context.Context, path Path) error {
+	if !isSubPath(t.shadow(), path) {
+		return t.Delegate.Delete(ctx, path.String())
+	}
+	entries, err := readEntries(ctx, t.Delegate, path.String(), nil)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		child := path.Child(entry.Id)
+		if child == t.shadow() {
+			continue
+		}
+		if err := t.deleteTree(ctx, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rollback discards all staged changes.
+func (t *TxFileSystem) Rollback(ctx context.Context) error {
+	tx, err := t.finish(ctx)
+	if err != nil {
+		return err
+	}
+	return t.Delegate.Delete(ctx, tx.dir.String())
+}
+
+// Recover applies all published journals and discards all other transactions. It must be called before any
+// transaction is started, e.g. at startup.
+func (t *TxFileSystem) Recover(ctx context.Context) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	entries, err := readEntries(ctx, t.Delegate, t.shadow().String(), nil)
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		dir := t.shadow().Child(entry.Id)
+		data, err := ReadAllFS(ctx, t.Delegate, dir.Child(txJournalName).String())
+		if err != nil {
+			if !isNotFound(err) {
+				return err
+			}
+			// not committed
+			if err := t.Delegate.Delete(ctx, dir.String()); err != nil {
+				return err
+			}
+			continue
+		}
+		journal := &txJournal{}
+		if err := json.Unmarshal(data, journal); err != nil {
+			return &DefaultError{Message: "invalid journal " + dir.String(), Code: EILSEQ, CausedBy: err}
+		}
+		if err := t.apply(ctx, dir, journal); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *TxFileSystem) Open(ctx context.Context, path string, flag int, options interface{}) (Blob, error) {
+	fs, err := t.route(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Open(ctx, path, flag, options)
+}
+
+func (t *TxFileSystem) Delete(ctx context.Context, path string) error {
+	fs, err := t.route(ctx, path)
+	if err != nil {
+		return err
+	}
+	return fs.Delete(ctx, path)
+}
+
+func (t *TxFileSystem) ReadAttrs(ctx context.Context, path string, args interface{}) (Entry, error) {
+	fs, err := t.route(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return fs.ReadAttrs(ctx, path, args)
+}
+
+func (t *TxFileSystem) ReadForks(ctx context.Context, path string) ([]string, error) {
+	fs, err := t.route(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return fs.ReadForks(ctx, path)
+}
+
+func (t *TxFileSystem) WriteAttrs(ctx context.Context, path string, src interface{}) (Entry, error) {
+	fs, err := t.route(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return fs.WriteAttrs(ctx, path, src)
+}
+
+// ReadBucket hides the Shadow bucket.
+func (t *TxFileSystem) ReadBucket(ctx context.Context, path string, options interface{}) (ResultSet, error) {
+	fs, err := t.route(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if Path(path).Normalize() != t.shadow().Parent().Normalize() {
+		return fs.ReadBucket(ctx, path, options)
+	}
+	entries, err := readEntries(ctx, fs, path, options)
+	if err != nil {
+		return nil, err
+	}
+	res := &DefaultResultSet{Entries: entries[:0]}
+	for _, entry := range entries {
+		if entry.Id != t.shadow().Name() {
+			res.Entries = append(res.Entries, entry)
+		}
+	}
+	return res, nil
+}
+
+func (t *TxFileSystem) Invoke(ctx context.Context, endpoint string, args ...interface{}) (interface{}, error) {
+	return t.Delegate.Invoke(ctx, endpoint, args...)
+}
+
+func (t *TxFileSystem) MkBucket(ctx context.Context, path string, options interface{}) error {
+	fs, err := t.route(ctx, path)
+	if err != nil {
+		return err
+	}
+	return fs.MkBucket(ctx, path, options)
+}
+
+func (t *TxFileSystem) Rename(ctx context.Context, oldPath string, newPath string) error {
+	fs, err := t.route2(ctx, oldPath, newPath)
+	if err != nil {
+		return err
+	}
+	return fs.Rename(ctx, oldPath, newPath)
+}
+
+// SymLink stores the target as given. Within a transaction, the link is staged directly in the Delegate, because
+// the staging ChRoot would resolve the target into the shadow bucket, which is removed by Commit. So until the
+// Commit, the link resolves against the committed state.
+func (t *TxFileSystem) SymLink(ctx context.Context, oldPath string, newPath string) error {
+	fs, err := t.route2(ctx, oldPath, newPath)
+	if err != nil {
+		return err
+	}
+	tx, err := t.transaction(ctx)
+	if err != nil {
+		return err
+	}
+	if tx == nil {
+		return fs.SymLink(ctx, oldPath, newPath)
+	}
+	newP := Path(newPath).Normalize()
+	if err := tx.view.checkAbsent(ctx, newP); err != nil {
+		return err
+	}
+	if _, err := tx.view.prepareUpper(ctx, newP); err != nil {
+		return err
+	}
+	return t.Delegate.SymLink(ctx, oldPath, tx.dir.Child("data").Add(newP).String())
+}
+
+func (t *TxFileSystem) HardLink(ctx context.Context, oldPath string, newPath string) error {
+	fs, err := t.route2(ctx, oldPath, newPath)
+	if err != nil {
+		return err
+	}
+	return fs.HardLink(ctx, oldPath, newPath)
+}
+
+func (t *TxFileSystem) RefLink(ctx context.Context, oldPath string, newPath string) error {
+	fs, err := t.route2(ctx, oldPath, newPath)
+	if err != nil {
+		return err
+	}
+	return fs.RefLink(ctx, oldPath, newPath)
+}
+
+func (t *TxFileSystem) Close() error {
+	return t.Delegate.Close()
+}
+
+func (t *TxFileSystem) String() string {
+	return "TxFileSystem(" + t.Delegate.String() + ")"
+}
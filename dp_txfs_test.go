@@ -0,0 +1,228 @@
+package vfs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestTx(t *testing.T) (*TxFileSystem, *MemFS) {
+	mem := &MemFS{}
+	memWrite(t, mem, "/import/a.txt", "a")
+	memWrite(t, mem, "/import/old/b.txt", "b")
+	return &TxFileSystem{Delegate: mem}, mem
+}
+
+func TestTxFileSystem_Commit(t *testing.T) {
+	fs, mem := newTestTx(t)
+	ctx, err := fs.Begin(context.Background(), "/import", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memWriteCtx(t, ctx, fs, "/import/a.txt", "A")
+	memWriteCtx(t, ctx, fs, "/import/new/c.txt", "c")
+	if err := fs.Delete(ctx, "/import/old"); err != nil {
+		t.Fatal(err)
+	}
+	memWriteCtx(t, ctx, fs, "/import/d.txt", "d")
+	if err := fs.Rename(ctx, "/import/d.txt", "/import/e.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.MkBucket(ctx, "/import/empty", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// read committed: nothing is visible from the outside
+	if _, err := mem.ReadAttrs(context.Background(), "/import/e.txt", nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+	if _, err := fs.ReadAttrs(ctx, "/import/old", nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+	if _, err := fs.ReadAttrs(ctx, "/other", nil); !IsErr(err, EINVAL) {
+		t.Fatal("expected EINVAL but got", err)
+	}
+
+	if err := fs.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Commit(ctx); !IsErr(err, ETXINVALID) {
+		t.Fatal("expected ETXINVALID but got", err)
+	}
+	if names := overlayNames(t, mem, "/import"); len(names) != 4 || names[0] != "a.txt" || names[3] != "new" {
+		t.Fatal("unexpected listing", names)
+	}
+	if str := memRead(t, mem, "/import/a.txt"); str != "A" {
+		t.Fatal("expected A but got", str)
+	}
+	if str := memRead(t, mem, "/import/e.txt"); str != "d" {
+		t.Fatal("expected d but got", str)
+	}
+	if names := overlayNames(t, mem, "/.vfstx"); len(names) != 0 {
+		t.Fatal("expected empty shadow but got", names)
+	}
+	if names := overlayNames(t, fs, "/"); len(names) != 1 || names[0] != "import" {
+		t.Fatal("unexpected listing", names)
+	}
+}
+
+func TestTxFileSystem_Rollback(t *testing.T) {
+	fs, mem := newTestTx(t)
+	ctx, err := fs.Begin(context.Background(), "/", map[string]interface{}{TxOptionIsolation: IsolationNone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	memWriteCtx(t, ctx, fs, "/import/a.txt", "A")
+	if err := fs.Delete(ctx, "/import"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rollback(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if str := memRead(t, mem, "/import/a.txt"); str != "a" {
+		t.Fatal("expected a but got", str)
+	}
+	if names := overlayNames(t, mem, "/.vfstx"); len(names) != 0 {
+		t.Fatal("expected empty shadow but got", names)
+	}
+	if err := fs.Rollback(ctx); !IsErr(err, ETXINVALID) {
+		t.Fatal("expected ETXINVALID but got", err)
+	}
+}
+
+func TestTxFileSystem_SymLink(t *testing.T) {
+	fs, mem := newTestTx(t)
+	ctx, err := fs.Begin(context.Background(), "/import", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.SymLink(ctx, "/import/a.txt", "/import/links/a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.SymLink(ctx, "/import/a.txt", "/import/old/b.txt"); !IsErr(err, EEXIST) {
+		t.Fatal("expected EEXIST but got", err)
+	}
+	if err := fs.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if str := memRead(t, fs, "/import/links/a.txt"); str != "a" {
+		t.Fatal("expected a but got", str)
+	}
+	entries, err := readEntries(context.Background(), mem, "/import/links", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target := entries[0].Data.(*MemAttrs).Target; target != "/import/a.txt" {
+		t.Fatal("expected the target /import/a.txt but got", target)
+	}
+}
+
+func TestTxFileSystem_Begin(t *testing.T) {
+	fs, _ := newTestTx(t)
+	bg := context.Background()
+	if _, err := fs.Begin(bg, "/", map[string]interface{}{TxOptionIsolation: IsolationSerializable}); !IsErr(err, EINISOL) {
+		t.Fatal("expected EINISOL but got", err)
+	}
+	if _, err := fs.Begin(bg, "/.vfstx", nil); !IsErr(err, EINVAL) {
+		t.Fatal("expected EINVAL but got", err)
+	}
+	if err := fs.Commit(bg); !IsErr(err, ETXINVALID) {
+		t.Fatal("expected ETXINVALID but got", err)
+	}
+	ctx, err := fs.Begin(bg, "/", map[string]interface{}{TxOptionIsolation: "read-committed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Begin(ctx, "/", nil); !IsErr(err, EDEADLK) {
+		t.Fatal("expected EDEADLK but got", err)
+	}
+	if _, err := fs.ReadAttrs(bg, "/.vfstx", nil); !IsErr(err, EACCES) {
+		t.Fatal("expected EACCES but got", err)
+	}
+
+	// the context of a finished transaction can begin a new one
+	if err := fs.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ctx, err = fs.Begin(ctx, "/import", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memWriteCtx(t, ctx, fs, "/import/a.txt", "A")
+	if err := fs.Rollback(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if str := memRead(t, fs, "/import/a.txt"); str != "a" {
+		t.Fatal("expected a but got", str)
+	}
+}
+
+func TestTxFileSystem_Recover(t *testing.T) {
+	fs, mem := newTestTx(t)
+	bg := context.Background()
+
+	// a committed journal, which has not been applied
+	memWrite(t, mem, "/.vfstx/1/data/import/c.txt", "c")
+	memWrite(t, mem, "/.vfstx/1/journal.json", `{"id":"1","deletes":["/import/old"],"buckets":["/import"],"blobs":["/import/c.txt"]}`)
+	// an uncommitted transaction
+	memWrite(t, mem, "/.vfstx/2/data/import/a.txt", "A")
+
+	if err := fs.Recover(bg); err != nil {
+		t.Fatal(err)
+	}
+	if names := overlayNames(t, mem, "/import"); len(names) != 2 || names[0] != "a.txt" || names[1] != "c.txt" {
+		t.Fatal("unexpected listing", names)
+	}
+	if str := memRead(t, mem, "/import/a.txt"); str != "a" {
+		t.Fatal("expected a but got", str)
+	}
+	if names := overlayNames(t, mem, "/.vfstx"); len(names) != 0 {
+		t.Fatal("expected empty shadow but got", names)
+	}
+}
+
+func TestTxFileSystem_Local(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	local := &ChRoot{Prefix: Path(filepath.ToSlash(dir)), Delegate: LocalFileSystem}
+	memWrite(t, local, "/import/a.txt", "a")
+	fs := &TxFileSystem{Delegate: local}
+	ctx, err := fs.Begin(context.Background(), "/import", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	memWriteCtx(t, ctx, fs, "/import/a.txt", "A")
+	memWriteCtx(t, ctx, fs, "/import/sub/b.txt", "b")
+	if str := memRead(t, local, "/import/a.txt"); str != "a" {
+		t.Fatal("expected a but got", str)
+	}
+	if err := fs.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if str := memRead(t, local, "/import/a.txt"); str != "A" {
+		t.Fatal("expected A but got", str)
+	}
+	if str := memRead(t, local, "/import/sub/b.txt"); str != "b" {
+		t.Fatal("expected b but got", str)
+	}
+}
+
+func memWriteCtx(t *testing.T, ctx context.Context, fs FileSystem, path string, data string) {
+	t.Helper()
+	blob, err := fs.Open(ctx, path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blob.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := blob.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
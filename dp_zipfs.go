@@ -0,0 +1,379 @@
+package vfs
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ FileSystem = (*ZipFileSystem)(nil)
+
+// A ZipFileSystem provides the content of a zip archive. It works either in read mode or in write mode, which
+// creates a new archive.
+//
+// Details
+//
+//  * in read mode, the archive is indexed once and the FileSystem is thread safe. Missing parent buckets are
+//    synthesized, they have no Sys() payload.
+//  * ReadAttrs and ReadBucket return DefaultEntry instances with a *ZipAttrs payload
+//  * Open returns a Blob which supports ReadAt for stored (uncompressed) entries. Compressed entries can be read
+//    sequentially, Seek is emulated by reopening and skipping.
+//  * in write mode, Open(O_CREATE|O_WRONLY) appends a new entry and options may be a *zip.FileHeader to define the
+//    compression method, comment or modification time. Only the last opened entry can be written, so opening a
+//    new entry closes the former one. The archive is committed by Close.
+//  * all other modifications return EROFS in read mode and ENOSYS in write mode
+//  * listeners, forks, transactions and Invoke are not supported
+type ZipFileSystem struct {
+	lock   sync.Mutex // guards the index and the current blob in write mode
+	index  *archiveIndex
+	reader io.ReaderAt
+	writer *zip.Writer
+	blob   *zipWriteBlob
+	closer io.Closer
+	closed bool
+}
+
+// ZipAttrs is the payload returned by Entry.Sys() for all non-synthetic entries of a ZipFileSystem.
+type ZipAttrs struct {
+	// Header is the original header of the entry. In write mode, the CRC and the sizes are available after the
+	// entry has been finished.
+	Header *zip.FileHeader
+}
+
+// ModTime returns the modification time of the entry, so that ZipAttrs looks like an os.FileInfo.
+func (a *ZipAttrs) ModTime() time.Time {
+	return a.Header.Modified
+}
+
+// CRC32 returns the checksum of the uncompressed data.
+func (a *ZipAttrs) CRC32() uint32 {
+	return a.Header.CRC32
+}
+
+// Method returns the compression method, e.g. zip.Store or zip.Deflate.
+func (a *ZipAttrs) Method() uint16 {
+	return a.Header.Method
+}
+
+// CompressedSize returns the amount of bytes of the entry within the archive.
+func (a *ZipAttrs) CompressedSize() int64 {
+	return int64(a.Header.CompressedSize64)
+}
+
+// NewZipFileSystem indexes the archive and returns a read only ZipFileSystem. Returns EILSEQ if the archive
+// cannot be parsed.
+func NewZipFileSystem(r io.ReaderAt, size int64) (*ZipFileSystem, error) {
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, &DefaultError{Message: "invalid zip archive", Code: EILSEQ, CausedBy: err}
+	}
+	index := newArchiveIndex()
+	for _, file := range reader.File {
+		index.put(Path(file.Name).Normalize(), &DefaultEntry{
+			IsBucket: strings.HasSuffix(file.Name, "/"),
+			Length:   int64(file.UncompressedSize64),
+			Data:     &ZipAttrs{Header: &file.FileHeader},
+		}, file)
+	}
+	return &ZipFileSystem{index: index, reader: r}, nil
+}
+
+// OpenZipFileSystem opens the local zip file in read mode. The file is closed by Close.
+func OpenZipFileSystem(name string) (*ZipFileSystem, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	zfs, err := NewZipFileSystem(file, stat.Size())
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	zfs.closer = file
+	return zfs, nil
+}
+
+// CreateZipFileSystem returns a ZipFileSystem in write mode. Close writes the central directory but does not
+// close w.
+func CreateZipFileSystem(w io.Writer) *ZipFileSystem {
+	return &ZipFileSystem{index: newArchiveIndex(), writer: zip.NewWriter(w)}
+}
+
+// readOnly returns the error for unsupported modifications.
+func (z *ZipFileSystem) readOnly(op string) error {
+	if z.writer == nil {
+		return &DefaultError{Message: op + ": zip archive opened in read mode", Code: EROFS}
+	}
+	return NewENOSYS(op+" not supported", z)
+}
+
+func (z *ZipFileSystem) Connect(ctx context.Context, path string, options interface{}) (interface{}, error) {
+	return options, nil
+}
+
+func (z *ZipFileSystem) Disconnect(ctx context.Context, path string) error {
+	return nil
+}
+
+func (z *ZipFileSystem) FireEvent(ctx context.Context, path string, event interface{}) error {
+	return NewENOSYS("FireEvent not supported", z)
+}
+
+func (z *ZipFileSystem) AddListener(ctx context.Context, path string, listener ResourceListener) (handle int, err error) {
+	return -1, NewENOSYS("AddListener not supported", z)
+}
+
+func (z *ZipFileSystem) RemoveListener(ctx context.Context, handle int) error {
+	return NewENOSYS("RemoveListener not supported", z)
+}
+
+func (z *ZipFileSystem) Begin(ctx context.Context, path string, options interface{}) (context.Context, error) {
+	return nil, NewENOSYS("Begin transaction not supported", z)
+}
+
+func (z *ZipFileSystem) Commit(ctx context.Context) error {
+	return NewENOSYS("Commit transaction not supported", z)
+}
+
+func (z *ZipFileSystem) Rollback(ctx context.Context) error {
+	return NewENOSYS("Rollback transaction not supported", z)
+}
+
+// Open returns a read only Blob in read mode. In write mode, only O_CREATE in combination with O_WRONLY is allowed
+// and an existing entry results in EEXIST.
+func (z *ZipFileSystem) Open(ctx context.Context, path string, flag int, options interface{}) (Blob, error) {
+	p := Path(path).Normalize()
+	if z.writer != nil {
+		return z.create(p, flag, options)
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE) != 0 {
+		return nil, z.readOnly("Open")
+	}
+
+	node, err := z.index.get(p)
+	if err != nil {
+		return nil, err
+	}
+	if node.children != nil {
+		return nil, &DefaultError{Message: p.String(), Code: EISDIR, DetailsPayload: []string{p.String()}}
+	}
+	file := node.payload.(*zip.File)
+	blob := &archiveBlob{open: file.Open, size: int64(file.UncompressedSize64)}
+	if file.Method == zip.Store {
+		offset, err := file.DataOffset()
+		if err != nil {
+			return nil, &DefaultError{Message: p.String(), Code: EILSEQ, CausedBy: err, DetailsPayload: []string{p.String()}}
+		}
+		blob.section = io.NewSectionReader(z.reader, offset, int64(file.CompressedSize64))
+	}
+	return blob, nil
+}
+
+// create appends a new entry in write mode.
+func (z *ZipFileSystem) create(p Path, flag int, options interface{}) (Blob, error) {
+	if flag&os.O_CREATE == 0 || flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return nil, NewENOSYS("only O_CREATE|O_WRONLY is supported in write mode", z)
+	}
+	if p.NameCount() == 0 {
+		return nil, &DefaultError{Message: p.String(), Code: EISDIR, DetailsPayload: []string{p.String()}}
+	}
+
+	z.lock.Lock()
+	defer z.lock.Unlock()
+
+	if z.closed {
+		return nil, &DefaultError{Message: "zip archive already closed", Code: EBADF}
+	}
+	if _, err := z.index.get(p); err == nil {
+		return nil, &DefaultError{Message: p.String(), Code: EEXIST, DetailsPayload: []string{p.String()}}
+	}
+	if err := z.index.checkParents(p); err != nil {
+		return nil, err
+	}
+
+	header := &zip.FileHeader{Method: zip.Deflate}
+	if h, ok := options.(*zip.FileHeader); ok {
+		cpy := *h
+		header = &cpy
+	}
+	header.Name = strings.TrimPrefix(p.String(), "/")
+	if header.Modified.IsZero() {
+		header.Modified = time.Now()
+	}
+	w, err := z.writer.CreateHeader(header)
+	if err != nil {
+		return nil, &DefaultError{Message: p.String(), Code: EIO, CausedBy: err, DetailsPayload: []string{p.String()}}
+	}
+	entry := &DefaultEntry{Data: &ZipAttrs{Header: header}}
+	z.index.put(p, entry, nil)
+	z.blob = &zipWriteBlob{fs: z, w: w, entry: entry}
+	return z.blob, nil
+}
+
+func (z *ZipFileSystem) Delete(ctx context.Context, path string) error {
+	return z.readOnly("Delete")
+}
+
+func (z *ZipFileSystem) ReadAttrs(ctx context.Context, path string, args interface{}) (Entry, error) {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+
+	node, err := z.index.get(Path(path).Normalize())
+	if err != nil {
+		return nil, err
+	}
+	entry := *node.entry
+	return readEntryInto(&entry, args), nil
+}
+
+func (z *ZipFileSystem) ReadForks(ctx context.Context, path string) ([]string, error) {
+	return nil, NewENOSYS("ReadForks not supported", z)
+}
+
+func (z *ZipFileSystem) WriteAttrs(ctx context.Context, path string, src interface{}) (Entry, error) {
+	return nil, z.readOnly("WriteAttrs")
+}
+
+// ReadBucket returns all entries sorted by name within a single page.
+func (z *ZipFileSystem) ReadBucket(ctx context.Context, path string, options interface{}) (ResultSet, error) {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+
+	return z.index.list(Path(path).Normalize())
+}
+
+func (z *ZipFileSystem) Invoke(ctx context.Context, endpoint string, args ...interface{}) (interface{}, error) {
+	return nil, NewENOSYS("Invoke not supported", z)
+}
+
+// MkBucket appends a directory entry in write mode. Missing parents are not written, because they are implicit.
+func (z *ZipFileSystem) MkBucket(ctx context.Context, path string, options interface{}) error {
+	if z.writer == nil {
+		return z.readOnly("MkBucket")
+	}
+	p := Path(path).Normalize()
+
+	z.lock.Lock()
+	defer z.lock.Unlock()
+
+	if z.closed {
+		return &DefaultError{Message: "zip archive already closed", Code: EBADF}
+	}
+	if node, err := z.index.get(p); err == nil {
+		if node.children == nil {
+			return &DefaultError{Message: "not a bucket: " + p.String(), Code: ENOTDIR, DetailsPayload: []string{p.String()}}
+		}
+		return nil
+	}
+	if err := z.index.checkParents(p); err != nil {
+		return err
+	}
+
+	header := &zip.FileHeader{Name: strings.TrimPrefix(p.String(), "/") + "/", Method: zip.Store, Modified: time.Now()}
+	if _, err := z.writer.CreateHeader(header); err != nil {
+		return &DefaultError{Message: p.String(), Code: EIO, CausedBy: err, DetailsPayload: []string{p.String()}}
+	}
+	// the former entry has been finished by the zip writer
+	z.blob = nil
+	z.index.put(p, &DefaultEntry{IsBucket: true, Data: &ZipAttrs{Header: header}}, nil)
+	return nil
+}
+
+func (z *ZipFileSystem) Rename(ctx context.Context, oldPath string, newPath string) error {
+	return z.readOnly("Rename")
+}
+
+func (z *ZipFileSystem) SymLink(ctx context.Context, oldPath string, newPath string) error {
+	return z.readOnly("SymLink")
+}
+
+func (z *ZipFileSystem) HardLink(ctx context.Context, oldPath string, newPath string) error {
+	return z.readOnly("HardLink")
+}
+
+func (z *ZipFileSystem) RefLink(ctx context.Context, oldPath string, newPath string) error {
+	return z.readOnly("RefLink")
+}
+
+// Close writes the central directory in write mode and closes the file opened by OpenZipFileSystem. Subsequent
+// calls have no effect.
+func (z *ZipFileSystem) Close() error {
+	z.lock.Lock()
+	defer z.lock.Unlock()
+
+	if z.closed {
+		return nil
+	}
+	z.closed = true
+	var err error
+	if z.writer != nil {
+		z.blob = nil
+		err = z.writer.Close()
+	}
+	if z.closer != nil {
+		if e := z.closer.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (z *ZipFileSystem) String() string {
+	return "ZipFileSystem"
+}
+
+//==
+
+// A zipWriteBlob writes the current entry of an archive in write mode.
+type zipWriteBlob struct {
+	fs    *ZipFileSystem
+	w     io.Writer
+	entry *DefaultEntry
+}
+
+func (b *zipWriteBlob) ReadAt(p []byte, off int64) (n int, err error) {
+	return 0, &DefaultError{Message: "blob opened write only", Code: EBADF}
+}
+
+func (b *zipWriteBlob) Read(p []byte) (n int, err error) {
+	return 0, &DefaultError{Message: "blob opened write only", Code: EBADF}
+}
+
+func (b *zipWriteBlob) WriteAt(p []byte, off int64) (n int, err error) {
+	return 0, NewENOSYS("zip entries can only be written sequentially", b)
+}
+
+// Write fails with EBADF if another entry has been opened in the meantime.
+func (b *zipWriteBlob) Write(p []byte) (n int, err error) {
+	b.fs.lock.Lock()
+	defer b.fs.lock.Unlock()
+	if b.fs.blob != b {
+		return 0, &DefaultError{Message: "zip entry already finished", Code: EBADF}
+	}
+	n, err = b.w.Write(p)
+	b.entry.Length += int64(n)
+	return n, err
+}
+
+func (b *zipWriteBlob) Seek(offset int64, whence int) (int64, error) {
+	return 0, NewENOSYS("zip entries can only be written sequentially", b)
+}
+
+// Close finishes the entry, however the data is flushed when the next entry is opened or the archive is closed.
+func (b *zipWriteBlob) Close() error {
+	b.fs.lock.Lock()
+	defer b.fs.lock.Unlock()
+	if b.fs.blob == b {
+		b.fs.blob = nil
+	}
+	return nil
+}
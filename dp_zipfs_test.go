@@ -0,0 +1,166 @@
+package vfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"hash/crc32"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func createTestZip(t *testing.T) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	zfs := CreateZipFileSystem(buf)
+	ctx := context.Background()
+	modTime := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	memWrite(t, zfs, "/assets/readme.txt", "hello zip")
+	blob, err := zfs.Open(ctx, "/assets/img/logo.raw", os.O_CREATE|os.O_WRONLY, &zip.FileHeader{Method: zip.Store, Modified: modTime})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blob.Write([]byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	if err := zfs.MkBucket(ctx, "/assets/empty", nil); err != nil {
+		t.Fatal(err)
+	}
+	// the former entry has been finished by MkBucket
+	if _, err := blob.Write([]byte("x")); !IsErr(err, EBADF) {
+		t.Fatal("expected EBADF but got", err)
+	}
+	if _, err := zfs.Open(ctx, "/assets/readme.txt", os.O_CREATE|os.O_WRONLY, nil); !IsErr(err, EEXIST) {
+		t.Fatal("expected EEXIST but got", err)
+	}
+	if _, err := zfs.Open(ctx, "/assets/readme.txt/x", os.O_CREATE|os.O_WRONLY, nil); !IsErr(err, ENOTDIR) {
+		t.Fatal("expected ENOTDIR but got", err)
+	}
+	if err := zfs.Delete(ctx, "/assets"); !IsErr(err, ENOSYS) {
+		t.Fatal("expected ENOSYS but got", err)
+	}
+
+	entry, err := zfs.ReadAttrs(ctx, "/assets/img/logo.raw", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := entry.(*DefaultEntry).Length; size != 10 {
+		t.Fatal("expected 10 but got", size)
+	}
+
+	if err := zfs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zfs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestZipFileSystem(t *testing.T) {
+	data := createTestZip(t)
+	zfs, err := NewZipFileSystem(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	res, err := zfs.ReadBucket(ctx, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Len() != 1 || res.ReadAttrs(0, nil).Name() != "assets" || !res.ReadAttrs(0, nil).IsDir() {
+		t.Fatal("unexpected result", res.Sys())
+	}
+	res, err = zfs.ReadBucket(ctx, "/assets", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Len() != 3 || res.ReadAttrs(0, nil).Name() != "empty" || res.ReadAttrs(2, nil).Name() != "readme.txt" {
+		t.Fatal("unexpected result", res.Sys())
+	}
+	if _, err := zfs.ReadBucket(ctx, "/assets/readme.txt", nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+
+	entry, err := zfs.ReadAttrs(ctx, "/assets/img/logo.raw", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attrs := entry.Sys().(*ZipAttrs)
+	size := entry.(*DefaultEntry).Length
+	if size != 10 || attrs.Method() != zip.Store || attrs.CRC32() != crc32.ChecksumIEEE([]byte("0123456789")) {
+		t.Fatal("unexpected attributes", size, attrs.Method(), attrs.CRC32())
+	}
+	if !attrs.ModTime().Equal(time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Fatal("unexpected mod time", attrs.ModTime())
+	}
+
+	// stored entries support random access
+	blob, err := zfs.Open(ctx, "/assets/img/logo.raw", os.O_RDONLY, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 3)
+	if _, err := blob.ReadAt(buf, 4); err != nil || string(buf) != "456" {
+		t.Fatal("expected 456 but got", string(buf), err)
+	}
+	if _, err := blob.Write(buf); !IsErr(err, EROFS) {
+		t.Fatal("expected EROFS but got", err)
+	}
+	silentClose(blob)
+
+	// compressed entries are streamed
+	blob, err = zfs.Open(ctx, "/assets/readme.txt", os.O_RDONLY, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := blob.ReadAt(buf, 0); !IsErr(err, ENOSYS) {
+		t.Fatal("expected ENOSYS but got", err)
+	}
+	if _, err := blob.Seek(6, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(blob, buf); err != nil || string(buf) != "zip" {
+		t.Fatal("expected zip but got", string(buf), err)
+	}
+	if _, err := blob.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(blob, buf); err != nil || string(buf) != "hel" {
+		t.Fatal("expected hel but got", string(buf), err)
+	}
+	silentClose(blob)
+
+	if _, err := zfs.Open(ctx, "/assets/new.txt", os.O_CREATE|os.O_WRONLY, nil); !IsErr(err, EROFS) {
+		t.Fatal("expected EROFS but got", err)
+	}
+	if err := zfs.Delete(ctx, "/assets"); !IsErr(err, EROFS) {
+		t.Fatal("expected EROFS but got", err)
+	}
+	if _, err := zfs.Open(ctx, "/assets", os.O_RDONLY, nil); !IsErr(err, EISDIR) {
+		t.Fatal("expected EISDIR but got", err)
+	}
+}
+
+func TestZipFileSystem_Mount(t *testing.T) {
+	data := createTestZip(t)
+	zfs, err := NewZipFileSystem(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfs := &MountableFileSystem{}
+	mfs.Mount("/assets", &ChRoot{Prefix: "/assets", Delegate: zfs})
+	if str := memRead(t, mfs, "/assets/readme.txt"); str != "hello zip" {
+		t.Fatal("expected hello zip but got", str)
+	}
+}
+
+func TestZipFileSystem_Invalid(t *testing.T) {
+	if _, err := NewZipFileSystem(bytes.NewReader([]byte("no zip")), 6); !IsErr(err, EILSEQ) {
+		t.Fatal("expected EILSEQ but got", err)
+	}
+}
@@ -1,8 +1,14 @@
 package vfs
 
 import (
+	"context"
+	"errors"
+	"io"
+	"os"
 	"reflect"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -502,10 +508,71 @@ const (
 )
 
 var statusText = map[int]string{
-	EOK: "OK",
+	EOK:             "OK",
+	EPERM:           "operation not permitted",
+	ENOENT:          "no such file or directory",
+	ESRCH:           "no such process",
+	EINTR:           "interrupted",
+	EIO:             "input/output error",
+	ENXIO:           "no such device or address",
+	EBADF:           "bad file descriptor",
+	ECHILD:          "no child processes",
+	EAGAIN:          "resource temporarily unavailable",
+	ENOMEM:          "out of memory",
+	EACCES:          "permission denied",
+	ENOTBLK:         "block device required",
+	EBUSY:           "device or resource busy",
+	EEXIST:          "file exists",
+	EXDEV:           "cross-device link",
+	ENODEV:          "no such device",
+	ENOTDIR:         "not a directory",
+	EISDIR:          "is a directory",
+	EINVAL:          "invalid argument",
+	ENFILE:          "too many open files in system",
+	EMFILE:          "too many open files",
+	EFBIG:           "file too large",
+	ENOSPC:          "no space left on device",
+	EROFS:           "read-only file system",
+	EDEADLK:         "resource deadlock would occur",
+	ENAMETOOLONG:    "file name too long",
+	ENOLCK:          "no locks available",
+	ENOSYS:          "function not implemented",
+	ENOTEMPTY:       "directory not empty",
+	ELOOP:           "too many levels of symbolic links",
+	ENODATA:         "no data available",
+	EREMOTE:         "object is remote",
+	ECOMM:           "communication error on send",
+	EPROTO:          "protocol error",
+	EOVERFLOW:       "value too large for defined data type",
+	ENOTUNIQ:        "name not unique on network",
+	EILSEQ:          "illegal byte sequence",
+	ENOPROTOOPT:     "protocol not available",
+	EPROTONOSUPPORT: "protocol not supported",
+	EADDRINUSE:      "address already in use",
+	EADDRNOTAVAIL:   "cannot assign requested address",
+	ENETDOWN:        "network is down",
+	ENETUNREACH:     "network is unreachable",
+	ENETRESET:       "network dropped connection on reset",
+	ECONNABORTED:    "software caused connection abort",
+	ECONNRESET:      "connection reset by peer",
+	ETIMEDOUT:       "connection timed out",
+	ECONNREFUSED:    "connection refused",
+	EHOSTDOWN:       "host is down",
+	EHOSTUNREACH:    "no route to host",
+	EALREADY:        "operation already in progress",
+	EREMOTEIO:       "remote i/o error",
+	EDQUOT:          "disk quota exceeded",
+	EOF:             "end of file",
+	ETXINVALID:      "invalid transaction",
+	EITINVALID:      "invalid iterator",
+	EINISOL:         "invalid isolation level",
+	EAEXP:           "account expired",
+	ENOMP:           "mount point not found",
+	EUNATTR:         "unsupported attributes",
+	EUNKOWN:         "unknown error",
 }
 
-// StatusText returns th
+// StatusText returns the text for the status code or Status-<code>, if the code is not defined.
 func StatusText(code int) string {
 	val, ok := statusText[code]
 	if !ok {
@@ -534,25 +601,262 @@ type LimitDetails interface {
 	Max() int64
 }
 
-type wrapper interface {
-	Unwrap() error
+// DefaultUnavailableDetails implements the UnavailableDetails interface
+var _ UnavailableDetails = (*DefaultUnavailableDetails)(nil)
+
+type DefaultUnavailableDetails struct {
+	Message string
+	Retry   time.Duration
+}
+
+func (d *DefaultUnavailableDetails) UserMessage() string {
+	return d.Message
+}
+
+func (d *DefaultUnavailableDetails) RetryAfter() time.Duration {
+	return d.Retry
+}
+
+// DefaultLimitDetails implements the LimitDetails interface
+var _ LimitDetails = (*DefaultLimitDetails)(nil)
+
+type DefaultLimitDetails struct {
+	Message   string
+	MinValue  int64
+	UsedValue int64
+	MaxValue  int64
+}
+
+func (d *DefaultLimitDetails) UserMessage() string {
+	return d.Message
+}
+
+func (d *DefaultLimitDetails) Min() int64 {
+	return d.MinValue
+}
+
+func (d *DefaultLimitDetails) Used() int64 {
+	return d.UsedValue
+}
+
+func (d *DefaultLimitDetails) Max() int64 {
+	return d.MaxValue
+}
+
+// PathDetails returns the affected paths of the first Error in the chain, which provides a []string as details.
+func PathDetails(err error) []string {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if e, ok := err.(Error); ok {
+			if paths, ok := e.Details().([]string); ok {
+				return paths
+			}
+		}
+	}
+	return nil
+}
+
+// rewritePaths returns a copy of the error chain, whose affected paths have been rewritten. Details:
+//  * the paths are taken from the []string details of all Errors in the chain
+//  * a *DefaultError is copied with rewritten details and a message, which contains the rewritten paths
+//  * an *os.PathError and *os.LinkError is copied with the rewritten paths
+//  * any other wrapper is replaced by an error with the rewritten message, which still unwraps to the rewritten
+//    chain
+//  * the error is returned as is, if nothing has been rewritten
+func rewritePaths(err error, rewrite func(path string) string) error {
+	var paths []string
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		if vfsErr, ok := e.(Error); ok {
+			if details, ok := vfsErr.Details().([]string); ok {
+				paths = append(paths, details...)
+			}
+		}
+	}
+
+	// the longest paths first, so that a path is not replaced by its parent
+	sort.Slice(paths, func(i, j int) bool {
+		return len(paths[i]) > len(paths[j])
+	})
+	var replacements []string
+	for _, path := range paths {
+		if rewritten := rewrite(path); path != "/" && rewritten != path {
+			replacements = append(replacements, path, rewritten)
+		}
+	}
+	if len(replacements) == 0 {
+		return err
+	}
+	return rewriteChain(err, strings.NewReplacer(replacements...), rewrite)
+}
+
+// rewriteChain is the recursive part of rewritePaths
+func rewriteChain(err error, replacer *strings.Replacer, rewrite func(path string) string) error {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case *DefaultError:
+		cpy := *e
+		cpy.Message = replacer.Replace(e.Message)
+		if paths, ok := e.DetailsPayload.([]string); ok {
+			rewritten := make([]string, len(paths))
+			for i, path := range paths {
+				rewritten[i] = rewrite(path)
+			}
+			cpy.DetailsPayload = rewritten
+		}
+		cpy.CausedBy = rewriteChain(e.CausedBy, replacer, rewrite)
+		return &cpy
+	case *os.PathError:
+		return &os.PathError{Op: e.Op, Path: replacer.Replace(e.Path), Err: rewriteChain(e.Err, replacer, rewrite)}
+	case *os.LinkError:
+		return &os.LinkError{Op: e.Op, Old: replacer.Replace(e.Old), New: replacer.Replace(e.New), Err: rewriteChain(e.Err, replacer, rewrite)}
+	}
+
+	cause := errors.Unwrap(err)
+	rewrittenCause := rewriteChain(cause, replacer, rewrite)
+	msg := replacer.Replace(err.Error())
+	if msg == err.Error() && rewrittenCause == cause {
+		return err
+	}
+	return &rewrittenError{msg: msg, cause: rewrittenCause}
+}
+
+// rewrittenError replaces a foreign wrapper in a chain, whose paths have been rewritten
+type rewrittenError struct {
+	msg   string
+	cause error
+}
+
+func (e *rewrittenError) Error() string {
+	return e.msg
+}
+
+func (e *rewrittenError) Unwrap() error {
+	return e.cause
+}
+
+// Retry invokes the closure until it succeeds, the attempts are exhausted or the error is not temporary. An
+// error is temporary, if it is an EAGAIN or provides UnavailableDetails. Details:
+//  * the time to wait is taken from UnavailableDetails.RetryAfter(), otherwise the closure is retried immediately
+//  * a done context interrupts the waiting with EINTR
+//  * the last error is returned, if the attempts are exhausted
+func Retry(ctx context.Context, attempts int, closure func(ctx context.Context) error) error {
+	for i := 1; ; i++ {
+		err := closure(ctx)
+		if err == nil || i >= attempts {
+			return err
+		}
+		delay, temporary := retryAfter(err)
+		if !temporary {
+			return err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return &DefaultError{Message: "retry cancelled: " + ctx.Err().Error(), Code: EINTR, CausedBy: err}
+		case <-timer.C:
+		}
+	}
+}
+
+// retryAfter inspects the chain for UnavailableDetails or an EAGAIN
+func retryAfter(err error) (time.Duration, bool) {
+	temporary := false
+	for ; err != nil; err = errors.Unwrap(err) {
+		if e, ok := err.(Error); ok {
+			if details, ok := e.Details().(UnavailableDetails); ok {
+				return details.RetryAfter(), true
+			}
+			temporary = temporary || e.StatusCode() == EAGAIN
+		}
+	}
+	return 0, temporary
 }
 
 // IsErr inspects the wrapped hierarchy for a specific statusCode
 func IsErr(err error, statusCode int) bool {
-	if e, ok := err.(Error); ok {
-		if e.StatusCode() == statusCode {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if e, ok := err.(Error); ok && e.StatusCode() == statusCode {
 			return true
 		}
+	}
+	return false
+}
 
+// sentinelCodes returns the status codes, which are equal to the errors of the standard library. See
+// DefaultError.Is. The target is compared by ==, because a map lookup panics for uncomparable errors.
+func sentinelCodes(target error) []int {
+	switch target {
+	case os.ErrNotExist:
+		return []int{ENOENT}
+	case os.ErrExist:
+		return []int{EEXIST}
+	case os.ErrPermission:
+		return []int{EACCES, EPERM, EROFS}
+	case os.ErrClosed:
+		return []int{EBADF}
+	case os.ErrDeadlineExceeded, context.DeadlineExceeded:
+		return []int{ETIMEDOUT}
+	case context.Canceled:
+		return []int{EINTR}
+	case io.EOF:
+		return []int{EOF}
 	}
+	return nil
+}
 
-	if e, ok := err.(wrapper); ok {
-		// recursive search
-		return IsErr(e.Unwrap(), statusCode)
+// FromOSError translates errors of the os package, like *os.PathError, *os.LinkError or a syscall.Errno, into a
+// *DefaultError with the matching status code. The original error is the cause and the affected paths are the
+// details. Nil, io.EOF and errors which already contain an Error are returned as is. An unknown syscall.Errno
+// is returned as EUNKOWN and anything else as EIO. On plan9, only the errors of the os package are translated.
+// A denied operation, i.e. os.ErrPermission including syscall.EACCES and syscall.EPERM, is always an EACCES,
+// because the EPERM status code requests a re-authentication, like an HTTP 401.
+func FromOSError(err error) error {
+	var vfsErr Error
+	if err == nil || err == io.EOF || errors.As(err, &vfsErr) {
+		return err
+	}
+	// the message of a plain path or link error is without the errno text, which is already the status text
+	msg := err.Error()
+	var details interface{}
+	var pathErr *os.PathError
+	var linkErr *os.LinkError
+	switch {
+	case errors.As(err, &pathErr):
+		details = []string{pathErr.Path}
+		if err == error(pathErr) {
+			msg = pathErr.Op + " " + pathErr.Path
+		}
+	case errors.As(err, &linkErr):
+		details = []string{linkErr.Old, linkErr.New}
+		if err == error(linkErr) {
+			msg = linkErr.Op + " " + linkErr.Old + " " + linkErr.New
+		}
 	}
 
-	return false
+	code := EIO
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		code = ENOENT
+	case errors.Is(err, os.ErrExist):
+		code = EEXIST
+	case errors.Is(err, os.ErrPermission):
+		code = EACCES
+	case errors.Is(err, os.ErrClosed):
+		code = EBADF
+	case errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, context.DeadlineExceeded):
+		code = ETIMEDOUT
+	case errors.Is(err, context.Canceled):
+		code = EINTR
+	default:
+		if c, errno, ok := errnoCode(err); ok {
+			code = c
+			if c == EUNKOWN {
+				details = errno
+			}
+		}
+	}
+	return &DefaultError{Message: msg, Code: code, CausedBy: err, DetailsPayload: details}
 }
 
 // DefaultError implements the Error interface
@@ -576,6 +880,22 @@ func (e *DefaultError) Unwrap() error {
 	return e.CausedBy
 }
 
+// Is supports errors.Is for the related errors of the standard library, e.g. os.ErrNotExist (which is
+// fs.ErrNotExist) for ENOENT, os.ErrExist for EEXIST, os.ErrPermission for EACCES, EPERM and EROFS,
+// context.Canceled for EINTR and context.DeadlineExceeded for ETIMEDOUT. Another *DefaultError is equal, if the
+// codes are equal.
+func (e *DefaultError) Is(target error) bool {
+	if t, ok := target.(*DefaultError); ok {
+		return t.Code == e.Code
+	}
+	for _, code := range sentinelCodes(target) {
+		if code == e.Code {
+			return true
+		}
+	}
+	return false
+}
+
 func (e *DefaultError) StatusCode() int {
 	return e.Code
 }
@@ -601,5 +921,40 @@ func (b errBuilder) UnsupportedAttributes(msg string, what interface{}) *Default
 	return &DefaultError{msg + ": " + reflect.TypeOf(what).String(), EUNATTR, nil, what}
 }
 
+// Path creates an error of the given code, whose details contain the affected paths, like ENOENT or EEXIST
+func (b errBuilder) Path(code int, msg string, paths ...string) *DefaultError {
+	return &DefaultError{msg, code, nil, paths}
+}
+
+// NotFound creates an ENOENT with the affected paths
+func (b errBuilder) NotFound(msg string, paths ...string) *DefaultError {
+	return b.Path(ENOENT, msg, paths...)
+}
+
+// Exists creates an EEXIST with the affected paths
+func (b errBuilder) Exists(msg string, paths ...string) *DefaultError {
+	return b.Path(EEXIST, msg, paths...)
+}
+
+// NotDir creates an ENOTDIR with the affected paths
+func (b errBuilder) NotDir(msg string, paths ...string) *DefaultError {
+	return b.Path(ENOTDIR, msg, paths...)
+}
+
+// IsDir creates an EISDIR with the affected paths
+func (b errBuilder) IsDir(msg string, paths ...string) *DefaultError {
+	return b.Path(EISDIR, msg, paths...)
+}
+
+// Unavailable creates an EAGAIN with UnavailableDetails
+func (b errBuilder) Unavailable(msg string, userMsg string, retryAfter time.Duration) *DefaultError {
+	return &DefaultError{msg, EAGAIN, nil, &DefaultUnavailableDetails{Message: userMsg, Retry: retryAfter}}
+}
+
+// Limit creates an error of the given code with LimitDetails, like EFBIG, ENOSPC or EDQUOT
+func (b errBuilder) Limit(code int, msg string, userMsg string, min int64, used int64, max int64) *DefaultError {
+	return &DefaultError{msg, code, nil, &DefaultLimitDetails{Message: userMsg, MinValue: min, UsedValue: used, MaxValue: max}}
+}
+
 //
 var eof = &DefaultError{Code: EOF}
@@ -0,0 +1,55 @@
+//go:build !plan9
+// +build !plan9
+
+package vfs
+
+import (
+	"errors"
+	"syscall"
+)
+
+// errnoCodes translates the system errors, which are not covered by the sentinels. EPERM is an EACCES, because
+// the EPERM status code requests a re-authentication.
+var errnoCodes = map[syscall.Errno]int{
+	syscall.EPERM:        EACCES,
+	syscall.ENOENT:       ENOENT,
+	syscall.EINTR:        EINTR,
+	syscall.EIO:          EIO,
+	syscall.ENXIO:        ENXIO,
+	syscall.EBADF:        EBADF,
+	syscall.EAGAIN:       EAGAIN,
+	syscall.ENOMEM:       ENOMEM,
+	syscall.EACCES:       EACCES,
+	syscall.EBUSY:        EBUSY,
+	syscall.EEXIST:       EEXIST,
+	syscall.EXDEV:        EXDEV,
+	syscall.ENODEV:       ENODEV,
+	syscall.ENOTDIR:      ENOTDIR,
+	syscall.EISDIR:       EISDIR,
+	syscall.EINVAL:       EINVAL,
+	syscall.ENFILE:       ENFILE,
+	syscall.EMFILE:       EMFILE,
+	syscall.EFBIG:        EFBIG,
+	syscall.ENOSPC:       ENOSPC,
+	syscall.EROFS:        EROFS,
+	syscall.EDEADLK:      EDEADLK,
+	syscall.ENAMETOOLONG: ENAMETOOLONG,
+	syscall.ENOLCK:       ENOLCK,
+	syscall.ENOSYS:       ENOSYS,
+	syscall.ENOTEMPTY:    ENOTEMPTY,
+	syscall.ELOOP:        ELOOP,
+	syscall.ETIMEDOUT:    ETIMEDOUT,
+	syscall.EDQUOT:       EDQUOT,
+}
+
+// errnoCode returns the status code of a syscall.Errno in the chain. An unknown syscall.Errno is an EUNKOWN.
+func errnoCode(err error) (code int, errno int, ok bool) {
+	var e syscall.Errno
+	if !errors.As(err, &e) {
+		return 0, 0, false
+	}
+	if c, ok := errnoCodes[e]; ok {
+		return c, int(e), true
+	}
+	return EUNKOWN, int(e), true
+}
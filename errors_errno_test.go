@@ -0,0 +1,23 @@
+//go:build !plan9
+// +build !plan9
+
+package vfs
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestFromOSError_Errno(t *testing.T) {
+	if code, _, _ := errnoCode(syscall.EPERM); code != EACCES {
+		t.Fatal("expected EACCES but got", code)
+	}
+	err := FromOSError(&os.PathError{Op: "chown", Path: "/a.txt", Err: syscall.EPERM})
+	if !IsErr(err, EACCES) || IsErr(err, EPERM) {
+		t.Fatal("expected EACCES but got", err)
+	}
+	if err := FromOSError(syscall.ENOSPC); !IsErr(err, ENOSPC) {
+		t.Fatal("expected ENOSPC but got", err)
+	}
+}
@@ -0,0 +1,6 @@
+package vfs
+
+// errnoCode is not supported, because plan9 has no syscall.Errno but uses plain error strings.
+func errnoCode(err error) (code int, errno int, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,209 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStatusText(t *testing.T) {
+	for _, code := range []int{EOK, EPERM, ENOENT, EINTR, EIO, EEXIST, ENOTEMPTY, EROFS, ETIMEDOUT, EOF, EUNKOWN} {
+		if text := StatusText(code); text == "Status-"+fmt.Sprint(code) {
+			t.Fatal("expected a text for", code, "but got", text)
+		}
+	}
+	err := &DefaultError{Message: "open /a.txt", Code: ENOENT}
+	if err.Error() != "open /a.txt: no such file or directory" {
+		t.Fatal("unexpected message", err.Error())
+	}
+	if StatusText(EDQUOT) != "disk quota exceeded" || StatusText(1000) != "Status-1000" {
+		t.Fatal("unexpected status texts", StatusText(EDQUOT), StatusText(1000))
+	}
+}
+
+func TestDefaultError_Is(t *testing.T) {
+	wrapped := fmt.Errorf("wrapped: %w", &DefaultError{Code: ENOENT})
+	if !errors.Is(wrapped, os.ErrNotExist) || !errors.Is(wrapped, fs.ErrNotExist) || errors.Is(wrapped, fs.ErrExist) {
+		t.Fatal("expected fs.ErrNotExist")
+	}
+	if !errors.Is(wrapped, &DefaultError{Code: ENOENT}) || !IsErr(wrapped, ENOENT) {
+		t.Fatal("expected ENOENT")
+	}
+	if !errors.Is(&DefaultError{Code: EEXIST}, fs.ErrExist) || !errors.Is(&DefaultError{Code: EROFS}, fs.ErrPermission) {
+		t.Fatal("expected fs.ErrExist and fs.ErrPermission")
+	}
+	if !errors.Is(&DefaultError{Code: EINTR}, context.Canceled) || !errors.Is(&DefaultError{Code: ETIMEDOUT}, context.DeadlineExceeded) {
+		t.Fatal("expected context errors")
+	}
+	if errors.Is(&DefaultError{Code: ENOENT}, multiErr{os.ErrNotExist}) {
+		t.Fatal("expected an uncomparable target to be unequal")
+	}
+	var vfsErr Error
+	if !errors.As(wrapped, &vfsErr) || vfsErr.StatusCode() != ENOENT {
+		t.Fatal("expected an Error but got", vfsErr)
+	}
+}
+
+// multiErr is an uncomparable error
+type multiErr []error
+
+func (m multiErr) Error() string {
+	return fmt.Sprint([]error(m))
+}
+
+func TestFromOSError(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing")
+	_, err := os.Stat(missing)
+	err = FromOSError(err)
+	if !IsErr(err, ENOENT) || !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+	if err.Error() != "stat "+missing+": no such file or directory" {
+		t.Fatal("unexpected message", err.Error())
+	}
+	if details := err.(Error).Details().([]string); details[0] != missing {
+		t.Fatal("expected", missing, "but got", details)
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "sub"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := FromOSError(os.Mkdir(filepath.Join(dir, "sub"), os.ModePerm)); !IsErr(err, EEXIST) {
+		t.Fatal("expected EEXIST but got", err)
+	}
+	if FromOSError(nil) != nil {
+		t.Fatal("expected nil")
+	}
+
+	local := &ChRoot{Prefix: Path(filepath.ToSlash(dir)), Delegate: LocalFileSystem}
+	if _, err := local.ReadAttrs(context.Background(), "/missing", nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+	if _, err := local.Open(context.Background(), "/missing", os.O_RDONLY, nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+}
+
+func TestPathDetails(t *testing.T) {
+	ctx := context.Background()
+	if paths := PathDetails(fmt.Errorf("wrapped: %w", NewErr().NotFound("missing", "/a", "/b"))); len(paths) != 2 || paths[1] != "/b" {
+		t.Fatal("expected [/a /b] but got", paths)
+	}
+
+	mem := &MemFS{}
+	chroot := &ChRoot{Prefix: "/home/user", Delegate: mem}
+	if _, err := chroot.ReadAttrs(ctx, "/missing.txt", nil); PathDetails(err)[0] != "/missing.txt" {
+		t.Fatal("expected /missing.txt but got", PathDetails(err))
+	}
+
+	local := &ChRoot{Prefix: Path(filepath.ToSlash(t.TempDir())), Delegate: LocalFileSystem}
+	if _, err := local.ReadAttrs(ctx, "/dir/missing.txt", nil); !IsErr(err, ENOENT) || PathDetails(err)[0] != "/dir/missing.txt" {
+		t.Fatal("expected /dir/missing.txt but got", err, PathDetails(err))
+	}
+
+	dir := filepath.ToSlash(t.TempDir())
+	local = &ChRoot{Prefix: Path(dir), Delegate: LocalFileSystem}
+	_, err := local.Open(ctx, "/dir/missing.txt", os.O_RDONLY, nil)
+	var pathErr *os.PathError
+	if strings.Contains(err.Error(), dir) || !errors.As(err, &pathErr) || pathErr.Path != "/dir/missing.txt" {
+		t.Fatal("expected no prefix in", err, pathErr)
+	}
+	if !errors.Is(err, os.ErrNotExist) || !errors.Is(err, syscall.ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+
+	// a wrapped error of the delegate
+	jail := &ChRoot{Prefix: "/home/user", Delegate: &AbstractFileSystem{
+		FOpen: func(ctx context.Context, path string, flag int, options interface{}) (Blob, error) {
+			return nil, fmt.Errorf("lookup %s: %w", path, NewErr().NotFound("missing "+path, path))
+		},
+	}}
+	_, err = jail.Open(ctx, "/a.txt", os.O_RDONLY, nil)
+	if err.Error() != "lookup /a.txt: missing /a.txt: no such file or directory" {
+		t.Fatal("expected no prefix but got", err)
+	}
+	if !IsErr(err, ENOENT) || PathDetails(err)[0] != "/a.txt" {
+		t.Fatal("expected ENOENT for /a.txt but got", err, PathDetails(err))
+	}
+
+	mfs := &MountableFileSystem{}
+	mfs.Mount("/mnt/mem", mem)
+	if _, err := mfs.Open(ctx, "/mnt/mem/missing.txt", os.O_RDONLY, nil); PathDetails(err)[0] != "/mnt/mem/missing.txt" {
+		t.Fatal("expected /mnt/mem/missing.txt but got", PathDetails(err))
+	}
+	if _, err := mfs.ReadAttrs(ctx, "/other", nil); !IsErr(err, ENOMP) || PathDetails(err)[0] != "/other" {
+		t.Fatal("expected ENOMP for /other but got", err)
+	}
+
+	built := (&Builder{}).Details("test", 1, 0, 0).
+		MatchBucket("/users").
+		OnList(func(ctx RoutingContext) ([]*DefaultEntry, error) {
+			return nil, nil
+		}).
+		Add().
+		Create()
+	if _, err := built.ReadBucket(ctx, "/groups", nil); !IsErr(err, ENOENT) || PathDetails(err)[0] != "/groups" {
+		t.Fatal("expected ENOENT for /groups but got", err)
+	}
+}
+
+func TestErrBuilder(t *testing.T) {
+	err := NewErr().Limit(EDQUOT, "upload", "your quota is exhausted", 0, 10, 10)
+	limit, ok := err.Details().(LimitDetails)
+	if !ok || limit.Used() != 10 || limit.Max() != 10 || limit.UserMessage() != "your quota is exhausted" {
+		t.Fatal("expected LimitDetails but got", err.Details())
+	}
+	if err := NewErr().Exists("exists", "/a"); !errors.Is(err, fs.ErrExist) {
+		t.Fatal("expected EEXIST but got", err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	ctx := context.Background()
+	calls := 0
+	err := Retry(ctx, 3, func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return NewErr().Unavailable("maintenance", "try again later", time.Millisecond)
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatal("expected 3 calls but got", calls, err)
+	}
+
+	calls = 0
+	err = Retry(ctx, 3, func(ctx context.Context) error {
+		calls++
+		return NewErr().NotFound("missing", "/a")
+	})
+	if !IsErr(err, ENOENT) || calls != 1 {
+		t.Fatal("expected a single call but got", calls, err)
+	}
+
+	calls = 0
+	err = Retry(ctx, 2, func(ctx context.Context) error {
+		calls++
+		return &DefaultError{Code: EAGAIN}
+	})
+	if !IsErr(err, EAGAIN) || calls != 2 {
+		t.Fatal("expected exhausted attempts but got", calls, err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	err = Retry(ctx, 3, func(ctx context.Context) error {
+		return NewErr().Unavailable("maintenance", "try again later", time.Hour)
+	})
+	if !IsErr(err, EINTR) || !IsErr(err, EAGAIN) {
+		t.Fatal("expected EINTR but got", err)
+	}
+}
@@ -1,5 +1,8 @@
 module github.com/worldiety/vfs
 
-go 1.12
+go 1.16
 
-require github.com/worldiety/xobj v0.0.0-20190426163538-01ff3dba5c17
+require (
+	github.com/worldiety/xobj v0.0.0-20190426163538-01ff3dba5c17
+	golang.org/x/net v0.0.0-20190620200207-3b0461eec859
+)
@@ -0,0 +1,307 @@
+package vfs
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var _ http.Handler = (*HTTPHandler)(nil)
+
+const (
+	// httpInvokePrefix marks the first path segment as an endpoint for FileSystem.Invoke
+	httpInvokePrefix = "/:"
+	// httpQueryAttrs requests the attributes of a blob or bucket instead of its content
+	httpQueryAttrs = "attrs"
+	// httpQueryPage requests a specific page of a bucket listing
+	httpQueryPage = "page"
+	// httpMethodMkCol is the WebDAV method to create a bucket
+	httpMethodMkCol = "MKCOL"
+	// httpMethodMove is the WebDAV method to rename a blob or bucket
+	httpMethodMove = "MOVE"
+	// httpHeaderDestination is the WebDAV header which contains the target of a MOVE
+	httpHeaderDestination = "Destination"
+	httpContentTypeJSON   = "application/json"
+)
+
+// An HTTPHandler serves a FileSystem using a simple REST protocol. The URL path is used as the path of the
+// FileSystem, so use http.StripPrefix to serve it from a sub path.
+//
+// Details
+//
+//  * GET on a blob returns its content. Range requests are supported, if the size is known and the Blob
+//    supports ReadAt.
+//  * GET on a bucket returns the entries, page, pages and total of the ResultSet as JSON. The query parameter
+//    page=n selects the nth page, a page after the last one returns EOF. With a page, the path is always listed,
+//    even if it is a blob.
+//  * GET with the query parameter attrs returns the name, bucket flag, size and modTime of an entry as JSON
+//  * PUT replaces a blob, DELETE deletes a blob or bucket, MKCOL creates a bucket and MOVE renames a blob or
+//    bucket to the path of the Destination header
+//  * POST to /:endpoint invokes the endpoint with the JSON array of the body as arguments and returns the
+//    result as JSON
+//  * errors are returned as JSON with code and message and a http status derived from the Error.StatusCode()
+type HTTPHandler struct {
+	// FileSystem is the served FileSystem
+	FileSystem FileSystem
+}
+
+// httpEntry is the JSON representation of an Entry
+type httpEntry struct {
+	Name     string     `json:"name"`
+	Bucket   bool       `json:"bucket"`
+	Size     int64      `json:"size"`
+	Modified *time.Time `json:"modTime,omitempty"`
+}
+
+// httpBucket is the JSON representation of a page of a ResultSet
+type httpBucket struct {
+	Entries []*httpEntry `json:"entries"`
+	Page    int          `json:"page"`
+	Pages   int64        `json:"pages"`
+	Total   int64        `json:"total"`
+}
+
+// httpError is the JSON representation of an Error
+type httpError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// httpStatus maps the vfs status codes to http status codes, everything else is an internal server error
+var httpStatus = map[int]int{
+	EPERM:        http.StatusUnauthorized,
+	ENOENT:       http.StatusNotFound,
+	EAGAIN:       http.StatusServiceUnavailable,
+	EACCES:       http.StatusForbidden,
+	EBUSY:        http.StatusLocked,
+	EEXIST:       http.StatusConflict,
+	ENOTDIR:      http.StatusConflict,
+	EISDIR:       http.StatusConflict,
+	EINVAL:       http.StatusBadRequest,
+	EFBIG:        http.StatusRequestEntityTooLarge,
+	ENOSPC:       http.StatusInsufficientStorage,
+	EROFS:        http.StatusMethodNotAllowed,
+	ENAMETOOLONG: http.StatusRequestURITooLong,
+	ENOLCK:       http.StatusLocked,
+	ENOSYS:       http.StatusNotImplemented,
+	ENOTEMPTY:    http.StatusConflict,
+	ETIMEDOUT:    http.StatusGatewayTimeout,
+	EREMOTEIO:    http.StatusBadGateway,
+	EDQUOT:       http.StatusInsufficientStorage,
+	EOF:          http.StatusRequestedRangeNotSatisfiable,
+	EUNATTR:      http.StatusUnprocessableEntity,
+}
+
+// newHTTPEntry converts the entry and picks the modification time from any Sys() which provides it, like
+// os.FileInfo.
+func newHTTPEntry(entry Entry) *httpEntry {
+	res := &httpEntry{Name: entry.Name(), Bucket: entry.IsDir(), Size: size(entry)}
+	if modTimer, ok := entry.Sys().(interface{ ModTime() time.Time }); ok {
+		modTime := modTimer.ModTime()
+		res.Modified = &modTime
+	}
+	return res
+}
+
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := Path(r.URL.Path).Normalize()
+	if strings.HasPrefix(r.URL.Path, httpInvokePrefix) {
+		if r.Method != http.MethodPost {
+			h.writeError(w, &DefaultError{Message: "invoke requires POST", Code: EINVAL})
+			return
+		}
+		h.invoke(w, r, strings.TrimPrefix(r.URL.Path, httpInvokePrefix))
+		return
+	}
+
+	var err error
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		err = h.get(w, r, p)
+	case http.MethodPut:
+		err = h.put(w, r, p)
+	case http.MethodDelete:
+		if err = h.FileSystem.Delete(r.Context(), p.String()); err == nil {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	case httpMethodMkCol:
+		if err = h.FileSystem.MkBucket(r.Context(), p.String(), nil); err == nil {
+			w.WriteHeader(http.StatusCreated)
+		}
+	case httpMethodMove:
+		err = h.move(w, r, p)
+	default:
+		err = &DefaultError{Message: "unsupported method " + r.Method, Code: ENOSYS}
+	}
+	if err != nil {
+		h.writeError(w, err)
+	}
+}
+
+func (h *HTTPHandler) get(w http.ResponseWriter, r *http.Request, p Path) error {
+	ctx := r.Context()
+	if _, ok := r.URL.Query()[httpQueryPage]; ok {
+		// an explicit listing leaves the error of a blob to the FileSystem
+		return h.list(w, r, p)
+	}
+	entry, err := h.FileSystem.ReadAttrs(ctx, p.String(), nil)
+	if err != nil {
+		return err
+	}
+	if _, ok := r.URL.Query()[httpQueryAttrs]; ok {
+		return h.writeJSON(w, http.StatusOK, newHTTPEntry(entry))
+	}
+	if entry.IsDir() {
+		return h.list(w, r, p)
+	}
+
+	blob, err := h.FileSystem.Open(ctx, p.String(), os.O_RDONLY, nil)
+	if err != nil {
+		return err
+	}
+	defer silentClose(blob)
+
+	contentType := mime.TypeByExtension(path.Ext(p.Name()))
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	info := newHTTPEntry(entry)
+
+	// only random access blobs can be served partially
+	if _, err := blob.ReadAt(nil, 0); err == nil && info.Size >= 0 {
+		http.ServeContent(w, r, p.Name(), info.ModTime(), io.NewSectionReader(blob, 0, info.Size))
+		return nil
+	}
+	if info.Size >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		// too late for an error response
+		_, _ = io.Copy(w, blob)
+	}
+	return nil
+}
+
+func (h *HTTPHandler) list(w http.ResponseWriter, r *http.Request, p Path) error {
+	page := 0
+	if str := r.URL.Query().Get(httpQueryPage); len(str) > 0 {
+		n, err := strconv.Atoi(str)
+		if err != nil || n < 0 {
+			return &DefaultError{Message: "invalid page " + str, Code: EINVAL}
+		}
+		page = n
+	}
+	res, err := h.FileSystem.ReadBucket(r.Context(), p.String(), nil)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < page; i++ {
+		if err := res.Next(r.Context()); err != nil {
+			return err
+		}
+	}
+	bucket := &httpBucket{Entries: make([]*httpEntry, res.Len()), Page: page, Pages: res.Pages(), Total: res.Total()}
+	for i := range bucket.Entries {
+		bucket.Entries[i] = newHTTPEntry(res.ReadAttrs(i, nil))
+	}
+	return h.writeJSON(w, http.StatusOK, bucket)
+}
+
+func (h *HTTPHandler) put(w http.ResponseWriter, r *http.Request, p Path) error {
+	blob, err := h.FileSystem.Open(r.Context(), p.String(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(blob, r.Body); err != nil {
+		silentClose(blob)
+		return err
+	}
+	if err := blob.Close(); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// move accepts an absolute URL or a path as Destination. The path of an URL must not contain any prefix.
+func (h *HTTPHandler) move(w http.ResponseWriter, r *http.Request, p Path) error {
+	dst, err := url.Parse(r.Header.Get(httpHeaderDestination))
+	if err != nil || len(dst.Path) == 0 {
+		return &DefaultError{Message: "invalid destination", Code: EINVAL, CausedBy: err}
+	}
+	if err := h.FileSystem.Rename(r.Context(), p.String(), Path(dst.Path).Normalize().String()); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+func (h *HTTPHandler) invoke(w http.ResponseWriter, r *http.Request, endpoint string) {
+	var args []interface{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&args); err != nil && err != io.EOF {
+			h.writeError(w, &DefaultError{Message: "invalid arguments", Code: EINVAL, CausedBy: err})
+			return
+		}
+	}
+	res, err := h.FileSystem.Invoke(r.Context(), endpoint, args...)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	if err := h.writeJSON(w, http.StatusOK, res); err != nil {
+		h.writeError(w, err)
+	}
+}
+
+func (h *HTTPHandler) writeJSON(w http.ResponseWriter, status int, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", httpContentTypeJSON)
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+	return nil
+}
+
+func (h *HTTPHandler) writeError(w http.ResponseWriter, err error) {
+	code := errorCode(err)
+	status, ok := httpStatus[code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	data, _ := json.Marshal(&httpError{Code: code, Message: err.Error()})
+	w.Header().Set("Content-Type", httpContentTypeJSON)
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
+
+// errorCode returns the status code of the first Error in the chain. Plain os errors are translated, anything else
+// is EUNKOWN.
+func errorCode(err error) int {
+	var vfsErr Error
+	if errors.As(err, &vfsErr) {
+		return vfsErr.StatusCode()
+	}
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return ENOENT
+	case errors.Is(err, os.ErrExist):
+		return EEXIST
+	case errors.Is(err, os.ErrPermission):
+		return EACCES
+	default:
+		return EUNKOWN
+	}
+}
@@ -0,0 +1,107 @@
+package vfs
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func httpDo(t *testing.T, method string, url string, body string, header map[string]string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silentClose(res.Body)
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, string(data)
+}
+
+func TestHTTPHandler(t *testing.T) {
+	fs := &MemFS{}
+	srv := httptest.NewServer(&HTTPHandler{FileSystem: fs})
+	defer srv.Close()
+
+	if res, _ := httpDo(t, http.MethodPut, srv.URL+"/docs/a.txt", "0123456789", nil); res.StatusCode != http.StatusCreated {
+		t.Fatal("expected 201 but got", res.StatusCode)
+	}
+	if str := memRead(t, fs, "/docs/a.txt"); str != "0123456789" {
+		t.Fatal("expected 0123456789 but got", str)
+	}
+
+	res, body := httpDo(t, http.MethodGet, srv.URL+"/docs/a.txt", "", nil)
+	if res.StatusCode != http.StatusOK || body != "0123456789" || res.Header.Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Fatal("unexpected response", res.StatusCode, body, res.Header)
+	}
+	res, body = httpDo(t, http.MethodGet, srv.URL+"/docs/a.txt", "", map[string]string{"Range": "bytes=2-4"})
+	if res.StatusCode != http.StatusPartialContent || body != "234" {
+		t.Fatal("expected 206 and 234 but got", res.StatusCode, body)
+	}
+
+	if res, _ := httpDo(t, httpMethodMkCol, srv.URL+"/docs/img", "", nil); res.StatusCode != http.StatusCreated {
+		t.Fatal("expected 201 but got", res.StatusCode)
+	}
+	res, body = httpDo(t, http.MethodGet, srv.URL+"/docs", "", nil)
+	bucket := &httpBucket{}
+	if err := json.Unmarshal([]byte(body), bucket); err != nil {
+		t.Fatal(err, body)
+	}
+	if len(bucket.Entries) != 2 || bucket.Entries[0].Name != "a.txt" || bucket.Entries[0].Size != 10 || !bucket.Entries[1].Bucket {
+		t.Fatal("unexpected listing", body)
+	}
+	if res, body = httpDo(t, http.MethodGet, srv.URL+"/docs?page=1", "", nil); res.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatal("expected 416 but got", res.StatusCode, body)
+	}
+
+	res, body = httpDo(t, http.MethodGet, srv.URL+"/docs/img?attrs", "", nil)
+	entry := &httpEntry{}
+	if err := json.Unmarshal([]byte(body), entry); err != nil || entry.Name != "img" || !entry.Bucket {
+		t.Fatal("unexpected attributes", body, err)
+	}
+
+	if res, _ := httpDo(t, httpMethodMove, srv.URL+"/docs/a.txt", "", map[string]string{httpHeaderDestination: srv.URL + "/docs/b.txt"}); res.StatusCode != http.StatusCreated {
+		t.Fatal("expected 201 but got", res.StatusCode)
+	}
+	if res, _ := httpDo(t, http.MethodDelete, srv.URL+"/docs/b.txt", "", nil); res.StatusCode != http.StatusNoContent {
+		t.Fatal("expected 204 but got", res.StatusCode)
+	}
+
+	res, body = httpDo(t, http.MethodGet, srv.URL+"/docs/b.txt", "", nil)
+	httpErr := &httpError{}
+	if err := json.Unmarshal([]byte(body), httpErr); err != nil || res.StatusCode != http.StatusNotFound || httpErr.Code != ENOENT {
+		t.Fatal("expected 404 but got", res.StatusCode, body)
+	}
+	if res, _ := httpDo(t, http.MethodPost, srv.URL+"/:echo", "", nil); res.StatusCode != http.StatusNotImplemented {
+		t.Fatal("expected 501 but got", res.StatusCode)
+	}
+}
+
+func TestHTTPHandler_Invoke(t *testing.T) {
+	fs := &AbstractFileSystem{FInvoke: func(ctx context.Context, endpoint string, args ...interface{}) (interface{}, error) {
+		return map[string]interface{}{"endpoint": endpoint, "args": args}, nil
+	}}
+	srv := httptest.NewServer(&HTTPHandler{FileSystem: fs})
+	defer srv.Close()
+
+	res, body := httpDo(t, http.MethodPost, srv.URL+"/:echo", `["a", 1]`, nil)
+	if res.StatusCode != http.StatusOK || body != `{"args":["a",1],"endpoint":"echo"}` {
+		t.Fatal("unexpected response", res.StatusCode, body)
+	}
+	if res, _ := httpDo(t, http.MethodGet, srv.URL+"/:echo", "", nil); res.StatusCode != http.StatusBadRequest {
+		t.Fatal("expected 400 but got", res.StatusCode)
+	}
+}
@@ -0,0 +1,292 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+)
+
+var _ fs.ReadDirFS = (*ioFS)(nil)
+var _ fs.StatFS = (*ioFS)(nil)
+var _ fs.ReadFileFS = (*ioFS)(nil)
+
+// AsIOFS returns an fs.FS view on the bucket root of the FileSystem, e.g. to serve it with http.FileServer(http.FS())
+// or to parse templates with template.ParseFS.
+//
+// Details
+//
+//  * the returned fs.FS also implements fs.ReadDirFS, fs.StatFS and fs.ReadFileFS
+//  * opened blobs also implement io.Seeker and io.ReaderAt, if supported by the Blob
+//  * all calls are performed using the background context
+//  * errors are returned as *fs.PathError, ENOENT and ENOTDIR are translated into fs.ErrNotExist, EEXIST into
+//    fs.ErrExist and EACCES, EPERM and EROFS into fs.ErrPermission
+func AsIOFS(fsys FileSystem, root Path) fs.FS {
+	return &ioFS{fs: fsys, root: root.Normalize()}
+}
+
+// FromIOFS returns a read only FileSystem for the given fs.FS, e.g. to mount an embed.FS into a
+// MountableFileSystem. All modifications return EROFS.
+func FromIOFS(fsys fs.FS) FileSystem {
+	readOnly := func(op string) error {
+		return &DefaultError{Message: op + ": io/fs is read only", Code: EROFS}
+	}
+	builder := &Builder{}
+	return builder.Details("iofs", 1, 0, 0).
+		MatchBucket("/**").
+		OnList(func(ctx RoutingContext) ([]*DefaultEntry, error) {
+			entries, err := fs.ReadDir(fsys, ioFSName(ctx.Path()))
+			if err != nil {
+				// the spec requires ENOENT if path is not a bucket, e.g. os.DirFS returns ENOTDIR
+				if info, statErr := fs.Stat(fsys, ioFSName(ctx.Path())); statErr == nil && !info.IsDir() {
+					return nil, &DefaultError{Message: "not a bucket: " + ctx.Path().String(), Code: ENOENT, CausedBy: err, DetailsPayload: []string{ctx.Path().String()}}
+				}
+				return nil, ioFSError(err)
+			}
+			res := make([]*DefaultEntry, 0, len(entries))
+			for _, entry := range entries {
+				info, err := entry.Info()
+				if err != nil {
+					// removed in the meantime
+					continue
+				}
+				res = append(res, &DefaultEntry{Id: info.Name(), IsBucket: info.IsDir(), Length: info.Size(), Data: info})
+			}
+			return res, nil
+		}).
+		Add().
+		ReadEntryAttrs(func(ctx context.Context, path Path, dst *DefaultEntry) error {
+			info, err := fs.Stat(fsys, ioFSName(path))
+			if err != nil {
+				return ioFSError(err)
+			}
+			dst.Id = info.Name()
+			dst.IsBucket = info.IsDir()
+			dst.Length = info.Size()
+			dst.Data = info
+			return nil
+		}).
+		MatchBlob("/**").
+		OnOpen(func(ctx RoutingContext, flag int, options interface{}) (Blob, error) {
+			if flag != os.O_RDONLY {
+				return nil, readOnly("Open")
+			}
+			file, err := fsys.Open(ioFSName(ctx.Path()))
+			if err != nil {
+				return nil, ioFSError(err)
+			}
+			if info, err := file.Stat(); err == nil && info.IsDir() {
+				_ = file.Close()
+				return nil, &DefaultError{Message: ctx.Path().String(), Code: EISDIR, DetailsPayload: []string{ctx.Path().String()}}
+			}
+			return &BlobAdapter{file}, nil
+		}).
+		Add().
+		Delete(func(ctx context.Context, path Path) error {
+			return readOnly("Delete")
+		}).
+		MkBucket(func(ctx context.Context, path Path, options interface{}) error {
+			return readOnly("MkBucket")
+		}).
+		Rename(func(ctx context.Context, oldPath Path, newPath Path) error {
+			return readOnly("Rename")
+		}).
+		Create()
+}
+
+// ioFSName converts the path into an unrooted io/fs name.
+func ioFSName(path Path) string {
+	name := strings.TrimPrefix(path.Normalize().String(), "/")
+	if len(name) == 0 {
+		return "."
+	}
+	return name
+}
+
+// ioFSError translates the io/fs errors into vfs errors. Unknown errors are an EIO.
+func ioFSError(err error) error {
+	var vfsErr Error
+	switch {
+	case errors.As(err, &vfsErr):
+		return err
+	case errors.Is(err, fs.ErrNotExist):
+		return &DefaultError{Message: err.Error(), Code: ENOENT, CausedBy: err}
+	case errors.Is(err, fs.ErrExist):
+		return &DefaultError{Message: err.Error(), Code: EEXIST, CausedBy: err}
+	case errors.Is(err, fs.ErrPermission):
+		return &DefaultError{Message: err.Error(), Code: EACCES, CausedBy: err}
+	case errors.Is(err, fs.ErrInvalid):
+		return &DefaultError{Message: err.Error(), Code: EINVAL, CausedBy: err}
+	default:
+		return &DefaultError{Message: err.Error(), Code: EIO, CausedBy: err}
+	}
+}
+
+// ioFS implements the fs.FS view of AsIOFS
+type ioFS struct {
+	fs   FileSystem
+	root Path
+}
+
+// resolve validates the name and returns the absolute path.
+func (f *ioFS) resolve(op string, name string) (Path, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return f.root.Add(Path(name)), nil
+}
+
+func (f *ioFS) Open(name string) (fs.File, error) {
+	path, err := f.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	entry, err := f.fs.ReadAttrs(ctx, path.String(), nil)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: osError(err)}
+	}
+	info := ioFileInfo{entryDelegator{entry}, name}
+	if entry.IsDir() {
+		return &ioDir{fs: f, name: name, info: info}, nil
+	}
+	blob, err := f.fs.Open(ctx, path.String(), os.O_RDONLY, nil)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: osError(err)}
+	}
+	return &ioFile{Blob: blob, info: info}, nil
+}
+
+// ReadDir returns the entries sorted by name.
+func (f *ioFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	path, err := f.resolve("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := readEntries(context.Background(), f.fs, path.String(), nil)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: osError(err)}
+	}
+	res := make([]fs.DirEntry, len(entries))
+	for i, entry := range entries {
+		res[i] = ioDirEntry{entryDelegator{entry}}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name() < res[j].Name()
+	})
+	return res, nil
+}
+
+func (f *ioFS) Stat(name string) (fs.FileInfo, error) {
+	path, err := f.resolve("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	entry, err := f.fs.ReadAttrs(context.Background(), path.String(), nil)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: osError(err)}
+	}
+	return ioFileInfo{entryDelegator{entry}, name}, nil
+}
+
+func (f *ioFS) ReadFile(name string) ([]byte, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer silentClose(file)
+	if _, ok := file.(*ioDir); ok {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	return data, nil
+}
+
+// ioFileInfo uses the base of the io/fs name, because the root entry has usually no name
+type ioFileInfo struct {
+	entryDelegator
+	name string
+}
+
+func (i ioFileInfo) Name() string {
+	if i.name == "." {
+		return "."
+	}
+	return Path(i.name).Name()
+}
+
+// ioDirEntry is the fs.DirEntry of an Entry
+type ioDirEntry struct {
+	entryDelegator
+}
+
+func (e ioDirEntry) Type() fs.FileMode {
+	return e.Mode().Type()
+}
+
+func (e ioDirEntry) Info() (fs.FileInfo, error) {
+	return e.entryDelegator, nil
+}
+
+// ioFile is an opened blob
+type ioFile struct {
+	Blob
+	info fs.FileInfo
+}
+
+func (f *ioFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// ioDir is an opened bucket, which reads its entries on the first call to ReadDir.
+type ioDir struct {
+	fs      *ioFS
+	name    string
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *ioDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *ioDir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *ioDir) Close() error {
+	return nil
+}
+
+// ReadDir works like fs.ReadDirFile.ReadDir
+func (d *ioDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.fs.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+		d.read = true
+	}
+	if n <= 0 {
+		res := d.entries
+		d.entries = nil
+		return res, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	res := d.entries[:n]
+	d.entries = d.entries[n:]
+	return res, nil
+}
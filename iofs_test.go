@@ -0,0 +1,98 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAsIOFS(t *testing.T) {
+	mem := &MemFS{}
+	memWrite(t, mem, "/www/index.html", "<html></html>")
+	memWrite(t, mem, "/www/css/main.css", "body{}")
+	memWrite(t, mem, "/other.txt", "other")
+
+	fsys := AsIOFS(mem, "/www")
+	if err := fstest.TestFS(fsys, "index.html", "css/main.css"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat(fsys, "other.txt"); !os.IsNotExist(err) {
+		t.Fatal("expected not exist but got", err)
+	}
+	if _, err := fs.Stat(fsys, "../other.txt"); err == nil {
+		t.Fatal("expected invalid path")
+	}
+
+	srv := httptest.NewServer(http.FileServer(http.FS(fsys)))
+	defer srv.Close()
+	res, body := httpDo(t, http.MethodGet, srv.URL+"/css/main.css", "", map[string]string{"Range": "bytes=0-3"})
+	if res.StatusCode != http.StatusPartialContent || body != "body" {
+		t.Fatal("expected body but got", res.StatusCode, body)
+	}
+}
+
+func TestFromIOFS(t *testing.T) {
+	assets := fstest.MapFS{
+		"static/logo.svg":   {Data: []byte("<svg/>")},
+		"static/js/main.js": {Data: []byte("main()")},
+	}
+	mfs := &MountableFileSystem{}
+	mfs.Mount("/assets", FromIOFS(assets))
+	ctx := context.Background()
+
+	if str := memRead(t, mfs, "/assets/static/js/main.js"); str != "main()" {
+		t.Fatal("expected main() but got", str)
+	}
+	if names := overlayNames(t, mfs, "/assets/static"); len(names) != 2 || names[0] != "js" || names[1] != "logo.svg" {
+		t.Fatal("unexpected listing", names)
+	}
+	entry, err := mfs.ReadAttrs(ctx, "/assets/static/logo.svg", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Name() != "logo.svg" || entry.IsDir() || size(entry) != 6 {
+		t.Fatal("unexpected entry", entry)
+	}
+	if _, err := mfs.Open(ctx, "/assets/static/missing.svg", os.O_RDONLY, nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+	if _, err := mfs.Open(ctx, "/assets/static/js", os.O_RDONLY, nil); !IsErr(err, EISDIR) {
+		t.Fatal("expected EISDIR but got", err)
+	}
+	if _, err := mfs.Open(ctx, "/assets/static/new.svg", os.O_CREATE|os.O_WRONLY, nil); !IsErr(err, EROFS) {
+		t.Fatal("expected EROFS but got", err)
+	}
+	if err := mfs.Delete(ctx, "/assets/static/logo.svg"); !IsErr(err, EROFS) {
+		t.Fatal("expected EROFS but got", err)
+	}
+	if _, err := mfs.ReadBucket(ctx, "/assets/static/logo.svg", nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+
+	// os.DirFS returns ENOTDIR for a blob
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FromIOFS(os.DirFS(dir)).ReadBucket(ctx, "/a.txt", nil); !IsErr(err, ENOENT) {
+		t.Fatal("expected ENOENT but got", err)
+	}
+
+	// any other error is an EIO
+	if _, err := FromIOFS(failingFS{}).ReadBucket(ctx, "/", nil); !IsErr(err, EIO) {
+		t.Fatal("expected EIO but got", err)
+	}
+}
+
+// failingFS fails with an unknown error
+type failingFS struct{}
+
+func (failingFS) Open(name string) (fs.File, error) {
+	return nil, errors.New("device unplugged")
+}
@@ -2,8 +2,13 @@ package vfs
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -20,25 +25,139 @@ type RoutingContext interface {
 
 	// Context returns the golang execution context
 	Context() context.Context
+
+	// WithContext returns a copy with the given context, e.g. to pass values or a timeout to the next layer
+	WithContext(ctx context.Context) RoutingContext
 }
 
-// A Router has a set of patterns which can be registered to be matched in the order of configuration.
+// A RouteHandler is the callback of a matched pattern.
+type RouteHandler func(ctx RoutingContext) (interface{}, error)
+
+// A Middleware wraps the RouteHandler of each matched pattern, e.g. for authorization, logging or timeouts.
+// A Middleware may decide to not invoke next at all.
+type Middleware func(next RouteHandler) RouteHandler
+
+// errRouteNotFound is returned by a mounted Router, which has no matching pattern
+var errRouteNotFound = errors.New("route not found")
+
+// A Router has a set of patterns, which are compiled into a tree of path segments. If multiple patterns match a
+// path, the most specific one wins, see Match.
+//
+// Details
+//
+//  * the middlewares of Use wrap each matched callback, the first one is the outermost
+//  * a Group registers its patterns below a prefix at the parent Router and applies its own middlewares after
+//    the ones of the parent. Dispatching a Group dispatches the parent.
+//  * a Router can be mounted below a prefix of another Router, see Mount
 type Router struct {
-	matchers []matcher
+	root        *routeNode
+	middlewares []Middleware
+	// parent and prefix are defined for a Group
+	parent *Router
+	prefix string
 }
 
-// Dispatch tries to find the correct matcher for the given path. The first matching callback is invoked or if
-// nothing matches, nothing is called at all (and false is returned). Returns io.EOF if no matcher can be applied.
-func (r *Router) Dispatch(ctx context.Context, path Path, args ...interface{}) (interface{}, error) {
-	for _, m := range r.matchers {
-		matcher, err := m.apply(ctx, path)
-		if err != nil {
-			continue
+// Use appends middlewares, which wrap all callbacks of this Router, also the ones registered before.
+func (r *Router) Use(middlewares ...Middleware) {
+	r.middlewares = append(r.middlewares, middlewares...)
+}
+
+// Group returns a Router, which registers all patterns below the prefix at this Router. The prefix may contain
+// variables, like /users/{id:int}.
+func (r *Router) Group(prefix string) *Router {
+	return &Router{parent: r, prefix: prefix}
+}
+
+// Mount dispatches all paths below the prefix to the sub Router, which owns the prefix: if the sub Router has no
+// matching pattern, nothing matches, even if another pattern of this Router would. Within the sub Router,
+// RoutingContext.Path returns the remaining path and ValueOf also returns the variables of the prefix.
+// The prefix must not contain **.
+func (r *Router) Mount(prefix string, sub *Router) {
+	full := prefix
+	for g := r; g.parent != nil; g = g.parent {
+		full = joinPattern(g.prefix, full)
+	}
+	names := Path(full).Names()
+	for _, name := range names {
+		if name == "**" {
+			panic("invalid mount prefix " + prefix + ": ** is not supported")
 		}
-		// invoke, if the pattern matches and return
-		return matcher.callback(matcher)
 	}
-	return nil, io.EOF
+	r.Match(joinPattern(prefix, "*"), func(ctx RoutingContext) (interface{}, error) {
+		rest := Path("/" + strings.Join(ctx.Path().Names()[len(names):], "/"))
+		m, ok := sub.lookup(ctx.Context(), rest, ctx.Args()...)
+		if !ok {
+			return nil, errRouteNotFound
+		}
+		m.parent = ctx
+		return sub.handler(m.route)(m)
+	})
+}
+
+// joinPattern concatenates the patterns
+func joinPattern(prefix string, pattern string) string {
+	return strings.TrimSuffix(prefix, "/") + "/" + strings.TrimPrefix(pattern, "/")
+}
+
+// handler returns the callback of the route, wrapped by the middlewares
+func (r *Router) handler(route *route) RouteHandler {
+	h := RouteHandler(route.callback)
+	for i := len(r.middlewares) - 1; i >= 0; i-- {
+		h = r.middlewares[i](h)
+	}
+	return h
+}
+
+// Dispatch tries to find the correct matcher for the given path. The most specific matching callback is invoked or
+// if nothing matches, nothing is called at all. Returns io.EOF if no matcher can be applied. The args are
+// available by RoutingContext.Args.
+func (r *Router) Dispatch(ctx context.Context, path Path, args ...interface{}) (interface{}, error) {
+	res, matched, err := r.dispatch(ctx, path, args...)
+	if !matched {
+		return nil, io.EOF
+	}
+	return res, err
+}
+
+// dispatch invokes the most specific matching callback and returns false, if nothing matches.
+func (r *Router) dispatch(ctx context.Context, path Path, args ...interface{}) (interface{}, bool, error) {
+	if r.parent != nil {
+		return r.parent.dispatch(ctx, path, args...)
+	}
+	m, ok := r.lookup(ctx, path, args...)
+	if !ok {
+		return nil, false, nil
+	}
+	res, err := r.handler(m.route)(m)
+	if errors.Is(err, errRouteNotFound) {
+		return nil, false, nil
+	}
+	return res, true, err
+}
+
+// lookup returns the RoutingContext of the most specific matching pattern.
+func (r *Router) lookup(ctx context.Context, path Path, args ...interface{}) (*matcher, bool) {
+	if r.parent != nil {
+		return r.parent.lookup(ctx, path, args...)
+	}
+	if r.root == nil {
+		return nil, false
+	}
+	m := &matcher{path: path, args: args, ctx: ctx}
+	route := r.root.match(path.Names(), m)
+	if route == nil {
+		return nil, false
+	}
+	m.route = route
+	return m, true
+}
+
+// empty returns true, if no pattern has been registered
+func (r *Router) empty() bool {
+	if r.parent != nil {
+		return r.parent.empty()
+	}
+	return r.root == nil
 }
 
 // DispatchResultSet is required to workaround missing generics
@@ -77,16 +196,52 @@ func (r *Router) DispatchEntry(ctx context.Context, path Path, args ...interface
 	return nil, fmt.Errorf("cannot convert result: %v", err)
 }
 
-// Match registers an arbitrary function with a pattern with injection-like semantics.
+// Match registers an arbitrary function with a pattern with injection-like semantics. Match panics, if the
+// pattern is invalid. If the same pattern is registered twice, the first one wins.
 //
 // Supported patterns are:
 //  * * : matches everything
 //  * /a/concrete/path : matches the exact path
 //  * /{name} : matches anything like /a or /b
 //  * /fix/{var}/fix : matches anything like /fix/a/fix or /fix/b/fix
+//  * /fix/{id:int} : matches only integers, like /fix/42. Also supported are uint and uuid.
+//  * /fix/{name:[a-z]+} : any other constraint is a regular expression for the entire value, like /fix/abc
+//  * /fix/{file}.jpg : a variable with a prefix and/or suffix, like /fix/a.jpg. Only one variable per segment.
+//  * /fix/*/fix : matches exactly one arbitrary segment, like /fix/a/fix
 //  * /fix/fix2/* : matches anything like /fix/fix2 or /fix/fix2/a/b/
+//  * /fix/**/fix : matches zero or more arbitrary segments, like /fix/fix or /fix/a/b/fix
+//  * /fix/*.jpg : a segment with *, ? or [ is matched using path.Match, like /fix/a.jpg
+//
+// The most specific pattern wins, which is decided from left to right segment by segment in the following
+// order: concrete names, constrained variables, variables with prefix or suffix, path.Match segments,
+// variables and *, ** and finally a trailing *. Longer prefixes and suffixes are more specific. Otherwise the
+// order of registration decides.
 func (r *Router) Match(pattern string, callback func(ctx RoutingContext) (interface{}, error)) {
-	r.matchers = append(r.matchers, matcher{pattern, "", callback, nil, nil})
+	if r.parent != nil {
+		r.parent.Match(joinPattern(r.prefix, pattern), func(ctx RoutingContext) (interface{}, error) {
+			return r.handler(&route{callback: callback})(ctx)
+		})
+		return
+	}
+	if r.root == nil {
+		r.root = &routeNode{}
+	}
+	names := Path(pattern).Names()
+	route := &route{pattern: pattern, callback: callback}
+	node := r.root
+	for i, name := range names {
+		seg, err := compileSegment(name, i == len(names)-1)
+		if err != nil {
+			panic(fmt.Sprintf("invalid pattern %s: %v", pattern, err))
+		}
+		if seg.kind == segmentParam {
+			route.params = append(route.params, seg.name)
+		}
+		node = node.child(seg)
+	}
+	if node.route == nil {
+		node.route = route
+	}
 }
 
 // MatchResultSet is required to workaround missing generics
@@ -110,86 +265,286 @@ func (r *Router) MatchEntry(pattern string, f func(ctx RoutingContext) (Entry, e
 	})
 }
 
+// a matcher is the RoutingContext of a dispatched path
 type matcher struct {
-	pattern  string
-	path     Path
-	callback func(ctx RoutingContext) (interface{}, error)
-	args     []interface{}
-	ctx      context.Context
+	route  *route
+	path   Path
+	values []string
+	args   []interface{}
+	ctx    context.Context
+	// parent is the RoutingContext of the mount prefix, if any
+	parent RoutingContext
 }
 
-func (c matcher) ValueOf(name string) string {
-	varName := "{" + name + "}"
-	idxOfName := -1
-	for i, elem := range Path(c.pattern).Names() {
-		if elem == varName {
-			idxOfName = i
-			break
+func (c *matcher) ValueOf(name string) string {
+	for i, param := range c.route.params {
+		if param == name {
+			return c.values[i]
 		}
 	}
-	if idxOfName < 0 {
-		return ""
-	}
-	// there could be out of bounds failure, which we silently ignore
-	pathNames := c.path.Names()
-	if idxOfName >= len(pathNames) {
-		return ""
+	if c.parent != nil {
+		return c.parent.ValueOf(name)
 	}
-	return pathNames[idxOfName]
+	return ""
 }
 
-func (c matcher) Path() Path {
+func (c *matcher) WithContext(ctx context.Context) RoutingContext {
+	res := *c
+	res.ctx = ctx
+	return &res
+}
+
+func (c *matcher) Path() Path {
 	return c.path
 }
 
-func (c matcher) Args() []interface{} {
+func (c *matcher) Args() []interface{} {
 	return c.args
 }
 
-func (c matcher) apply(ctx context.Context, path Path, args ...interface{}) (matcher, error) {
-	if c.pattern == "*" {
-		return c.derive(ctx, path), nil
-	}
+func (c *matcher) Context() context.Context {
+	return c.ctx
+}
 
-	patternPath := Path(c.pattern)
+// a route is a registered pattern
+type route struct {
+	pattern  string
+	params   []string
+	callback func(ctx RoutingContext) (interface{}, error)
+}
 
-	if patternPath.Normalize().String() == path.Normalize().String() {
-		return c.derive(ctx, path, args...), nil
-	}
+type segmentKind int
 
-	patternSegments := patternPath.Names()
-	pathSegments := path.Names()
+// the kinds of dynamic segments, ordered by their priority
+const (
+	segmentStatic segmentKind = iota
+	segmentParam
+	segmentGlob
+	segmentWildcard
+	segmentGlobstar
+	segmentRest
+)
 
-	if len(pathSegments) == len(patternSegments) {
-		for i, p := range patternSegments {
-			isWildcard := p == "*"
-			if isWildcard {
-				break
-			}
-			isNamedVar := strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}")
-			if isNamedVar {
-				// a named path segment is ignored
-				continue
-			}
-			if pathSegments[i] != patternSegments[i] {
-				return c, fmt.Errorf("cannot match path")
+// a routeSegment is a compiled name of a pattern
+type routeSegment struct {
+	kind segmentKind
+	// key identifies equal segments regardless of the variable name
+	key        string
+	name       string
+	prefix     string
+	suffix     string
+	constraint func(value string) bool
+}
+
+// compileSegment parses a single name of a pattern
+func compileSegment(name string, last bool) (routeSegment, error) {
+	switch {
+	case name == "**":
+		return routeSegment{kind: segmentGlobstar, key: name}, nil
+	case name == "*" && last:
+		// a trailing wildcard matches also the parent itself
+		return routeSegment{kind: segmentRest, key: name}, nil
+	case name == "*":
+		return routeSegment{kind: segmentWildcard, key: name}, nil
+	case strings.Contains(name, "{"):
+		return compileParam(name)
+	case strings.ContainsAny(name, "*?["):
+		if _, err := path.Match(name, ""); err != nil {
+			return routeSegment{}, err
+		}
+		return routeSegment{kind: segmentGlob, key: name}, nil
+	default:
+		return routeSegment{kind: segmentStatic, key: name}, nil
+	}
+}
+
+// compileParam parses a segment like prefix{name:constraint}suffix
+func compileParam(name string) (routeSegment, error) {
+	start := strings.Index(name, "{")
+	end := -1
+	depth := 0
+	for i := start; i < len(name) && end < 0; i++ {
+		switch name[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				end = i
 			}
 		}
-		return c.derive(ctx, path, args...), nil
 	}
+	if end < 0 {
+		return routeSegment{}, fmt.Errorf("unclosed variable in %s", name)
+	}
+	seg := routeSegment{kind: segmentParam, prefix: name[:start], suffix: name[end+1:]}
+	if strings.ContainsAny(seg.prefix+seg.suffix, "{}") {
+		return routeSegment{}, fmt.Errorf("only one variable per segment is supported: %s", name)
+	}
+	spec := name[start+1 : end]
+	constraint := ""
+	if idx := strings.Index(spec, ":"); idx >= 0 {
+		spec, constraint = spec[:idx], spec[idx+1:]
+	}
+	seg.name = spec
+	seg.key = seg.prefix + "{:" + constraint + "}" + seg.suffix
+	switch constraint {
+	case "":
+	case "int":
+		seg.constraint = func(value string) bool {
+			_, err := strconv.ParseInt(value, 10, 64)
+			return err == nil
+		}
+	case "uint":
+		seg.constraint = func(value string) bool {
+			_, err := strconv.ParseUint(value, 10, 64)
+			return err == nil
+		}
+	case "uuid":
+		seg.constraint = uuidPattern.MatchString
+	default:
+		regex, err := regexp.Compile("^(?:" + constraint + ")$")
+		if err != nil {
+			return routeSegment{}, err
+		}
+		seg.constraint = regex.MatchString
+	}
+	return seg, nil
+}
+
+var uuidPattern = regexp.MustCompile("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$")
 
-	if len(patternSegments) > 0 && patternSegments[len(patternSegments)-1] == "*" && strings.HasPrefix(path.String(), patternPath.Parent().String()) {
-		return c.derive(ctx, path, args...), nil
+// rank returns the priority of the segment, the lower the more specific
+func (s routeSegment) rank() int {
+	switch {
+	case s.kind == segmentParam && s.constraint != nil:
+		return 1
+	case s.kind == segmentParam && len(s.prefix)+len(s.suffix) > 0:
+		return 2
+	case s.kind == segmentGlob:
+		return 3
+	case s.kind == segmentParam || s.kind == segmentWildcard:
+		return 4
+	case s.kind == segmentGlobstar:
+		return 5
+	case s.kind == segmentRest:
+		return 6
+	default:
+		return 0
 	}
+}
 
-	return c, fmt.Errorf("cannot match path")
+// literals returns the amount of literal characters, which are more specific
+func (s routeSegment) literals() int {
+	if s.kind == segmentGlob {
+		return len(s.key)
+	}
+	return len(s.prefix) + len(s.suffix)
+}
 
+// matches checks a single name of a path and returns the value of a variable
+func (s routeSegment) matches(name string) (string, bool) {
+	switch s.kind {
+	case segmentWildcard:
+		return "", true
+	case segmentGlob:
+		ok, _ := path.Match(s.key, name)
+		return "", ok
+	case segmentParam:
+		if len(name) <= len(s.prefix)+len(s.suffix) || !strings.HasPrefix(name, s.prefix) || !strings.HasSuffix(name, s.suffix) {
+			return "", false
+		}
+		value := name[len(s.prefix) : len(name)-len(s.suffix)]
+		if s.constraint != nil && !s.constraint(value) {
+			return "", false
+		}
+		return value, true
+	default:
+		return "", s.key == name
+	}
 }
 
-func (c matcher) Context() context.Context {
-	return c.ctx
+// a routeNode is a node of the segment tree. Concrete names are indexed, the dynamic children are sorted by
+// their priority.
+type routeNode struct {
+	seg     routeSegment
+	static  map[string]*routeNode
+	dynamic []*routeNode
+	// route is the pattern which ends at this node, if any
+	route *route
 }
 
-func (c matcher) derive(ctx context.Context, path Path, args ...interface{}) matcher {
-	return matcher{c.pattern, path, c.callback, args, ctx}
+// child returns the existing or a new child node for the segment
+func (n *routeNode) child(seg routeSegment) *routeNode {
+	if seg.kind == segmentStatic {
+		if n.static == nil {
+			n.static = make(map[string]*routeNode)
+		}
+		child := n.static[seg.key]
+		if child == nil {
+			child = &routeNode{seg: seg}
+			n.static[seg.key] = child
+		}
+		return child
+	}
+	for _, child := range n.dynamic {
+		if child.seg.kind == seg.kind && child.seg.key == seg.key {
+			return child
+		}
+	}
+	child := &routeNode{seg: seg}
+	n.dynamic = append(n.dynamic, child)
+	sort.SliceStable(n.dynamic, func(i, j int) bool {
+		a, b := n.dynamic[i].seg, n.dynamic[j].seg
+		if a.rank() != b.rank() {
+			return a.rank() < b.rank()
+		}
+		return a.literals() > b.literals()
+	})
+	return child
+}
+
+// match returns the most specific route for the remaining names and collects the values of the variables.
+func (n *routeNode) match(names []string, m *matcher) *route {
+	if len(names) == 0 && n.route != nil {
+		return n.route
+	}
+	if len(names) > 0 {
+		if child := n.static[names[0]]; child != nil {
+			if r := child.match(names[1:], m); r != nil {
+				return r
+			}
+		}
+	}
+	for _, child := range n.dynamic {
+		switch child.seg.kind {
+		case segmentRest:
+			if child.route != nil {
+				return child.route
+			}
+		case segmentGlobstar:
+			for i := 0; i <= len(names); i++ {
+				if r := child.match(names[i:], m); r != nil {
+					return r
+				}
+			}
+		default:
+			if len(names) == 0 {
+				continue
+			}
+			value, ok := child.seg.matches(names[0])
+			if !ok {
+				continue
+			}
+			mark := len(m.values)
+			if child.seg.kind == segmentParam {
+				m.values = append(m.values, value)
+			}
+			if r := child.match(names[1:], m); r != nil {
+				return r
+			}
+			m.values = m.values[:mark]
+		}
+	}
+	return nil
 }
@@ -2,7 +2,10 @@ package vfs
 
 import (
 	"context"
+	"fmt"
+	"io"
 	"testing"
+	"time"
 )
 
 func TestRouter_Dispatch(t *testing.T) {
@@ -63,3 +66,224 @@ func assertState(t *testing.T, router *Router, path Path, expect string) {
 	}
 
 }
+
+func TestRouter_Globs(t *testing.T) {
+	router := &Router{}
+
+	router.Match("/a/**/{id}/c", func(ctx RoutingContext) (interface{}, error) {
+		return "1:" + ctx.ValueOf("id"), nil
+	})
+
+	router.Match("/b/*/c", func(ctx RoutingContext) (interface{}, error) {
+		return "2", nil
+	})
+
+	router.Match("/b/*.jpg", func(ctx RoutingContext) (interface{}, error) {
+		return "3", nil
+	})
+
+	router.Match("*", func(ctx RoutingContext) (interface{}, error) {
+		return "4", nil
+	})
+
+	assertState(t, router, "/a/x/c", "1:x")
+	assertState(t, router, "/a/1/2/3/x/c", "1:x")
+	assertState(t, router, "/a/c", "4")
+	assertState(t, router, "/b/x/c", "2")
+	assertState(t, router, "/b/x/y/c", "4")
+	assertState(t, router, "/b/x.jpg", "3")
+	assertState(t, router, "/b/x.png", "4")
+}
+
+func TestRouter_Constraints(t *testing.T) {
+	router := &Router{}
+	value := func(name string) func(ctx RoutingContext) (interface{}, error) {
+		return func(ctx RoutingContext) (interface{}, error) {
+			return name + ":" + ctx.ValueOf("id"), nil
+		}
+	}
+	// registered in reverse order of specificity on purpose
+	router.Match("/users/*", value("rest"))
+	router.Match("/users/{id}", value("any"))
+	router.Match("/users/{id}.jpg", value("suffix"))
+	router.Match("/users/img-{id:int}.jpg", value("typed-suffix"))
+	router.Match("/users/{id:[a-z]+}", value("regex"))
+	router.Match("/users/{id:int}", value("int"))
+	router.Match("/users/{id:uuid}", value("uuid"))
+	router.Match("/users/me", value("static"))
+	router.Match("/files/**/{id}.jpg", value("globstar"))
+
+	assertState(t, router, "/users/42", "int:42")
+	assertState(t, router, "/users/-1", "int:-1")
+	assertState(t, router, "/users/abc", "regex:abc")
+	assertState(t, router, "/users/me", "static:")
+	assertState(t, router, "/users/Abc", "any:Abc")
+	assertState(t, router, "/users/a.jpg", "suffix:a")
+	assertState(t, router, "/users/img-7.jpg", "typed-suffix:7")
+	assertState(t, router, "/users/img-x.jpg", "suffix:img-x")
+	assertState(t, router, "/users/0b8a1b8e-7a8e-4c6a-9d32-0c1b2f3a4d5e", "uuid:0b8a1b8e-7a8e-4c6a-9d32-0c1b2f3a4d5e")
+	assertState(t, router, "/users", "rest:")
+	assertState(t, router, "/users/a/b", "rest:")
+	assertState(t, router, "/files/a/b/c.jpg", "globstar:c")
+	assertState(t, router, "/files/c.jpg", "globstar:c")
+
+	if _, err := router.Dispatch(context.Background(), "/files/c.png"); err != io.EOF {
+		t.Fatal("expected io.EOF but got", err)
+	}
+}
+
+func TestRouter_Args(t *testing.T) {
+	router := &Router{}
+	router.Match("/a/**", func(ctx RoutingContext) (interface{}, error) {
+		return ctx.Args()[0], nil
+	})
+	res, err := router.Dispatch(context.Background(), "/a/b/c", "arg")
+	if err != nil || res != "arg" {
+		t.Fatal("expected arg but got", res, err)
+	}
+}
+
+func TestRouter_Invalid(t *testing.T) {
+	for _, pattern := range []string{"/a/{id", "/a/{id:[}", "/a/{x}{y}", "/a/[a"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("expected a panic for", pattern)
+				}
+			}()
+			router := &Router{}
+			router.Match(pattern, nil)
+		}()
+	}
+}
+
+func benchmarkRouter(routes int) *Router {
+	router := &Router{}
+	callback := func(ctx RoutingContext) (interface{}, error) {
+		return ctx.ValueOf("photo"), nil
+	}
+	for i := 0; i < routes; i++ {
+		router.Match(fmt.Sprintf("/api/v%d/users/{id:int}/photos/{photo}.jpg", i), callback)
+		router.Match(fmt.Sprintf("/static/%d/**/{file}", i), callback)
+	}
+	router.Match("*", callback)
+	return router
+}
+
+func BenchmarkRouter_Dispatch(b *testing.B) {
+	for _, routes := range []int{10, 1000, 5000} {
+		router := benchmarkRouter(routes)
+		ctx := context.Background()
+		path := Path(fmt.Sprintf("/api/v%d/users/42/photos/summer.jpg", routes-1))
+		b.Run(fmt.Sprint(routes), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				res, err := router.Dispatch(ctx, path)
+				if err != nil || res != "summer" {
+					b.Fatal("unexpected result", res, err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRouter_Fallback(b *testing.B) {
+	router := benchmarkRouter(5000)
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := router.Dispatch(ctx, "/unknown/path/to/somewhere"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+type testRouteKey struct{}
+
+func TestRouter_Middleware(t *testing.T) {
+	router := &Router{}
+	var log []string
+	router.Use(func(next RouteHandler) RouteHandler {
+		return func(ctx RoutingContext) (interface{}, error) {
+			log = append(log, "log "+ctx.Path().String())
+			return next(ctx.WithContext(context.WithValue(ctx.Context(), testRouteKey{}, "alice")))
+		}
+	})
+	router.Match("/public", func(ctx RoutingContext) (interface{}, error) {
+		return "public", nil
+	})
+
+	users := router.Group("/users/{id:int}")
+	users.Use(func(next RouteHandler) RouteHandler {
+		return func(ctx RoutingContext) (interface{}, error) {
+			if ctx.Context().Value(testRouteKey{}) != "alice" || ctx.ValueOf("id") != "1" {
+				return nil, &DefaultError{Message: "forbidden", Code: EACCES}
+			}
+			return next(ctx)
+		}
+	})
+	users.Match("/photos/{photo}", func(ctx RoutingContext) (interface{}, error) {
+		return ctx.ValueOf("id") + ":" + ctx.ValueOf("photo"), nil
+	})
+
+	admin := &Router{}
+	admin.Match("/stats", func(ctx RoutingContext) (interface{}, error) {
+		return ctx.Path().String() + ":" + ctx.ValueOf("id"), nil
+	})
+	users.Mount("/admin", admin)
+
+	assertState(t, router, "/public", "public")
+	assertState(t, router, "/users/1/photos/a.jpg", "1:a.jpg")
+	assertState(t, router, "/users/1/admin/stats", "/stats:1")
+	if _, err := router.Dispatch(context.Background(), "/users/2/photos/a.jpg"); !IsErr(err, EACCES) {
+		t.Fatal("expected EACCES but got", err)
+	}
+	if _, err := router.Dispatch(context.Background(), "/users/1/admin/other"); err != io.EOF {
+		t.Fatal("expected io.EOF but got", err)
+	}
+	if len(log) != 5 || log[0] != "log /public" {
+		t.Fatal("unexpected log", log)
+	}
+}
+
+func TestRouter_WrappingMiddleware(t *testing.T) {
+	router := &Router{}
+	router.Use(func(next RouteHandler) RouteHandler {
+		return func(ctx RoutingContext) (interface{}, error) {
+			res, err := next(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", ctx.Path(), err)
+			}
+			return res, nil
+		}
+	})
+	admin := &Router{}
+	admin.Match("/stats", func(ctx RoutingContext) (interface{}, error) {
+		return "stats", nil
+	})
+	router.Mount("/admin", admin)
+
+	assertState(t, router, "/admin/stats", "stats")
+	if _, err := router.Dispatch(context.Background(), "/admin/other"); err != io.EOF {
+		t.Fatal("expected io.EOF but got", err)
+	}
+}
+
+func TestRouter_Timeout(t *testing.T) {
+	router := &Router{}
+	router.Use(func(next RouteHandler) RouteHandler {
+		return func(ctx RoutingContext) (interface{}, error) {
+			timeout, cancel := context.WithTimeout(ctx.Context(), time.Millisecond)
+			defer cancel()
+			return next(ctx.WithContext(timeout))
+		}
+	})
+	router.Match("/slow", func(ctx RoutingContext) (interface{}, error) {
+		<-ctx.Context().Done()
+		return nil, ctx.Context().Err()
+	})
+	if _, err := router.Dispatch(context.Background(), "/slow"); err != context.DeadlineExceeded {
+		t.Fatal("expected a deadline but got", err)
+	}
+}
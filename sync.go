@@ -0,0 +1,615 @@
+package vfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SyncDirection defines which sides of a Sync are modified.
+type SyncDirection int
+
+const (
+	// SyncTwoWay propagates the changes of both sides to each other and resolves conflicts
+	SyncTwoWay SyncDirection = iota
+	// SyncOneWay mirrors the source into the destination, all changes of the destination are overwritten
+	SyncOneWay
+)
+
+// syncStateName is the name of the default state database in the destination bucket
+const syncStateName = ".vfssync.json"
+
+// SyncResolution is the decision of a ConflictPolicy.
+type SyncResolution int
+
+const (
+	// ResolveUseSrc replaces the destination with the source
+	ResolveUseSrc SyncResolution = iota
+	// ResolveUseDst replaces the source with the destination
+	ResolveUseDst
+	// ResolveKeepBoth renames the destination into a conflict copy and copies both versions to both sides
+	ResolveKeepBoth
+	// ResolveSkip leaves both sides untouched, the conflict is detected again by the next Sync
+	ResolveSkip
+)
+
+// A SyncConflict describes a path which has been modified on both sides since the last Sync.
+type SyncConflict struct {
+	// Path is relative to the synchronized buckets
+	Path string
+	// Src is the current source entry or nil, if it has been deleted
+	Src Entry
+	// Dst is the current destination entry or nil, if it has been deleted
+	Dst Entry
+}
+
+// A ConflictPolicy decides how a SyncConflict is resolved. A returned error is passed to CopyOptions.OnError.
+type ConflictPolicy func(conflict *SyncConflict) (SyncResolution, error)
+
+// ConflictNewestWins keeps the entry with the latest modification time. A deleted entry always loses, so that
+// no modification gets lost. If the times are equal or unknown, the source wins.
+func ConflictNewestWins(conflict *SyncConflict) (SyncResolution, error) {
+	switch {
+	case conflict.Src == nil:
+		return ResolveUseDst, nil
+	case conflict.Dst == nil:
+		return ResolveUseSrc, nil
+	case entryDelegator{conflict.Dst}.ModTime().After(entryDelegator{conflict.Src}.ModTime()):
+		return ResolveUseDst, nil
+	default:
+		return ResolveUseSrc, nil
+	}
+}
+
+// ConflictKeepBoth always keeps both versions.
+func ConflictKeepBoth(conflict *SyncConflict) (SyncResolution, error) {
+	return ResolveKeepBoth, nil
+}
+
+// SyncOptions configures a Sync. The embedded CopyOptions are used to report the progress and errors and to
+// cancel the Sync.
+type SyncOptions struct {
+	CopyOptions
+
+	// SrcPath is the synchronized bucket of the source, defaults to the root
+	SrcPath string
+	// DstPath is the synchronized bucket of the destination, defaults to the root
+	DstPath string
+	// Direction is SyncTwoWay by default
+	Direction SyncDirection
+	// Hash enables the comparison of SHA-256 content hashes, if the size or the modification time has changed.
+	// Without hashes, a touched entry is always treated as modified.
+	Hash bool
+	// Conflict resolves conflicts of a two way Sync, defaults to ConflictNewestWins
+	Conflict ConflictPolicy
+	// State is the FileSystem of the state database, defaults to the destination
+	State FileSystem
+	// StatePath is the path of the state database, defaults to .vfssync.json in the DstPath. It is only excluded
+	// from the Sync by default, so a custom StatePath should be outside of the synchronized buckets.
+	StatePath string
+}
+
+// Sync synchronizes the buckets of src and dst, see SyncOptions for the defaults.
+//
+// Details
+//
+//  * the state database contains the snapshot of the last Sync. An entry is modified, if its type, size or
+//    modification time differs from the snapshot and, if enabled, also its content hash.
+//  * two way: changes of one side are applied to the other side, a deletion is propagated, unless a child has
+//    been modified on the other side. If both sides have been modified, the ConflictPolicy is asked. Entries
+//    which appear on both sides without a snapshot are only equal, if the hashes or if size and modification
+//    time are equal.
+//  * one way: the destination becomes a mirror of the source, unmodified entries are skipped
+//  * buckets are compared by their type only
+//  * an error is passed to OnError. If OnError returns nil, the entry is skipped and the snapshot of the entry
+//    is kept. The state database is also saved, if the Sync is cancelled with EINTR or fails.
+func Sync(ctx context.Context, src, dst FileSystem, opts *SyncOptions) error {
+	if opts == nil {
+		opts = &SyncOptions{}
+	}
+	s := &syncer{ctx: ctx, opts: opts, hashes: [2]map[string]string{{}, {}}}
+	s.fs[0], s.fs[1] = src, dst
+	s.root[0], s.root[1] = Path(opts.SrcPath).Normalize(), Path(opts.DstPath).Normalize()
+	s.stateFs, s.statePath = opts.State, Path(opts.StatePath).Normalize()
+	if s.stateFs == nil {
+		s.stateFs = dst
+		if len(opts.StatePath) == 0 {
+			s.statePath = s.root[1].Child(syncStateName)
+			s.exclude = "/" + syncStateName
+		}
+	}
+
+	if err := s.load(); err != nil {
+		return err
+	}
+	err := s.run()
+	if saveErr := s.save(); err == nil {
+		err = saveErr
+	}
+	return err
+}
+
+// syncState is the persisted snapshot of the last Sync
+type syncState struct {
+	Entries map[string]*syncRecord `json:"entries"`
+}
+
+// a syncRecord contains the metadata of both sides, after the entry has been synchronized
+type syncRecord struct {
+	Bucket bool        `json:"bucket"`
+	Sides  [2]syncMeta `json:"sides"`
+}
+
+type syncMeta struct {
+	Size     int64  `json:"size"`
+	Modified int64  `json:"modified"` // unix nanoseconds or 0 if unknown
+	Hash     string `json:"hash,omitempty"`
+}
+
+// syncStatus is the change of an entry of one side since the last Sync
+type syncStatus int
+
+const (
+	syncAbsent syncStatus = iota
+	syncUnchanged
+	syncCreated
+	syncChanged
+	syncDeleted
+)
+
+// quiet returns true, if nothing has happened
+func (s syncStatus) quiet() bool {
+	return s == syncAbsent || s == syncUnchanged
+}
+
+// syncer contains the state of a single Sync. Index 0 is the source and 1 is the destination.
+type syncer struct {
+	ctx       context.Context
+	opts      *SyncOptions
+	fs        [2]FileSystem
+	root      [2]Path
+	entries   [2]map[string]Entry
+	hashes    [2]map[string]string
+	status    [2]map[string]syncStatus
+	stateFs   FileSystem
+	statePath Path
+	exclude   string
+	state     *syncState
+	next      *syncState
+	scanned   int64
+	found     int64
+	objects   int64
+	bytes     int64
+}
+
+func (s *syncer) load() error {
+	s.state = &syncState{Entries: make(map[string]*syncRecord)}
+	data, err := ReadAllFS(s.ctx, s.stateFs, s.statePath.String())
+	switch {
+	case isNotFound(err):
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(data, s.state); err != nil {
+			return &DefaultError{Message: "invalid sync state: " + err.Error(), Code: EINVAL, CausedBy: err}
+		}
+		if s.state.Entries == nil {
+			s.state.Entries = make(map[string]*syncRecord)
+		}
+	}
+	s.next = &syncState{Entries: make(map[string]*syncRecord, len(s.state.Entries))}
+	for rel, rec := range s.state.Entries {
+		s.next.Entries[rel] = rec
+	}
+	return nil
+}
+
+func (s *syncer) save() error {
+	data, err := json.Marshal(s.next)
+	if err != nil {
+		return err
+	}
+	_, err = WriteAllFS(s.ctx, s.stateFs, s.statePath.String(), data)
+	return err
+}
+
+func (s *syncer) run() error {
+	for side := 0; side < 2; side++ {
+		if err := s.prepare(side); err != nil {
+			return err
+		}
+		s.entries[side] = make(map[string]Entry)
+		if err := s.walk(side, ""); err != nil {
+			return err
+		}
+	}
+
+	paths := make(map[string]bool)
+	for side := 0; side < 2; side++ {
+		for rel := range s.entries[side] {
+			paths[rel] = true
+		}
+	}
+	for rel := range s.state.Entries {
+		paths[rel] = true
+	}
+	sorted := make([]string, 0, len(paths))
+	for rel := range paths {
+		sorted = append(sorted, rel)
+	}
+	sort.Strings(sorted)
+
+	for side := 0; side < 2; side++ {
+		s.status[side] = make(map[string]syncStatus, len(sorted))
+		for _, rel := range sorted {
+			status, err := s.detect(side, rel)
+			if err != nil {
+				return err
+			}
+			s.status[side][rel] = status
+		}
+	}
+
+	var deleted []string
+	for _, rel := range sorted {
+		if s.opts.IsCancelled() {
+			return &DefaultError{Message: "sync cancelled", Code: EINTR}
+		}
+		if syncIsChild(deleted, rel) {
+			delete(s.next.Entries, rel)
+			continue
+		}
+		removed, err := s.sync(rel)
+		if err != nil {
+			if err = s.opts.onError(rel, err); err != nil {
+				return err
+			}
+			continue
+		}
+		if removed {
+			deleted = append(deleted, rel)
+		}
+	}
+	return nil
+}
+
+// prepare creates the missing bucket of a writable side
+func (s *syncer) prepare(side int) error {
+	_, err := s.fs[side].ReadAttrs(s.ctx, s.root[side].String(), nil)
+	if isNotFound(err) && (side == 1 || s.opts.Direction == SyncTwoWay) {
+		return s.fs[side].MkBucket(s.ctx, s.root[side].String(), nil)
+	}
+	return err
+}
+
+// walk collects all entries recursively, using paths relative to the root
+func (s *syncer) walk(side int, rel string) error {
+	entries, err := readEntries(s.ctx, s.fs[side], s.root[side].Add(Path(rel)).String(), nil)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		child := rel + "/" + entry.Id
+		if child == s.exclude || isCopyJournal(entry.Id) {
+			continue
+		}
+		s.entries[side][child] = entry
+		length := size(entry)
+		if length > 0 && !entry.IsBucket {
+			s.found += length
+		}
+		s.scanned++
+		s.opts.onScan(s.root[side].Add(Path(child)).String(), s.scanned, s.found)
+		if entry.IsBucket {
+			if err := s.walk(side, child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// meta returns the current metadata of an entry without a hash
+func (s *syncer) meta(entry Entry) syncMeta {
+	meta := syncMeta{Size: size(entry)}
+	if modTime := (entryDelegator{entry}).ModTime(); modTime.Unix() > 0 {
+		meta.Modified = modTime.UnixNano()
+	}
+	return meta
+}
+
+// hash returns the content hash, which is calculated only once
+func (s *syncer) hash(side int, rel string) (string, error) {
+	if hash, ok := s.hashes[side][rel]; ok {
+		return hash, nil
+	}
+	blob, err := s.fs[side].Open(s.ctx, s.root[side].Add(Path(rel)).String(), os.O_RDONLY, nil)
+	if err != nil {
+		return "", err
+	}
+	defer silentClose(blob)
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, blob); err != nil {
+		return "", err
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	s.hashes[side][rel] = hash
+	return hash, nil
+}
+
+// detect compares the current entry with the snapshot
+func (s *syncer) detect(side int, rel string) (syncStatus, error) {
+	entry := s.entries[side][rel]
+	rec := s.state.Entries[rel]
+	switch {
+	case rec == nil && entry == nil:
+		return syncAbsent, nil
+	case rec == nil:
+		return syncCreated, nil
+	case entry == nil:
+		return syncDeleted, nil
+	case rec.Bucket != entry.IsDir():
+		return syncChanged, nil
+	case rec.Bucket:
+		return syncUnchanged, nil
+	}
+	old, cur := rec.Sides[side], s.meta(entry)
+	if old.Size == cur.Size && old.Modified == cur.Modified {
+		return syncUnchanged, nil
+	}
+	if !s.opts.Hash || len(old.Hash) == 0 || old.Size != cur.Size {
+		return syncChanged, nil
+	}
+	hash, err := s.hash(side, rel)
+	if err != nil {
+		return syncChanged, err
+	}
+	if hash == old.Hash {
+		return syncUnchanged, nil
+	}
+	return syncChanged, nil
+}
+
+// equal compares the entries of both sides without a snapshot
+func (s *syncer) equal(rel string) (bool, error) {
+	src, dst := s.entries[0][rel], s.entries[1][rel]
+	if src.IsDir() != dst.IsDir() {
+		return false, nil
+	}
+	if src.IsDir() {
+		return true, nil
+	}
+	srcMeta, dstMeta := s.meta(src), s.meta(dst)
+	if srcMeta.Size != dstMeta.Size {
+		return false, nil
+	}
+	if !s.opts.Hash {
+		return srcMeta.Modified != 0 && srcMeta.Modified == dstMeta.Modified, nil
+	}
+	srcHash, err := s.hash(0, rel)
+	if err != nil {
+		return false, err
+	}
+	dstHash, err := s.hash(1, rel)
+	if err != nil {
+		return false, err
+	}
+	return srcHash == dstHash, nil
+}
+
+// dirty returns true, if a child of the bucket has been modified on the given side
+func (s *syncer) dirty(side int, rel string) bool {
+	for child, status := range s.status[side] {
+		if strings.HasPrefix(child, rel+"/") && !status.quiet() {
+			return true
+		}
+	}
+	return false
+}
+
+// sync applies the changes of a single entry and returns true, if a bucket has been deleted
+func (s *syncer) sync(rel string) (bool, error) {
+	src, dst := s.status[0][rel], s.status[1][rel]
+	srcEntry, dstEntry := s.entries[0][rel], s.entries[1][rel]
+
+	if src.quiet() && dst.quiet() {
+		if srcEntry != nil && dstEntry != nil {
+			s.record(rel)
+		} else {
+			delete(s.next.Entries, rel)
+		}
+		return false, nil
+	}
+
+	if srcEntry != nil && dstEntry != nil {
+		equal, err := s.equal(rel)
+		if err != nil {
+			return false, err
+		}
+		if equal {
+			s.record(rel)
+			return false, nil
+		}
+	}
+
+	if s.opts.Direction == SyncOneWay {
+		return s.apply(0, rel)
+	}
+
+	switch {
+	case srcEntry == nil && dstEntry == nil:
+		delete(s.next.Entries, rel)
+		return false, nil
+	case dst.quiet():
+		return s.apply(0, rel)
+	case src.quiet():
+		return s.apply(1, rel)
+	}
+
+	policy := s.opts.Conflict
+	if policy == nil {
+		policy = ConflictNewestWins
+	}
+	resolution, err := policy(&SyncConflict{Path: rel, Src: srcEntry, Dst: dstEntry})
+	if err != nil {
+		return false, err
+	}
+	switch resolution {
+	case ResolveUseSrc:
+		return s.apply(0, rel)
+	case ResolveUseDst:
+		return s.apply(1, rel)
+	case ResolveKeepBoth:
+		return false, s.keepBoth(rel)
+	default:
+		return false, nil
+	}
+}
+
+// apply makes the other side equal to the given side
+func (s *syncer) apply(from int, rel string) (bool, error) {
+	to := 1 - from
+	if s.entries[from][rel] != nil {
+		return false, s.copy(from, rel, rel)
+	}
+	if s.entries[to][rel] == nil {
+		delete(s.next.Entries, rel)
+		return false, nil
+	}
+	if s.opts.Direction == SyncTwoWay && s.entries[to][rel].IsDir() && s.dirty(to, rel) {
+		// keep the modified children and recreate the bucket
+		if err := s.fs[from].MkBucket(s.ctx, s.root[from].Add(Path(rel)).String(), nil); err != nil {
+			return false, err
+		}
+		s.entries[from][rel] = s.entries[to][rel]
+		s.record(rel)
+		return false, nil
+	}
+	if err := s.fs[to].Delete(s.ctx, s.root[to].Add(Path(rel)).String()); err != nil {
+		return false, err
+	}
+	delete(s.next.Entries, rel)
+	return s.entries[to][rel].IsDir(), nil
+}
+
+// keepBoth moves the destination into a conflict copy and copies it to the source
+func (s *syncer) keepBoth(rel string) error {
+	if s.entries[0][rel] == nil || s.entries[1][rel] == nil {
+		from := 0
+		if s.entries[0][rel] == nil {
+			from = 1
+		}
+		_, err := s.apply(from, rel)
+		return err
+	}
+	conflict := syncConflictName(rel)
+	if err := s.fs[1].Rename(s.ctx, s.root[1].Add(Path(rel)).String(), s.root[1].Add(Path(conflict)).String()); err != nil {
+		return err
+	}
+	s.entries[1][conflict] = s.entries[1][rel]
+	s.entries[1][rel] = nil
+	if err := s.copy(1, conflict, conflict); err != nil {
+		return err
+	}
+	return s.copy(0, rel, rel)
+}
+
+// syncConflictName inserts a timestamp before the extension, e.g. /a (conflict 20191231-235959).txt
+func syncConflictName(rel string) string {
+	p := Path(rel)
+	name := p.Name()
+	ext := ""
+	if idx := strings.LastIndex(name, "."); idx > 0 {
+		name, ext = name[:idx], name[idx:]
+	}
+	return p.Parent().Normalize().Child(name + " (conflict " + time.Now().Format("20060102-150405") + ")" + ext).String()
+}
+
+// copy transfers the entry to the other side, replacing an entry of a different type
+func (s *syncer) copy(from int, rel string, dstRel string) error {
+	to := 1 - from
+	entry := s.entries[from][rel]
+	srcPath, dstPath := s.root[from].Add(Path(rel)).String(), s.root[to].Add(Path(dstRel)).String()
+	if existing := s.entries[to][dstRel]; existing != nil && existing.IsDir() != entry.IsDir() {
+		if err := s.fs[to].Delete(s.ctx, dstPath); err != nil {
+			return err
+		}
+	}
+
+	if entry.IsDir() {
+		if err := s.fs[to].MkBucket(s.ctx, dstPath, nil); err != nil {
+			return err
+		}
+	} else {
+		hash, written, err := s.transfer(from, srcPath, dstPath, size(entry))
+		if err != nil {
+			return err
+		}
+		s.hashes[from][rel] = hash
+		s.hashes[to][dstRel] = hash
+		s.bytes += written
+	}
+
+	written, err := s.fs[to].ReadAttrs(s.ctx, dstPath, nil)
+	if err != nil {
+		return err
+	}
+	s.entries[to][dstRel] = written
+	s.record(dstRel)
+	s.objects++
+	s.opts.onCopied(srcPath, s.objects, s.bytes)
+	return nil
+}
+
+// transfer copies the content of a blob and returns its hash
+func (s *syncer) transfer(from int, srcPath string, dstPath string, length int64) (string, int64, error) {
+	reader, err := s.fs[from].Open(s.ctx, srcPath, os.O_RDONLY, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	defer silentClose(reader)
+	writer, err := s.fs[1-from].Open(s.ctx, dstPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	hasher := sha256.New()
+	written, err := copyBuffer(srcPath, dstPath, length, io.TeeReader(reader, hasher), writer, nil, &s.opts.CopyOptions)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", written, err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), written, nil
+}
+
+// record updates the snapshot of an entry, which exists on both sides. The hash of an unchanged entry is kept.
+func (s *syncer) record(rel string) {
+	old := s.state.Entries[rel]
+	rec := &syncRecord{Bucket: s.entries[0][rel].IsDir()}
+	for side := 0; side < 2 && !rec.Bucket; side++ {
+		meta := s.meta(s.entries[side][rel])
+		meta.Hash = s.hashes[side][rel]
+		if len(meta.Hash) == 0 && old != nil && old.Sides[side].Size == meta.Size && old.Sides[side].Modified == meta.Modified {
+			meta.Hash = old.Sides[side].Hash
+		}
+		rec.Sides[side] = meta
+	}
+	s.next.Entries[rel] = rec
+}
+
+// syncIsChild returns true, if rel is a child of any of the buckets
+func syncIsChild(buckets []string, rel string) bool {
+	for _, bucket := range buckets {
+		if strings.HasPrefix(rel, bucket+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package vfs
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// syncNames returns all relative paths of the bucket, except the state database
+func syncNames(t *testing.T, fs FileSystem, path string) string {
+	t.Helper()
+	s := &syncer{ctx: context.Background(), opts: &SyncOptions{}, exclude: "/" + syncStateName}
+	s.fs[0], s.root[0], s.entries[0] = fs, Path(path), make(map[string]Entry)
+	if err := s.walk(0, ""); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for rel := range s.entries[0] {
+		names = append(names, rel)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func memModTime(t *testing.T, fs FileSystem, path string) time.Time {
+	t.Helper()
+	entry, err := fs.ReadAttrs(context.Background(), path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entry.Sys().(*MemAttrs).ModTime()
+}
+
+func TestSync_TwoWay(t *testing.T) {
+	src, dst := &MemFS{}, &MemFS{}
+	ctx := context.Background()
+	memWrite(t, src, "/a.txt", "a")
+	memWrite(t, src, "/docs/b.txt", "b")
+	memWrite(t, dst, "/c.txt", "c")
+
+	if err := Sync(ctx, src, dst, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, fs := range []FileSystem{src, dst} {
+		if names := syncNames(t, fs, "/"); names != "/a.txt,/c.txt,/docs,/docs/b.txt" {
+			t.Fatal("unexpected entries", names)
+		}
+	}
+
+	memWrite(t, src, "/a.txt", "a2")
+	memWrite(t, dst, "/docs/d.txt", "d")
+	if err := dst.Delete(ctx, "/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Sync(ctx, src, dst, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, fs := range []FileSystem{src, dst} {
+		if names := syncNames(t, fs, "/"); names != "/a.txt,/docs,/docs/b.txt,/docs/d.txt" {
+			t.Fatal("unexpected entries", names)
+		}
+	}
+	if str := memRead(t, dst, "/a.txt"); str != "a2" {
+		t.Fatal("expected a2 but got", str)
+	}
+
+	// a deleted bucket is kept, if a child has been modified on the other side
+	if err := src.Delete(ctx, "/docs"); err != nil {
+		t.Fatal(err)
+	}
+	memWrite(t, dst, "/docs/d.txt", "d2")
+	if err := Sync(ctx, src, dst, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, fs := range []FileSystem{src, dst} {
+		if names := syncNames(t, fs, "/"); names != "/a.txt,/docs,/docs/d.txt" {
+			t.Fatal("unexpected entries", names)
+		}
+	}
+}
+
+func TestSync_Conflicts(t *testing.T) {
+	src, dst := &MemFS{}, &MemFS{}
+	ctx := context.Background()
+	memWrite(t, src, "/a.txt", "a")
+	memWrite(t, src, "/b.txt", "b")
+	if err := Sync(ctx, src, dst, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the source wins on equal modification times, so ensure that dst is newer
+	memWrite(t, src, "/a.txt", "src")
+	time.Sleep(10 * time.Millisecond)
+	memWrite(t, dst, "/a.txt", "dst")
+	if srcTime, dstTime := memModTime(t, src, "/a.txt"), memModTime(t, dst, "/a.txt"); !dstTime.After(srcTime) {
+		t.Fatal("expected a newer dst but got", srcTime, dstTime)
+	}
+	if err := Sync(ctx, src, dst, nil); err != nil {
+		t.Fatal(err)
+	}
+	if str := memRead(t, src, "/a.txt"); str != "dst" {
+		t.Fatal("expected the newest dst but got", str)
+	}
+
+	memWrite(t, src, "/b.txt", "src")
+	memWrite(t, dst, "/b.txt", "dst")
+	var conflicts []string
+	opts := &SyncOptions{Conflict: func(conflict *SyncConflict) (SyncResolution, error) {
+		conflicts = append(conflicts, conflict.Path)
+		return ConflictKeepBoth(conflict)
+	}}
+	if err := Sync(ctx, src, dst, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(conflicts) != 1 || conflicts[0] != "/b.txt" {
+		t.Fatal("expected a conflict but got", conflicts)
+	}
+	for _, fs := range []FileSystem{src, dst} {
+		names := strings.Split(syncNames(t, fs, "/"), ",")
+		if len(names) != 3 || !strings.HasPrefix(names[1], "/b (conflict ") {
+			t.Fatal("expected a conflict copy but got", names)
+		}
+		if str := memRead(t, fs, "/b.txt"); str != "src" {
+			t.Fatal("expected src but got", str)
+		}
+		if str := memRead(t, fs, names[1]); str != "dst" {
+			t.Fatal("expected dst but got", str)
+		}
+	}
+}
+
+func TestSync_OneWay(t *testing.T) {
+	src, dst := &MemFS{}, &MemFS{}
+	ctx := context.Background()
+	memWrite(t, src, "/media/a.jpg", "a")
+	memWrite(t, src, journalPath("/media/b.jpg"), "{}")
+	memWrite(t, dst, "/mirror/media/old.jpg", "old")
+	copied := 0
+	opts := &SyncOptions{DstPath: "/mirror", Direction: SyncOneWay, Hash: true}
+	opts.OnCopied = func(obj string, objectsTransferred int64, bytesTransferred int64) {
+		copied++
+	}
+
+	if err := Sync(ctx, src, dst, opts); err != nil {
+		t.Fatal(err)
+	}
+	if names := syncNames(t, dst, "/mirror"); names != "/media,/media/a.jpg" || copied != 1 {
+		t.Fatal("unexpected mirror", names, copied)
+	}
+	if _, err := dst.ReadAttrs(ctx, "/mirror/"+syncStateName, nil); err != nil {
+		t.Fatal("expected a state database but got", err)
+	}
+
+	// touched but equal content is not copied again
+	copied = 0
+	memWrite(t, src, "/media/a.jpg", "a")
+	memWrite(t, dst, "/mirror/media/a.jpg", "modified")
+	if err := Sync(ctx, src, dst, opts); err != nil {
+		t.Fatal(err)
+	}
+	if str := memRead(t, dst, "/mirror/media/a.jpg"); str != "a" || copied != 1 {
+		t.Fatal("expected a single copy but got", str, copied)
+	}
+	copied = 0
+	memWrite(t, src, "/media/a.jpg", "a")
+	if err := Sync(ctx, src, dst, opts); err != nil || copied != 0 {
+		t.Fatal("expected no copy but got", copied, err)
+	}
+
+	opts.Cancel()
+	if err := Sync(ctx, src, dst, opts); !IsErr(err, EINTR) {
+		t.Fatal("expected EINTR but got", err)
+	}
+}
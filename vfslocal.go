@@ -6,6 +6,7 @@ import (
 	"os"
 )
 
+// LocalFileSystem provides access to the local filesystem of the os. All errors are translated using FromOSError.
 var LocalFileSystem FileSystem
 
 func init() {
@@ -17,31 +18,38 @@ func createLocalVFS() FileSystem {
 
 	vfs := builder.Details("local", 1, 0, 0).
 		// bucket listing
-		MatchBucket("/").
-		OnList(func(path Path) ([]*DefaultEntry, error) {
-			files, err := ioutil.ReadDir(path.String())
+		MatchBucket("/**").
+		OnList(func(ctx RoutingContext) ([]*DefaultEntry, error) {
+			files, err := ioutil.ReadDir(ctx.Path().String())
 			if err != nil {
+				err = FromOSError(err)
+				if e, ok := err.(*DefaultError); ok && e.Code == ENOTDIR {
+					// the spec requires ENOENT if path is not a bucket
+					e.Code = ENOENT
+				}
 				return nil, err
 			}
 			res := make([]*DefaultEntry, len(files))
 			for i, f := range files {
-				res[i].Id = f.Name()
-				res[i].Length = f.Size()
-				res[i].IsBucket = f.IsDir()
-				res[i].Data = f
+				res[i] = &DefaultEntry{
+					Id:       f.Name(),
+					Length:   f.Size(),
+					IsBucket: f.IsDir(),
+					Data:     f,
+				}
 			}
 			return res, nil
 		}).
 		Add().
 		// generic (fallback) delete
 		Delete(func(i context.Context, path Path) error {
-			return os.RemoveAll(path.String())
+			return FromOSError(os.RemoveAll(path.String()))
 		}).
 		// generic (fallback) read attributes
 		ReadEntryAttrs(func(ctx context.Context, path Path, dst *DefaultEntry) error {
 			stat, err := os.Stat(path.String())
 			if err != nil {
-				return err
+				return FromOSError(err)
 			}
 			dst.Data = stat
 			dst.Length = stat.Size()
@@ -55,17 +63,23 @@ func createLocalVFS() FileSystem {
 			if p, ok := options.(os.FileMode); ok {
 				perm = p
 			}
-			return os.MkdirAll(path.String(), perm)
+			return FromOSError(os.MkdirAll(path.String(), perm))
 		}).
 		// blob matching
-		MatchBlob("/").
-		OnOpen(func(_ context.Context, path Path, flag int, perm interface{}) (blob Blob, e error) {
+		MatchBlob("/**").
+		OnOpen(func(ctx RoutingContext, flag int, perm interface{}) (blob Blob, e error) {
+			path := ctx.Path()
 			mode := os.ModePerm
 			if m, ok := perm.(os.FileMode); ok {
 				mode = m
 			}
 			if flag == os.O_RDONLY {
-				return os.OpenFile(path.String(), flag, 0)
+				file, err := os.OpenFile(path.String(), flag, 0)
+				if err != nil {
+					// avoid returning a typed nil *os.File as a non-nil Blob
+					return nil, FromOSError(err)
+				}
+				return file, nil
 			}
 			file, err := os.OpenFile(path.String(), flag, mode)
 			if _, ok := err.(*os.PathError); ok {
@@ -73,22 +87,29 @@ func createLocalVFS() FileSystem {
 				err2 := os.MkdirAll(path.Parent().String(), mode)
 				if err2 != nil {
 					//suppress err2 intentionally and return the original failure
-					return nil, err
+					return nil, FromOSError(err)
 				}
 				// mkdir is fine, retry again
 				file, err = os.OpenFile(path.String(), flag, mode)
-				if err != nil {
-					return nil, err
-				}
+			}
+			if err != nil {
+				return nil, FromOSError(err)
 			}
 			return file, nil
 		}).Add().
+		// renaming replaces the target
+		Rename(func(ctx context.Context, oldPath Path, newPath Path) error {
+			if err := os.MkdirAll(newPath.Parent().String(), os.ModePerm); err != nil {
+				return FromOSError(err)
+			}
+			return FromOSError(os.Rename(oldPath.String(), newPath.String()))
+		}).
 		// linkings
 		Symlink(func(ctx context.Context, oldPath Path, newPath Path) error {
-			return os.Symlink(oldPath.String(), newPath.String())
+			return FromOSError(os.Symlink(oldPath.String(), newPath.String()))
 		}).
 		Hardlink(func(ctx context.Context, oldPath Path, newPath Path) error {
-			return os.Link(oldPath.String(), newPath.String())
+			return FromOSError(os.Link(oldPath.String(), newPath.String()))
 		}).
 		// finally create the vfs
 		Create()
@@ -0,0 +1,533 @@
+// Package vfstest provides the conformance test suite (CTS) for vfs.FileSystem implementations.
+package vfstest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/worldiety/vfs"
+)
+
+// A Result describes the outcome of a single CTS check.
+type Result int
+
+const (
+	// Supported means that the contract is fulfilled
+	Supported Result = iota
+	// Unsupported means that the implementation has rejected at least one required operation with ENOSYS
+	Unsupported
+	// Failed means that the contract is violated
+	Failed
+)
+
+// String returns the markdown emoji for the result.
+func (r Result) String() string {
+	switch r {
+	case Supported:
+		return ":white_check_mark:"
+	case Unsupported:
+		return ":heavy_minus_sign:"
+	default:
+		return ":x:"
+	}
+}
+
+// A Check is the outcome of a named contract verification.
+type Check struct {
+	// Name of the check, as shown in the README
+	Name string
+	// Result of the check
+	Result Result
+	// Message contains the failure or the ENOSYS error, if any
+	Message string
+}
+
+// A Profile describes the capabilities of a FileSystem implementation.
+type Profile struct {
+	// Name is the String() of the tested FileSystem
+	Name   string
+	Checks []Check
+}
+
+// Result returns the outcome of the named check. Returns Failed if the check is unknown.
+func (p *Profile) Result(name string) Result {
+	for _, check := range p.Checks {
+		if check.Name == name {
+			return check.Result
+		}
+	}
+	return Failed
+}
+
+// Markdown renders the profile as a table, as used by the README.
+func (p *Profile) Markdown() string {
+	sb := &strings.Builder{}
+	sb.WriteString("| CTS Check     | Result        |\n")
+	sb.WriteString("| ------------- | ------------- |\n")
+	for _, check := range p.Checks {
+		sb.WriteString("| " + check.Name + "|" + check.Result.String() + " |\n")
+	}
+	return sb.String()
+}
+
+// a conformanceCheck returns nil on success. Any ENOSYS error marks the check as unsupported.
+type conformanceCheck struct {
+	name string
+	run  func(ctx context.Context, fs vfs.FileSystem) error
+}
+
+var checks = []conformanceCheck{
+	{"Empty", checkEmpty},
+	{"Write any", checkWriteAny},
+	{"Read any", checkReadAny},
+	{"Write and Read", checkWriteAndRead},
+	{"Random access", checkRandomAccess},
+	{"ReadBucket", checkReadBucket},
+	{"MkBucket", checkMkBucket},
+	{"Delete", checkDelete},
+	{"Rename", checkRename},
+	{"Attributes", checkAttributes},
+	{"SymLink", checkSymLink},
+	{"HardLink", checkHardLink},
+	{"RefLink", checkRefLink},
+	{"Transactions", checkTransactions},
+	{"Close", checkClose},
+}
+
+// RunConformance verifies the FileSystem contracts of the spec. Each check is executed as a sub test with a new
+// FileSystem instance created by the factory. A check fails, if the contract is violated and is reported as
+// unsupported, if the implementation rejects a required operation with ENOSYS. Use the returned profile
+// to render the README table of a backend.
+func RunConformance(t *testing.T, factory func() vfs.FileSystem) *Profile {
+	t.Helper()
+	profile := &Profile{}
+	for _, check := range checks {
+		check := check
+		t.Run(check.name, func(t *testing.T) {
+			fs := factory()
+			if len(profile.Name) == 0 {
+				profile.Name = fs.String()
+			}
+			err := check.run(context.Background(), fs)
+			_ = fs.Close()
+			res := Check{Name: check.name}
+			switch {
+			case err == nil:
+				res.Result = Supported
+			case vfs.IsErr(err, vfs.ENOSYS):
+				res.Result = Unsupported
+				res.Message = err.Error()
+				t.Log("unsupported:", err)
+			default:
+				res.Result = Failed
+				res.Message = err.Error()
+				t.Error(err)
+			}
+			profile.Checks = append(profile.Checks, res)
+		})
+	}
+	return profile
+}
+
+// expectErr returns nil if err has the expected code. ENOSYS is returned as is.
+func expectErr(op string, err error, code int) error {
+	if vfs.IsErr(err, code) {
+		return nil
+	}
+	if vfs.IsErr(err, vfs.ENOSYS) {
+		return err
+	}
+	return fmt.Errorf("%s: expected %s but got %v", op, vfs.StatusText(code), err)
+}
+
+func write(ctx context.Context, fs vfs.FileSystem, path string, data []byte) error {
+	blob, err := fs.Open(ctx, path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, nil)
+	if err != nil {
+		return err
+	}
+	n, err := blob.Write(data)
+	if err != nil {
+		_ = blob.Close()
+		return err
+	}
+	if n != len(data) {
+		_ = blob.Close()
+		return fmt.Errorf("%s: short write %d of %d", path, n, len(data))
+	}
+	return blob.Close()
+}
+
+func read(ctx context.Context, fs vfs.FileSystem, path string) ([]byte, error) {
+	blob, err := fs.Open(ctx, path, os.O_RDONLY, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+	return ioutil.ReadAll(blob)
+}
+
+func expectContent(ctx context.Context, fs vfs.FileSystem, path string, expected []byte) error {
+	data, err := read(ctx, fs, path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(data, expected) {
+		return fmt.Errorf("%s: expected %q but got %q", path, expected, data)
+	}
+	return nil
+}
+
+// list returns the names of all entries of all pages.
+func list(ctx context.Context, fs vfs.FileSystem, path string) ([]string, error) {
+	res, err := fs.ReadBucket(ctx, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for {
+		for i := 0; i < res.Len(); i++ {
+			names = append(names, res.ReadAttrs(i, nil).Name())
+		}
+		err = res.Next(ctx)
+		if err != nil {
+			if vfs.IsErr(err, vfs.EOF) || err == io.EOF {
+				return names, nil
+			}
+			return names, err
+		}
+	}
+}
+
+func expectNames(ctx context.Context, fs vfs.FileSystem, path string, expected ...string) error {
+	names, err := list(ctx, fs, path)
+	if err != nil {
+		return err
+	}
+	if len(names) != len(expected) {
+		return fmt.Errorf("%s: expected %v but got %v", path, expected, names)
+	}
+	for _, name := range expected {
+		found := false
+		for _, n := range names {
+			found = found || n == name
+		}
+		if !found {
+			return fmt.Errorf("%s: expected %v but got %v", path, expected, names)
+		}
+	}
+	return nil
+}
+
+func checkEmpty(ctx context.Context, fs vfs.FileSystem) error {
+	return expectNames(ctx, fs, "/")
+}
+
+func checkWriteAny(ctx context.Context, fs vfs.FileSystem) error {
+	// parents must be created implicitly
+	if err := write(ctx, fs, "/a/b/c.bin", []byte("hello")); err != nil {
+		return err
+	}
+	if err := write(ctx, fs, "/empty.bin", nil); err != nil {
+		return err
+	}
+	// an existing path segment must not be replaced
+	err := write(ctx, fs, "/a/b/c.bin/d.bin", []byte("world"))
+	if err == nil {
+		return fmt.Errorf("expected an error when writing below a blob")
+	}
+	return expectContent(ctx, fs, "/a/b/c.bin", []byte("hello"))
+}
+
+func checkReadAny(ctx context.Context, fs vfs.FileSystem) error {
+	_, err := read(ctx, fs, "/missing.bin")
+	if err := expectErr("Open", err, vfs.ENOENT); err != nil {
+		return err
+	}
+	data := make([]byte, 64*1024+3)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if err := write(ctx, fs, "/a.bin", data); err != nil {
+		return err
+	}
+	return expectContent(ctx, fs, "/a.bin", data)
+}
+
+func checkWriteAndRead(ctx context.Context, fs vfs.FileSystem) error {
+	if err := write(ctx, fs, "/a.txt", []byte("a long text")); err != nil {
+		return err
+	}
+	if err := write(ctx, fs, "/a.txt", []byte("short")); err != nil {
+		return err
+	}
+	return expectContent(ctx, fs, "/a.txt", []byte("short"))
+}
+
+func checkRandomAccess(ctx context.Context, fs vfs.FileSystem) error {
+	if err := write(ctx, fs, "/a.txt", []byte("0123456789")); err != nil {
+		return err
+	}
+	blob, err := fs.Open(ctx, "/a.txt", os.O_RDWR, nil)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	if _, err := blob.WriteAt([]byte("xy"), 4); err != nil {
+		return err
+	}
+	buf := make([]byte, 4)
+	if n, err := blob.ReadAt(buf, 3); err != nil || string(buf[:n]) != "3xy6" {
+		return fmt.Errorf("ReadAt: expected 3xy6 but got %q (%v)", buf[:n], err)
+	}
+	if n, err := blob.ReadAt(buf, 8); err != io.EOF || n != 2 {
+		return fmt.Errorf("ReadAt: expected 2 bytes and io.EOF but got %d (%v)", n, err)
+	}
+	pos, err := blob.Seek(-2, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if pos != 8 {
+		return fmt.Errorf("Seek: expected 8 but got %d", pos)
+	}
+	n, err := io.ReadFull(blob, buf[:2])
+	if err != nil || string(buf[:n]) != "89" {
+		return fmt.Errorf("Read: expected 89 but got %q (%v)", buf[:n], err)
+	}
+	return nil
+}
+
+func checkReadBucket(ctx context.Context, fs vfs.FileSystem) error {
+	_, err := fs.ReadBucket(ctx, "/missing", nil)
+	if err := expectErr("ReadBucket", err, vfs.ENOENT); err != nil {
+		return err
+	}
+	if err := write(ctx, fs, "/dir/a.txt", []byte("a")); err != nil {
+		return err
+	}
+	if err := write(ctx, fs, "/dir/sub/b.txt", []byte("b")); err != nil {
+		return err
+	}
+	_, err = fs.ReadBucket(ctx, "/dir/a.txt", nil)
+	if err := expectErr("ReadBucket of a blob", err, vfs.ENOENT); err != nil {
+		return err
+	}
+	if err := expectNames(ctx, fs, "/", "dir"); err != nil {
+		return err
+	}
+	return expectNames(ctx, fs, "/dir", "a.txt", "sub")
+}
+
+func checkMkBucket(ctx context.Context, fs vfs.FileSystem) error {
+	if err := fs.MkBucket(ctx, "/a/b/c", nil); err != nil {
+		return err
+	}
+	if err := fs.MkBucket(ctx, "/a/b/c", nil); err != nil {
+		return fmt.Errorf("MkBucket of an existing bucket: %v", err)
+	}
+	if err := expectNames(ctx, fs, "/a/b", "c"); err != nil {
+		return err
+	}
+	if err := write(ctx, fs, "/a/file", []byte("x")); err != nil {
+		return err
+	}
+	if err := fs.MkBucket(ctx, "/a/file/c", nil); err == nil {
+		return fmt.Errorf("MkBucket: expected an error if a segment is a blob")
+	}
+	return expectContent(ctx, fs, "/a/file", []byte("x"))
+}
+
+func checkDelete(ctx context.Context, fs vfs.FileSystem) error {
+	if err := write(ctx, fs, "/a/b/c.txt", []byte("x")); err != nil {
+		return err
+	}
+	if err := write(ctx, fs, "/a/d.txt", []byte("x")); err != nil {
+		return err
+	}
+	if err := fs.Delete(ctx, "/a/d.txt"); err != nil {
+		return err
+	}
+	if err := expectNames(ctx, fs, "/a", "b"); err != nil {
+		return err
+	}
+	if err := fs.Delete(ctx, "/a"); err != nil {
+		return err
+	}
+	if err := expectNames(ctx, fs, "/"); err != nil {
+		return err
+	}
+	// deleting non-existing resources is not an error
+	if err := fs.Delete(ctx, "/a"); err != nil {
+		return fmt.Errorf("Delete must be idempotent: %v", err)
+	}
+	if err := fs.Delete(ctx, "/x/y/z"); err != nil {
+		return fmt.Errorf("Delete must be idempotent: %v", err)
+	}
+	return nil
+}
+
+func checkRename(ctx context.Context, fs vfs.FileSystem) error {
+	err := fs.Rename(ctx, "/missing", "/other")
+	if err := expectErr("Rename", err, vfs.ENOENT); err != nil {
+		return err
+	}
+	if err := write(ctx, fs, "/a.txt", []byte("a")); err != nil {
+		return err
+	}
+	if err := write(ctx, fs, "/b.txt", []byte("b")); err != nil {
+		return err
+	}
+	if err := fs.Rename(ctx, "/a.txt", "/c.txt"); err != nil {
+		return err
+	}
+	if err := expectContent(ctx, fs, "/c.txt", []byte("a")); err != nil {
+		return err
+	}
+	// the target is replaced
+	if err := fs.Rename(ctx, "/c.txt", "/b.txt"); err != nil {
+		return err
+	}
+	if err := expectContent(ctx, fs, "/b.txt", []byte("a")); err != nil {
+		return err
+	}
+	if err := fs.MkBucket(ctx, "/dir", nil); err != nil {
+		return err
+	}
+	if err := fs.Rename(ctx, "/b.txt", "/dir/b.txt"); err != nil {
+		return err
+	}
+	return expectNames(ctx, fs, "/", "dir")
+}
+
+func checkAttributes(ctx context.Context, fs vfs.FileSystem) error {
+	_, err := fs.ReadAttrs(ctx, "/missing", nil)
+	if err := expectErr("ReadAttrs", err, vfs.ENOENT); err != nil {
+		return err
+	}
+	if err := write(ctx, fs, "/dir/a.txt", []byte("hello")); err != nil {
+		return err
+	}
+	entry, err := fs.ReadAttrs(ctx, "/dir/a.txt", nil)
+	if err != nil {
+		return err
+	}
+	if entry.Name() != "a.txt" || entry.IsDir() {
+		return fmt.Errorf("ReadAttrs: unexpected entry %s %v", entry.Name(), entry.IsDir())
+	}
+	if sizer, ok := entry.(interface{ Size() int64 }); ok && sizer.Size() >= 0 && sizer.Size() != 5 {
+		return fmt.Errorf("ReadAttrs: expected size 5 but got %d", sizer.Size())
+	}
+	entry, err = fs.ReadAttrs(ctx, "/dir", nil)
+	if err != nil {
+		return err
+	}
+	if !entry.IsDir() {
+		return fmt.Errorf("ReadAttrs: expected a bucket")
+	}
+	return nil
+}
+
+func checkSymLink(ctx context.Context, fs vfs.FileSystem) error {
+	if err := write(ctx, fs, "/a.txt", []byte("a")); err != nil {
+		return err
+	}
+	if err := fs.SymLink(ctx, "/a.txt", "/b.txt"); err != nil {
+		return err
+	}
+	if err := expectContent(ctx, fs, "/b.txt", []byte("a")); err != nil {
+		return err
+	}
+	err := fs.SymLink(ctx, "/a.txt", "/b.txt")
+	return expectErr("SymLink to existing", err, vfs.EEXIST)
+}
+
+func checkHardLink(ctx context.Context, fs vfs.FileSystem) error {
+	if err := write(ctx, fs, "/a.txt", []byte("a")); err != nil {
+		return err
+	}
+	if err := fs.HardLink(ctx, "/a.txt", "/b.txt"); err != nil {
+		return err
+	}
+	if err := fs.Delete(ctx, "/a.txt"); err != nil {
+		return err
+	}
+	if err := expectContent(ctx, fs, "/b.txt", []byte("a")); err != nil {
+		return err
+	}
+	if err := write(ctx, fs, "/c.txt", []byte("c")); err != nil {
+		return err
+	}
+	err := fs.HardLink(ctx, "/c.txt", "/b.txt")
+	return expectErr("HardLink to existing", err, vfs.EEXIST)
+}
+
+func checkRefLink(ctx context.Context, fs vfs.FileSystem) error {
+	if err := write(ctx, fs, "/a.txt", []byte("a")); err != nil {
+		return err
+	}
+	if err := fs.RefLink(ctx, "/a.txt", "/b.txt"); err != nil {
+		return err
+	}
+	if err := write(ctx, fs, "/b.txt", []byte("b")); err != nil {
+		return err
+	}
+	return expectContent(ctx, fs, "/a.txt", []byte("a"))
+}
+
+func checkTransactions(ctx context.Context, fs vfs.FileSystem) error {
+	// committing without a transaction is always wrong
+	err := fs.Commit(ctx)
+	if err == nil {
+		return fmt.Errorf("Commit: expected ETXINVALID without a transaction")
+	}
+	if err := expectErr("Commit", err, vfs.ETXINVALID); err != nil {
+		return err
+	}
+
+	txCtx, err := fs.Begin(ctx, "/", nil)
+	if err != nil {
+		return err
+	}
+	if err := write(txCtx, fs, "/a.txt", []byte("a")); err != nil {
+		return err
+	}
+	if err := fs.Rollback(txCtx); err != nil {
+		return err
+	}
+	_, err = fs.ReadAttrs(ctx, "/a.txt", nil)
+	if err := expectErr("ReadAttrs after Rollback", err, vfs.ENOENT); err != nil {
+		return err
+	}
+
+	txCtx, err = fs.Begin(ctx, "/", nil)
+	if err != nil {
+		return err
+	}
+	if err := write(txCtx, fs, "/b.txt", []byte("b")); err != nil {
+		return err
+	}
+	if err := fs.Commit(txCtx); err != nil {
+		return err
+	}
+	if err := expectContent(ctx, fs, "/b.txt", []byte("b")); err != nil {
+		return err
+	}
+	err = fs.Commit(txCtx)
+	return expectErr("Commit of a committed transaction", err, vfs.ETXINVALID)
+}
+
+func checkClose(ctx context.Context, fs vfs.FileSystem) error {
+	if err := fs.Close(); err != nil {
+		return err
+	}
+	// subsequent calls have no effect
+	if err := fs.Close(); err != nil {
+		return fmt.Errorf("second Close: %v", err)
+	}
+	return nil
+}
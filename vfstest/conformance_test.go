@@ -0,0 +1,125 @@
+package vfstest
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/worldiety/vfs"
+)
+
+func TestMemFS(t *testing.T) {
+	profile := RunConformance(t, func() vfs.FileSystem {
+		return &vfs.MemFS{}
+	})
+	for _, name := range []string{"Empty", "Write any", "Read any", "Write and Read", "Rename", "Attributes", "Close"} {
+		if profile.Result(name) != Supported {
+			t.Fatal("expected", name, "to be supported but got", profile.Result(name))
+		}
+	}
+	if profile.Result("Transactions") != Unsupported {
+		t.Fatal("expected unsupported transactions but got", profile.Result("Transactions"))
+	}
+	expectReadme(t, "MemFS", profile)
+	t.Log("\n" + profile.Markdown())
+}
+
+func TestOverlayFileSystem(t *testing.T) {
+	profile := RunConformance(t, func() vfs.FileSystem {
+		return &vfs.OverlayFileSystem{Upper: &vfs.MemFS{}, Lower: []vfs.FileSystem{&vfs.MemFS{}}}
+	})
+	t.Log("\n" + profile.Markdown())
+}
+
+func TestTxFileSystem(t *testing.T) {
+	profile := RunConformance(t, func() vfs.FileSystem {
+		return &vfs.TxFileSystem{Delegate: &vfs.MemFS{}}
+	})
+	if profile.Result("Transactions") != Supported {
+		t.Fatal("expected supported transactions but got", profile.Result("Transactions"))
+	}
+	t.Log("\n" + profile.Markdown())
+}
+
+func TestHTTPFileSystem(t *testing.T) {
+	var servers []*httptest.Server
+	defer func() {
+		for _, srv := range servers {
+			srv.Close()
+		}
+	}()
+	profile := RunConformance(t, func() vfs.FileSystem {
+		srv := httptest.NewServer(&vfs.HTTPHandler{FileSystem: &vfs.MemFS{}})
+		servers = append(servers, srv)
+		return &vfs.HTTPFileSystem{URL: srv.URL}
+	})
+	for _, name := range []string{"Empty", "Write any", "Read any", "Write and Read", "Random access", "ReadBucket", "MkBucket", "Delete", "Rename"} {
+		if profile.Result(name) != Supported {
+			t.Fatal("expected", name, "to be supported but got", profile.Result(name))
+		}
+	}
+	t.Log("\n" + profile.Markdown())
+}
+
+func TestCASFileSystem(t *testing.T) {
+	profile := RunConformance(t, func() vfs.FileSystem {
+		return &vfs.CASFileSystem{Dir: t.TempDir(), ChunkSize: 4}
+	})
+	for _, name := range []string{"Empty", "Write any", "Read any", "Write and Read", "Random access", "Rename", "HardLink", "RefLink", "Close"} {
+		if profile.Result(name) != Supported {
+			t.Fatal("expected", name, "to be supported but got", profile.Result(name))
+		}
+	}
+	expectReadme(t, "CASFileSystem", profile)
+	t.Log("\n" + profile.Markdown())
+}
+
+func TestCacheFileSystem(t *testing.T) {
+	profile := RunConformance(t, func() vfs.FileSystem {
+		return &vfs.CacheFileSystem{Delegate: &vfs.MemFS{}, BlobDir: t.TempDir()}
+	})
+	for _, name := range []string{"Empty", "Write any", "Read any", "Write and Read", "Random access", "ReadBucket", "Delete", "Rename", "RefLink"} {
+		if profile.Result(name) != Supported {
+			t.Fatal("expected", name, "to be supported but got", profile.Result(name))
+		}
+	}
+	t.Log("\n" + profile.Markdown())
+}
+
+func TestLocalFileSystem(t *testing.T) {
+	profile := RunConformance(t, func() vfs.FileSystem {
+		return &vfs.ChRoot{Prefix: vfs.Path(filepath.ToSlash(t.TempDir())), Delegate: vfs.LocalFileSystem}
+	})
+	for _, name := range []string{"Empty", "Write any", "Read any", "Write and Read", "Rename", "Attributes", "Close"} {
+		if profile.Result(name) != Supported {
+			t.Fatal("expected", name, "to be supported but got", profile.Result(name))
+		}
+	}
+	expectReadme(t, "FilesystemDataProvider", profile)
+	t.Log("\n" + profile.Markdown())
+}
+
+// expectReadme verifies, that the table of the section in the README is equal to the profile
+func expectReadme(t *testing.T, section string, profile *Profile) {
+	t.Helper()
+	buf, err := ioutil.ReadFile("../README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	readme := string(buf)
+	idx := strings.Index(readme, "\n## "+section+"\n")
+	if idx < 0 {
+		t.Fatal("expected the README section", section)
+	}
+	readme = readme[idx:]
+	start := strings.Index(readme, "| CTS Check")
+	end := strings.Index(readme[start:], "\n\n")
+	if start < 0 || end < 0 {
+		t.Fatal("expected a table in the README section", section)
+	}
+	if table := readme[start : start+end+1]; table != profile.Markdown() {
+		t.Fatal("expected the README table of", section, "\n"+profile.Markdown(), "\nbut got\n"+table)
+	}
+}
@@ -0,0 +1,173 @@
+package vfs
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"golang.org/x/net/webdav"
+)
+
+var _ webdav.FileSystem = (*WebDAVFileSystem)(nil)
+
+// A WebDAVFileSystem adapts a FileSystem to be served by a webdav.Handler, e.g.
+//
+//  handler := &webdav.Handler{
+//     FileSystem: &vfs.WebDAVFileSystem{FileSystem: myFs},
+//     LockSystem: webdav.NewMemLS(),
+//  }
+//
+// Details
+//
+//  * the error codes ENOENT and ENOTDIR are returned as os.ErrNotExist, EEXIST as os.ErrExist and EACCES, EPERM
+//    and EROFS as os.ErrPermission, so that the handler can respond with the correct status
+//  * Mkdir fails, if the bucket already exists or if the parent bucket is missing
+//  * buckets are opened as a File which only supports Readdir and Stat. Readdir reads all pages at once.
+type WebDAVFileSystem struct {
+	// FileSystem is the served FileSystem
+	FileSystem FileSystem
+}
+
+// osError translates the error into the os errors, which are understood e.g. by the webdav.Handler.
+func osError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case IsErr(err, ENOENT) || IsErr(err, ENOTDIR):
+		return os.ErrNotExist
+	case IsErr(err, EEXIST):
+		return os.ErrExist
+	case IsErr(err, EACCES) || IsErr(err, EPERM) || IsErr(err, EROFS):
+		return os.ErrPermission
+	default:
+		return err
+	}
+}
+
+func (w *WebDAVFileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	p := Path(name).Normalize()
+	if _, err := w.FileSystem.ReadAttrs(ctx, p.String(), nil); err == nil {
+		return os.ErrExist
+	}
+	if p.NameCount() > 1 {
+		parent, err := w.FileSystem.ReadAttrs(ctx, p.Parent().String(), nil)
+		if err != nil {
+			return osError(err)
+		}
+		if !parent.IsDir() {
+			return os.ErrNotExist
+		}
+	}
+	return osError(w.FileSystem.MkBucket(ctx, p.String(), perm))
+}
+
+// OpenFile opens a blob or a bucket. The perm is passed as the options of Open.
+func (w *WebDAVFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	p := Path(name).Normalize()
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE) == 0 {
+		entry, err := w.FileSystem.ReadAttrs(ctx, p.String(), nil)
+		if err != nil {
+			return nil, osError(err)
+		}
+		if entry.IsDir() {
+			return &webdavFile{fs: w.FileSystem, ctx: ctx, path: p}, nil
+		}
+	}
+	blob, err := w.FileSystem.Open(ctx, p.String(), flag, perm)
+	if err != nil {
+		return nil, osError(err)
+	}
+	return &webdavFile{fs: w.FileSystem, ctx: ctx, path: p, blob: blob}, nil
+}
+
+func (w *WebDAVFileSystem) RemoveAll(ctx context.Context, name string) error {
+	return osError(w.FileSystem.Delete(ctx, Path(name).Normalize().String()))
+}
+
+func (w *WebDAVFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	return osError(w.FileSystem.Rename(ctx, Path(oldName).Normalize().String(), Path(newName).Normalize().String()))
+}
+
+func (w *WebDAVFileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	entry, err := w.FileSystem.ReadAttrs(ctx, Path(name).Normalize().String(), nil)
+	if err != nil {
+		return nil, osError(err)
+	}
+	return entryDelegator{entry}, nil
+}
+
+// A webdavFile is either an opened blob or a bucket, which has no blob.
+type webdavFile struct {
+	fs      FileSystem
+	ctx     context.Context
+	path    Path
+	blob    Blob
+	entries []os.FileInfo // lazily loaded by Readdir
+	pos     int
+}
+
+func (f *webdavFile) Close() error {
+	if f.blob == nil {
+		return nil
+	}
+	return f.blob.Close()
+}
+
+func (f *webdavFile) Read(p []byte) (n int, err error) {
+	if f.blob == nil {
+		return 0, &DefaultError{Message: f.path.String(), Code: EISDIR, DetailsPayload: []string{f.path.String()}}
+	}
+	return f.blob.Read(p)
+}
+
+func (f *webdavFile) Seek(offset int64, whence int) (int64, error) {
+	if f.blob == nil {
+		return 0, &DefaultError{Message: f.path.String(), Code: EISDIR, DetailsPayload: []string{f.path.String()}}
+	}
+	return f.blob.Seek(offset, whence)
+}
+
+func (f *webdavFile) Write(p []byte) (n int, err error) {
+	if f.blob == nil {
+		return 0, &DefaultError{Message: f.path.String(), Code: EISDIR, DetailsPayload: []string{f.path.String()}}
+	}
+	return f.blob.Write(p)
+}
+
+// Readdir works like os.File.Readdir
+func (f *webdavFile) Readdir(count int) ([]os.FileInfo, error) {
+	if f.blob != nil {
+		return nil, &DefaultError{Message: f.path.String(), Code: ENOTDIR, DetailsPayload: []string{f.path.String()}}
+	}
+	if f.entries == nil {
+		entries, err := readEntries(f.ctx, f.fs, f.path.String(), nil)
+		if err != nil {
+			return nil, osError(err)
+		}
+		f.entries = make([]os.FileInfo, len(entries))
+		for i, entry := range entries {
+			f.entries[i] = entryDelegator{entry}
+		}
+	}
+	remaining := f.entries[f.pos:]
+	if count <= 0 {
+		f.pos = len(f.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(remaining) {
+		count = len(remaining)
+	}
+	f.pos += count
+	return remaining[:count], nil
+}
+
+func (f *webdavFile) Stat() (os.FileInfo, error) {
+	entry, err := f.fs.ReadAttrs(f.ctx, f.path.String(), nil)
+	if err != nil {
+		return nil, osError(err)
+	}
+	return entryDelegator{entry}, nil
+}
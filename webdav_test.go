@@ -0,0 +1,54 @@
+package vfs
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/webdav"
+)
+
+func TestWebDAVFileSystem(t *testing.T) {
+	mem := &MemFS{}
+	srv := httptest.NewServer(&webdav.Handler{FileSystem: &WebDAVFileSystem{FileSystem: mem}, LockSystem: webdav.NewMemLS()})
+	defer srv.Close()
+
+	if res, _ := httpDo(t, http.MethodPut, srv.URL+"/a.txt", "hello dav", nil); res.StatusCode != http.StatusCreated {
+		t.Fatal("expected 201 but got", res.StatusCode)
+	}
+	if str := memRead(t, mem, "/a.txt"); str != "hello dav" {
+		t.Fatal("expected hello dav but got", str)
+	}
+	if res, body := httpDo(t, http.MethodGet, srv.URL+"/a.txt", "", map[string]string{"Range": "bytes=6-"}); res.StatusCode != http.StatusPartialContent || body != "dav" {
+		t.Fatal("expected dav but got", res.StatusCode, body)
+	}
+	if res, _ := httpDo(t, http.MethodGet, srv.URL+"/missing.txt", "", nil); res.StatusCode != http.StatusNotFound {
+		t.Fatal("expected 404 but got", res.StatusCode)
+	}
+
+	if res, _ := httpDo(t, "MKCOL", srv.URL+"/docs", "", nil); res.StatusCode != http.StatusCreated {
+		t.Fatal("expected 201 but got", res.StatusCode)
+	}
+	if res, _ := httpDo(t, "MKCOL", srv.URL+"/docs", "", nil); res.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatal("expected 405 but got", res.StatusCode)
+	}
+	if res, _ := httpDo(t, "MKCOL", srv.URL+"/missing/docs", "", nil); res.StatusCode != http.StatusConflict {
+		t.Fatal("expected 409 but got", res.StatusCode)
+	}
+
+	if res, _ := httpDo(t, "MOVE", srv.URL+"/a.txt", "", map[string]string{"Destination": srv.URL + "/docs/b.txt"}); res.StatusCode != http.StatusCreated {
+		t.Fatal("expected 201 but got", res.StatusCode)
+	}
+	res, body := httpDo(t, "PROPFIND", srv.URL+"/docs", "", map[string]string{"Depth": "1"})
+	if res.StatusCode != http.StatusMultiStatus || !strings.Contains(body, "<D:href>/docs/b.txt</D:href>") || !strings.Contains(body, "<D:getcontentlength>9</D:getcontentlength>") {
+		t.Fatal("unexpected multi status", res.StatusCode, body)
+	}
+
+	if res, _ := httpDo(t, http.MethodDelete, srv.URL+"/docs", "", nil); res.StatusCode != http.StatusNoContent {
+		t.Fatal("expected 204 but got", res.StatusCode)
+	}
+	if res, _ := httpDo(t, "PROPFIND", srv.URL+"/docs", "", map[string]string{"Depth": "0"}); res.StatusCode != http.StatusNotFound {
+		t.Fatal("expected 404 but got", res.StatusCode)
+	}
+}